@@ -10,9 +10,28 @@ import (
 
 type Node interface {
 	TokenPos() token.Pos
+	// Pos and End return the source range of the node. End is the
+	// position immediately after the node's last token.
+	Pos() token.Pos
+	End() token.Pos
 	String() string
 }
 
+// Span is the source range of a node. It's embedded in every node and
+// filled in by the parser.
+type Span struct {
+	From token.Pos
+	To   token.Pos
+}
+
+func (s Span) Pos() token.Pos { return s.From }
+func (s Span) End() token.Pos { return s.To }
+
+func (s *Span) SetSpan(from, to token.Pos) {
+	s.From = from
+	s.To = to
+}
+
 type Statement interface {
 	Node
 	statementNode()
@@ -24,7 +43,10 @@ type Expression interface {
 }
 
 type Program struct {
+	Span
 	Statements []Statement
+	// Comments are in source order
+	Comments []*Comment
 }
 
 func (p *Program) String() string {
@@ -42,7 +64,19 @@ func (p *Program) TokenPos() token.Pos {
 	return p.Statements[0].TokenPos()
 }
 
+type Comment struct {
+	Span
+	Token token.Token
+	Text  string
+}
+
+func (c *Comment) String() string { return c.Text }
+func (c *Comment) TokenPos() token.Pos {
+	return c.Token.Pos
+}
+
 type PackageStatement struct {
+	Span
 	Token token.Token
 	Name  *Identifier
 }
@@ -57,6 +91,7 @@ func (p *PackageStatement) TokenPos() token.Pos {
 }
 
 type ImportStatement struct {
+	Span
 	Token   token.Token
 	Value   string
 	Program *Program
@@ -71,9 +106,16 @@ func (i *ImportStatement) TokenPos() token.Pos {
 }
 
 type Parameter struct {
+	Span
 	Token token.Token
 	Name  *Identifier
 	Type  *Identifier
+	// Pattern is set instead of Name when the argument is destructured
+	Pattern Expression
+	// Default is evaluated when the argument is missing
+	Default Expression
+	// Rest parameters collect the remaining arguments into an array
+	Rest bool
 }
 
 func (p *Parameter) expressionNode() {}
@@ -81,13 +123,26 @@ func (p *Parameter) TokenPos() token.Pos {
 	return p.Token.Pos
 }
 func (p *Parameter) String() string {
-	if p.Type != nil {
-		return fmt.Sprintf("%s: %s", p.Name, p.Type)
+	var s string
+	switch {
+	case p.Pattern != nil:
+		s = p.Pattern.String()
+	case p.Type != nil:
+		s = fmt.Sprintf("%s: %s", p.Name, p.Type)
+	default:
+		s = p.Name.Value
+	}
+	if p.Rest {
+		s = "..." + s
+	}
+	if p.Default != nil {
+		s = fmt.Sprintf("%s = %s", s, p.Default)
 	}
-	return p.Name.Value
+	return s
 }
 
 type Identifier struct {
+	Span
 	Token token.Token
 	Value string
 }
@@ -99,13 +154,19 @@ func (i *Identifier) TokenPos() token.Pos {
 }
 
 type LetStatement struct {
+	Span
 	Token token.Token
 	Name  *Identifier
 	Type  *Identifier
-	Value Expression
+	// Pattern is set instead of Name when the value is destructured
+	Pattern Expression
+	Value   Expression
 }
 
 func (l *LetStatement) String() string {
+	if l.Pattern != nil {
+		return fmt.Sprintf("let %s = %s;", l.Pattern, l.Value)
+	}
 	if l.Type != nil {
 		return fmt.Sprintf("let %s: %s = %s;", l.Name, l.Type, l.Value)
 	}
@@ -118,6 +179,7 @@ func (l *LetStatement) TokenPos() token.Pos {
 }
 
 type WhileStatement struct {
+	Span
 	Token     token.Token
 	Condition Expression
 	Body      *BlockStatement
@@ -132,7 +194,69 @@ func (w *WhileStatement) TokenPos() token.Pos {
 	return w.Token.Pos
 }
 
+// ForStatement loops over the elements of Iterable. Key is nil when
+// there's only one loop variable.
+type ForStatement struct {
+	Span
+	Token    token.Token
+	Key      *Identifier
+	Value    *Identifier
+	Iterable Expression
+	Body     *BlockStatement
+}
+
+func (f *ForStatement) String() string {
+	if f.Key != nil {
+		return fmt.Sprintf("for %s, %s in %s { %s}", f.Key, f.Value, f.Iterable, f.Body)
+	}
+	return fmt.Sprintf("for %s in %s { %s}", f.Value, f.Iterable, f.Body)
+}
+
+func (ForStatement) statementNode() {}
+func (f *ForStatement) TokenPos() token.Pos {
+	return f.Token.Pos
+}
+
+// BranchStatement is a break or continue. Label is nil when the
+// innermost loop is the target.
+type BranchStatement struct {
+	Span
+	Token token.Token
+	Label *Identifier
+}
+
+func (b *BranchStatement) String() string {
+	if b.Label != nil {
+		return fmt.Sprintf("%s %s", b.Token.Text, b.Label)
+	}
+	return b.Token.Text
+}
+
+func (BranchStatement) statementNode() {}
+func (b *BranchStatement) TokenPos() token.Pos {
+	return b.Token.Pos
+}
+
+// LabeledStatement names a loop so that branches in nested loops can
+// refer to it.
+type LabeledStatement struct {
+	Span
+	Token     token.Token
+	Label     *Identifier
+	Statement Statement
+}
+
+func (l *LabeledStatement) String() string {
+	return fmt.Sprintf("%s: %s", l.Label, l.Statement)
+}
+
+func (LabeledStatement) statementNode() {}
+func (l *LabeledStatement) TokenPos() token.Pos {
+	return l.Token.Pos
+}
+
 type ReturnStatement struct {
+	Span
 	Token       token.Token
 	ReturnValue Expression
 }
@@ -148,7 +272,70 @@ func (r *ReturnStatement) TokenPos() token.Pos {
 	return r.Token.Pos
 }
 
+// TryStatement runs Block and passes any error raised by it to Catch.
+// Param is nil when the catch block doesn't bind the error. Either of
+// Catch and Finally may be nil, but not both.
+type TryStatement struct {
+	Span
+	Token   token.Token
+	Block   *BlockStatement
+	Param   *Identifier
+	Catch   *BlockStatement
+	Finally *BlockStatement
+}
+
+func (t *TryStatement) String() string {
+	var out strings.Builder
+	fmt.Fprintf(&out, "try { %s}", t.Block)
+	if t.Catch != nil {
+		if t.Param != nil {
+			fmt.Fprintf(&out, " catch (%s) { %s}", t.Param, t.Catch)
+		} else {
+			fmt.Fprintf(&out, " catch { %s}", t.Catch)
+		}
+	}
+	if t.Finally != nil {
+		fmt.Fprintf(&out, " finally { %s}", t.Finally)
+	}
+	return out.String()
+}
+func (TryStatement) statementNode() {}
+func (t *TryStatement) TokenPos() token.Pos {
+	return t.Token.Pos
+}
+
+type ThrowStatement struct {
+	Span
+	Token token.Token
+	Value Expression
+}
+
+func (t *ThrowStatement) String() string {
+	return fmt.Sprintf("throw %s", t.Value)
+}
+func (ThrowStatement) statementNode() {}
+func (t *ThrowStatement) TokenPos() token.Pos {
+	return t.Token.Pos
+}
+
+// DeferStatement evaluates the function and arguments of Call and calls
+// it when the surrounding function returns.
+type DeferStatement struct {
+	Span
+	Token token.Token
+	Call  *CallExpression
+}
+
+func (d *DeferStatement) String() string {
+	return fmt.Sprintf("defer %s", d.Call)
+}
+func (DeferStatement) statementNode() {}
+func (d *DeferStatement) TokenPos() token.Pos {
+	return d.Token.Pos
+}
+
 type SwitchStatement struct {
+	Span
 	Token   token.Token
 	Value   Expression
 	Cases   []*CaseStatement
@@ -156,6 +343,7 @@ type SwitchStatement struct {
 }
 
 type CaseStatement struct {
+	Span
 	Token      token.Token
 	Value      Expression
 	Statements []Statement
@@ -170,6 +358,7 @@ func (s *SwitchStatement) TokenPos() token.Pos { return s.Token.Pos }
 func (s *SwitchStatement) String() string      { return "<switch>" }
 
 type ExpressionStatement struct {
+	Span
 	Token      token.Token
 	Expression Expression
 }
@@ -186,6 +375,7 @@ func (e *ExpressionStatement) TokenPos() token.Pos {
 }
 
 type DebuggerStatement struct {
+	Span
 	Token token.Token
 }
 
@@ -198,6 +388,7 @@ func (d *DebuggerStatement) TokenPos() token.Pos {
 }
 
 type IntegerLiteral struct {
+	Span
 	Token token.Token
 	Value int64
 }
@@ -211,14 +402,31 @@ func (i *IntegerLiteral) TokenPos() token.Pos {
 	return i.Token.Pos
 }
 
-type AssignmentExpression struct {
+type FloatLiteral struct {
+	Span
 	Token token.Token
-	Left  Expression
-	Value Expression
+	Value float64
+}
+
+func (f *FloatLiteral) String() string {
+	return strconv.FormatFloat(f.Value, 'g', -1, 64)
+}
+
+func (FloatLiteral) expressionNode() {}
+func (f *FloatLiteral) TokenPos() token.Pos {
+	return f.Token.Pos
+}
+
+type AssignmentExpression struct {
+	Span
+	Token    token.Token
+	Operator string
+	Left     Expression
+	Value    Expression
 }
 
 func (a *AssignmentExpression) String() string {
-	return fmt.Sprintf("%s = %s", a.Left, a.Value)
+	return fmt.Sprintf("%s %s %s", a.Left, a.Operator, a.Value)
 }
 
 func (AssignmentExpression) expressionNode() {}
@@ -227,6 +435,7 @@ func (a *AssignmentExpression) TokenPos() token.Pos {
 }
 
 type StringLiteral struct {
+	Span
 	Token token.Token
 	Value string
 }
@@ -239,7 +448,34 @@ func (s *StringLiteral) String() string {
 	return fmt.Sprintf("%q", s.Value)
 }
 
+type TemplateLiteral struct {
+	Span
+	Token  token.Token
+	Values []Expression
+	// Strings surround the values, so len(Strings) == len(Values)+1
+	Strings []string
+}
+
+func (TemplateLiteral) expressionNode() {}
+func (t *TemplateLiteral) TokenPos() token.Pos {
+	return t.Token.Pos
+}
+func (t *TemplateLiteral) String() string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i, s := range t.Strings {
+		quoted := strconv.Quote(s)
+		b.WriteString(strings.ReplaceAll(quoted[1:len(quoted)-1], "${", `\${`))
+		if i < len(t.Values) {
+			fmt.Fprintf(&b, "${%s}", t.Values[i])
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
 type PrefixExpression struct {
+	Span
 	Token    token.Token
 	Operator string
 	Right    Expression
@@ -253,7 +489,78 @@ func (p *PrefixExpression) TokenPos() token.Pos {
 	return p.Token.Pos
 }
 
+// ConditionalExpression is condition ? consequence : alternative.
+type ConditionalExpression struct {
+	Span
+	Token       token.Token
+	Condition   Expression
+	Consequence Expression
+	Alternative Expression
+}
+
+func (c *ConditionalExpression) String() string {
+	return fmt.Sprintf("(%s ? %s : %s)", c.Condition, c.Consequence, c.Alternative)
+}
+func (ConditionalExpression) expressionNode() {}
+func (c *ConditionalExpression) TokenPos() token.Pos {
+	return c.Token.Pos
+}
+
+// PipelineExpression is value |> fn(args), which is short for
+// fn(value, args). The right side may also be a function without
+// arguments.
+type PipelineExpression struct {
+	Span
+	Token token.Token
+	Left  Expression
+	Right Expression
+}
+
+func (p *PipelineExpression) String() string {
+	return fmt.Sprintf("(%s |> %s)", p.Left, p.Right)
+}
+func (PipelineExpression) expressionNode() {}
+func (p *PipelineExpression) TokenPos() token.Pos {
+	return p.Token.Pos
+}
+
+// Call returns the call which the pipeline is short for.
+func (p *PipelineExpression) Call() *CallExpression {
+	if call, ok := p.Right.(*CallExpression); ok {
+		return &CallExpression{
+			Span:      call.Span,
+			Token:     call.Token,
+			Function:  call.Function,
+			Arguments: append([]Expression{p.Left}, call.Arguments...),
+			Optional:  call.Optional,
+		}
+	}
+	return &CallExpression{
+		Span:      p.Span,
+		Token:     p.Token,
+		Function:  p.Right,
+		Arguments: []Expression{p.Left},
+	}
+}
+
+// SpreadExpression expands an array into the elements of an array
+// literal or the arguments of a call.
+type SpreadExpression struct {
+	Span
+	Token token.Token
+	Value Expression
+}
+
+func (s *SpreadExpression) String() string {
+	return "..." + s.Value.String()
+}
+func (SpreadExpression) expressionNode() {}
+func (s *SpreadExpression) TokenPos() token.Pos {
+	return s.Token.Pos
+}
+
 type InfixExpression struct {
+	Span
 	Token    token.Token
 	Operator string
 	Left     Expression
@@ -269,6 +576,7 @@ func (i *InfixExpression) TokenPos() token.Pos {
 }
 
 type NullExpression struct {
+	Span
 	Token token.Token
 }
 
@@ -281,6 +589,7 @@ func (n *NullExpression) TokenPos() token.Pos {
 }
 
 type BooleanExpression struct {
+	Span
 	Token token.Token
 	Value bool
 }
@@ -295,6 +604,7 @@ func (b *BooleanExpression) TokenPos() token.Pos {
 }
 
 type IfExpression struct {
+	Span
 	Token       token.Token
 	Condition   Expression
 	Concequence *BlockStatement
@@ -313,6 +623,7 @@ func (i *IfExpression) TokenPos() token.Pos {
 }
 
 type ArrayLiteral struct {
+	Span
 	Token    token.Token
 	Elements []Expression
 }
@@ -329,10 +640,58 @@ func (a *ArrayLiteral) String() string {
 	return fmt.Sprintf("[%s]", strings.Join(values, ", "))
 }
 
+// ArrayPattern destructures an array. Rest is set when the pattern
+// ends with ...rest.
+type ArrayPattern struct {
+	Span
+	Token    token.Token
+	Elements []Expression
+	Rest     Expression
+}
+
+func (ArrayPattern) expressionNode() {}
+func (a *ArrayPattern) TokenPos() token.Pos {
+	return a.Token.Pos
+}
+func (a *ArrayPattern) String() string {
+	var elems []string
+	for _, e := range a.Elements {
+		elems = append(elems, e.String())
+	}
+	if a.Rest != nil {
+		elems = append(elems, "..."+a.Rest.String())
+	}
+	return fmt.Sprintf("[%s]", strings.Join(elems, ", "))
+}
+
+// HashPattern destructures a hash. Identifier keys are the names of
+// entries, other keys are evaluated. Missing entries are null.
+type HashPattern struct {
+	Span
+	Token token.Token
+	Pairs []*HashPair
+}
+
+func (HashPattern) expressionNode() {}
+func (h *HashPattern) TokenPos() token.Pos {
+	return h.Token.Pos
+}
+func (h *HashPattern) String() string {
+	var pairs []string
+	for _, p := range h.Pairs {
+		pairs = append(pairs, p.String())
+	}
+	return fmt.Sprintf("{ %s }", strings.Join(pairs, ", "))
+}
+
 type IndexExpression struct {
+	Span
 	Token token.Token
 	Value Expression
 	Index Expression
+	// Optional is set for value?.[index], which is null without
+	// evaluating the index when the value is null
+	Optional bool
 }
 
 func (i *IndexExpression) expressionNode() {}
@@ -340,16 +699,55 @@ func (i *IndexExpression) TokenPos() token.Pos {
 	return i.Token.Pos
 }
 func (i *IndexExpression) String() string {
+	if i.Optional {
+		return fmt.Sprintf("%s?.[%s]", i.Value, i.Index)
+	}
 	return fmt.Sprintf("%s[%s]", i.Value, i.Index)
 }
 
+// SliceExpression is value[low:high], either bound may be nil.
+type SliceExpression struct {
+	Span
+	Token token.Token
+	Value Expression
+	Low   Expression
+	High  Expression
+	// Optional is set for value?.[low:high]
+	Optional bool
+}
+
+func (SliceExpression) expressionNode() {}
+func (s *SliceExpression) TokenPos() token.Pos {
+	return s.Token.Pos
+}
+func (s *SliceExpression) String() string {
+	var low, high string
+	if s.Low != nil {
+		low = s.Low.String()
+	}
+	if s.High != nil {
+		high = s.High.String()
+	}
+	if s.Optional {
+		return fmt.Sprintf("%s?.[%s:%s]", s.Value, low, high)
+	}
+	return fmt.Sprintf("%s[%s:%s]", s.Value, low, high)
+}
+
 type PropertyExpression struct {
+	Span
 	Token token.Token
 	Value Expression
 	Name  *Identifier
+	// Optional is set for value?.name, which is null when the value is
+	// null or doesn't have the property
+	Optional bool
 }
 
 func (p *PropertyExpression) String() string {
+	if p.Optional {
+		return fmt.Sprintf("%s?.%s", p.Value, p.Name.Value)
+	}
 	return fmt.Sprintf("%s.%s", p.Value, p.Name.Value)
 }
 
@@ -359,6 +757,7 @@ func (p *PropertyExpression) TokenPos() token.Pos {
 }
 
 type BlockStatement struct {
+	Span
 	Token      token.Token
 	Statements []Statement
 }
@@ -376,6 +775,7 @@ func (b *BlockStatement) TokenPos() token.Pos {
 }
 
 type FunctionLiteral struct {
+	Span
 	Token      token.Token
 	Parameters []*Parameter
 	ReturnType *Identifier
@@ -385,7 +785,11 @@ type FunctionLiteral struct {
 func (f *FunctionLiteral) ParameterNames() []string {
 	var names []string
 	for _, p := range f.Parameters {
-		names = append(names, p.Name.Value)
+		if p.Pattern != nil {
+			names = append(names, p.Pattern.String())
+		} else {
+			names = append(names, p.Name.Value)
+		}
 	}
 	return names
 }
@@ -399,6 +803,7 @@ func (f *FunctionLiteral) TokenPos() token.Pos {
 }
 
 type FunctionStatement struct {
+	Span
 	Token      token.Token
 	Name       *Identifier
 	Parameters []*Parameter
@@ -409,7 +814,11 @@ type FunctionStatement struct {
 func (f *FunctionStatement) ParameterNames() []string {
 	var names []string
 	for _, p := range f.Parameters {
-		names = append(names, p.Name.Value)
+		if p.Pattern != nil {
+			names = append(names, p.Pattern.String())
+		} else {
+			names = append(names, p.Name.Value)
+		}
 	}
 	return names
 }
@@ -428,9 +837,13 @@ func (f *FunctionStatement) TokenPos() token.Pos {
 }
 
 type CallExpression struct {
+	Span
 	Token     token.Token
 	Function  Expression
 	Arguments []Expression
+	// Optional is set for fn?.(args), which is null without evaluating
+	// the arguments when the function is null
+	Optional bool
 }
 
 func (c *CallExpression) String() string {
@@ -438,6 +851,9 @@ func (c *CallExpression) String() string {
 	for _, a := range c.Arguments {
 		args = append(args, a.String())
 	}
+	if c.Optional {
+		return fmt.Sprintf("%s?.(%s)", c.Function, strings.Join(args, ", "))
+	}
 	return fmt.Sprintf("%s(%s)", c.Function, strings.Join(args, ", "))
 }
 func (CallExpression) expressionNode() {}
@@ -446,6 +862,7 @@ func (c *CallExpression) TokenPos() token.Pos {
 }
 
 type HashLiteral struct {
+	Span
 	Token token.Token
 	Pairs []*HashPair
 }
@@ -0,0 +1,332 @@
+// Package json converts syntax trees to and from JSON.
+//
+// Each node is encoded as an object with a "kind" discriminator naming
+// its type, its "start" and "end" positions, its "token", and one member
+// for each of its fields. Field names are the ast field names with a
+// lower case first letter. Nil nodes and slices are encoded as null.
+package json
+
+import (
+	"bytes"
+	stdjson "encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/icholy/monkey/ast"
+	"github.com/icholy/monkey/token"
+)
+
+var kinds = map[string]reflect.Type{}
+
+func init() {
+	for _, n := range []ast.Node{
+		&ast.Program{},
+		&ast.Comment{},
+		&ast.PackageStatement{},
+		&ast.ImportStatement{},
+		&ast.Parameter{},
+		&ast.Identifier{},
+		&ast.LetStatement{},
+		&ast.WhileStatement{},
+		&ast.ForStatement{},
+		&ast.BranchStatement{},
+		&ast.LabeledStatement{},
+		&ast.ReturnStatement{},
+		&ast.TryStatement{},
+		&ast.ThrowStatement{},
+		&ast.DeferStatement{},
+		&ast.SwitchStatement{},
+		&ast.CaseStatement{},
+		&ast.ExpressionStatement{},
+		&ast.DebuggerStatement{},
+		&ast.IntegerLiteral{},
+		&ast.FloatLiteral{},
+		&ast.AssignmentExpression{},
+		&ast.StringLiteral{},
+		&ast.TemplateLiteral{},
+		&ast.PrefixExpression{},
+		&ast.SpreadExpression{},
+		&ast.ConditionalExpression{},
+		&ast.PipelineExpression{},
+		&ast.InfixExpression{},
+		&ast.NullExpression{},
+		&ast.BooleanExpression{},
+		&ast.IfExpression{},
+		&ast.ArrayLiteral{},
+		&ast.ArrayPattern{},
+		&ast.HashPattern{},
+		&ast.IndexExpression{},
+		&ast.SliceExpression{},
+		&ast.PropertyExpression{},
+		&ast.BlockStatement{},
+		&ast.FunctionLiteral{},
+		&ast.FunctionStatement{},
+		&ast.CallExpression{},
+		&ast.HashLiteral{},
+	} {
+		t := reflect.TypeOf(n).Elem()
+		kinds[t.Name()] = t
+	}
+}
+
+var (
+	spanType  = reflect.TypeOf(ast.Span{})
+	tokenType = reflect.TypeOf(token.Token{})
+	nodeType  = reflect.TypeOf((*ast.Node)(nil)).Elem()
+)
+
+type jsonToken struct {
+	Type  token.TokenType `json:"type"`
+	Text  string          `json:"text"`
+	Start token.Pos       `json:"start"`
+	End   token.Pos       `json:"end"`
+}
+
+// Encode returns the JSON encoding of node.
+func Encode(node ast.Node) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := encode(&buf, reflect.ValueOf(&node).Elem()); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decode returns the node encoded in data.
+func Decode(data []byte) (ast.Node, error) {
+	v, err := decode(data, nodeType)
+	if err != nil {
+		return nil, err
+	}
+	node, _ := v.Interface().(ast.Node)
+	return node, nil
+}
+
+func encode(buf *bytes.Buffer, v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Interface, reflect.Ptr:
+		if v.IsNil() {
+			buf.WriteString("null")
+			return nil
+		}
+		if v.Kind() == reflect.Interface {
+			return encode(buf, v.Elem())
+		}
+		if v.Type().Implements(nodeType) {
+			return encodeNode(buf, v)
+		}
+		buf.WriteByte('{')
+		err := encodeFields(buf, v.Elem(), false)
+		buf.WriteByte('}')
+		return err
+	case reflect.Slice:
+		if v.IsNil() {
+			buf.WriteString("null")
+			return nil
+		}
+		buf.WriteByte('[')
+		for i := 0; i < v.Len(); i++ {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := encode(buf, v.Index(i)); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+		return nil
+	case reflect.Struct:
+		if v.Type() == tokenType {
+			tok := v.Interface().(token.Token)
+			return marshal(buf, jsonToken{
+				Type:  tok.Type,
+				Text:  tok.Text,
+				Start: tok.Pos,
+				End:   tok.End,
+			})
+		}
+		return marshal(buf, v.Interface())
+	default:
+		return marshal(buf, v.Interface())
+	}
+}
+
+func encodeNode(buf *bytes.Buffer, v reflect.Value) error {
+	buf.WriteString(`{"kind":`)
+	if err := marshal(buf, v.Elem().Type().Name()); err != nil {
+		return err
+	}
+	err := encodeFields(buf, v.Elem(), true)
+	buf.WriteByte('}')
+	return err
+}
+
+func encodeFields(buf *bytes.Buffer, v reflect.Value, comma bool) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if comma {
+			buf.WriteByte(',')
+		}
+		comma = true
+		if f.Anonymous && f.Type == spanType {
+			span := v.Field(i).Interface().(ast.Span)
+			buf.WriteString(`"start":`)
+			if err := marshal(buf, span.From); err != nil {
+				return err
+			}
+			buf.WriteString(`,"end":`)
+			if err := marshal(buf, span.To); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := marshal(buf, fieldName(f)); err != nil {
+			return err
+		}
+		buf.WriteByte(':')
+		if err := encode(buf, v.Field(i)); err != nil {
+			return fmt.Errorf("%s.%s: %w", t.Name(), f.Name, err)
+		}
+	}
+	return nil
+}
+
+func marshal(buf *bytes.Buffer, v interface{}) error {
+	data, err := stdjson.Marshal(v)
+	if err != nil {
+		return err
+	}
+	buf.Write(data)
+	return nil
+}
+
+func decode(data []byte, t reflect.Type) (reflect.Value, error) {
+	v := reflect.New(t).Elem()
+	if bytes.Equal(bytes.TrimSpace(data), []byte("null")) {
+		return v, nil
+	}
+	switch t.Kind() {
+	case reflect.Interface:
+		n, err := decodeNode(data)
+		if err != nil {
+			return v, err
+		}
+		if !n.Type().Implements(t) {
+			return v, fmt.Errorf("%s is not a %s", n.Elem().Type().Name(), t.Name())
+		}
+		v.Set(n)
+		return v, nil
+	case reflect.Ptr:
+		if t.Implements(nodeType) {
+			n, err := decodeNode(data)
+			if err != nil {
+				return v, err
+			}
+			if n.Type() != t {
+				return v, fmt.Errorf("%s is not a %s", n.Elem().Type().Name(), t.Elem().Name())
+			}
+			return n, nil
+		}
+		var obj map[string]stdjson.RawMessage
+		if err := stdjson.Unmarshal(data, &obj); err != nil {
+			return v, err
+		}
+		v.Set(reflect.New(t.Elem()))
+		return v, decodeFields(obj, v.Elem())
+	case reflect.Slice:
+		var elems []stdjson.RawMessage
+		if err := stdjson.Unmarshal(data, &elems); err != nil {
+			return v, err
+		}
+		v.Set(reflect.MakeSlice(t, len(elems), len(elems)))
+		for i, data := range elems {
+			e, err := decode(data, t.Elem())
+			if err != nil {
+				return v, err
+			}
+			v.Index(i).Set(e)
+		}
+		return v, nil
+	default:
+		if t == tokenType {
+			var tok jsonToken
+			if err := stdjson.Unmarshal(data, &tok); err != nil {
+				return v, err
+			}
+			v.Set(reflect.ValueOf(token.Token{
+				Pos:  tok.Start,
+				End:  tok.End,
+				Type: tok.Type,
+				Text: tok.Text,
+			}))
+			return v, nil
+		}
+		return v, stdjson.Unmarshal(data, v.Addr().Interface())
+	}
+}
+
+func decodeNode(data []byte) (reflect.Value, error) {
+	var obj map[string]stdjson.RawMessage
+	if err := stdjson.Unmarshal(data, &obj); err != nil {
+		return reflect.Value{}, err
+	}
+	data, ok := obj["kind"]
+	if !ok {
+		return reflect.Value{}, errors.New("missing node kind")
+	}
+	var kind string
+	if err := stdjson.Unmarshal(data, &kind); err != nil {
+		return reflect.Value{}, fmt.Errorf("invalid node kind: %s", data)
+	}
+	t, ok := kinds[kind]
+	if !ok {
+		return reflect.Value{}, fmt.Errorf("unknown node kind: %q", kind)
+	}
+	v := reflect.New(t)
+	if err := decodeFields(obj, v.Elem()); err != nil {
+		return reflect.Value{}, fmt.Errorf("%s: %w", kind, err)
+	}
+	return v, nil
+}
+
+// decodeFields sets the fields of the struct v from obj. Missing fields
+// are left as the zero value.
+func decodeFields(obj map[string]stdjson.RawMessage, v reflect.Value) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Anonymous && f.Type == spanType {
+			var span ast.Span
+			if data, ok := obj["start"]; ok {
+				if err := stdjson.Unmarshal(data, &span.From); err != nil {
+					return err
+				}
+			}
+			if data, ok := obj["end"]; ok {
+				if err := stdjson.Unmarshal(data, &span.To); err != nil {
+					return err
+				}
+			}
+			v.Field(i).Set(reflect.ValueOf(span))
+			continue
+		}
+		data, ok := obj[fieldName(f)]
+		if !ok {
+			continue
+		}
+		fv, err := decode(data, f.Type)
+		if err != nil {
+			return fmt.Errorf("%s: %w", fieldName(f), err)
+		}
+		v.Field(i).Set(fv)
+	}
+	return nil
+}
+
+func fieldName(f reflect.StructField) string {
+	r, n := utf8.DecodeRuneInString(f.Name)
+	return string(unicode.ToLower(r)) + f.Name[n:]
+}
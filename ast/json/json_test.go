@@ -0,0 +1,100 @@
+package json
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/icholy/monkey/ast"
+	"github.com/icholy/monkey/parser"
+)
+
+func TestRoundTrip(t *testing.T) {
+	input := `
+		package main
+		import "lib.monkey"
+		// comment
+		let add = fn(a: int, b): int { return a + b; };
+		function f(x) { x["k"].y = -1.5 }
+		while add(1, 2) > 0 { "${add}" }
+		switch 1 { case 2: {3: null}; default: if (true) { [4] } else { !false } }
+		for k, v in {"a": 1} { v } for x in "abc" {}
+		a: while x { break a; continue }
+		let [p, ...q] = fn({s, "t": [u]}, v = 1, ...w) {}; [p, ...q] = [...q];
+		q[1:]; q[:-1]; q[:]; q?.a?.[0]?.() ?? q?.[1:];
+		p ? q : r ? s : t; x |> f(1) |> g;
+		let h = (a, [b] = [], ...c) => a; h = x => { x };
+		try { throw h } catch (e) { e } finally {} try {} catch {}
+		fn() { defer h(1, ...x) };
+		x += 0x10; debugger; return;
+	`
+	program, err := parser.Parse(input)
+	require.NoError(t, err)
+	data, err := Encode(program)
+	require.NoError(t, err)
+	node, err := Decode(data)
+	require.NoError(t, err)
+	require.Equal(t, program, node)
+}
+
+func TestEncode(t *testing.T) {
+	program, err := parser.Parse("-x")
+	require.NoError(t, err)
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	data, err := Encode(stmt.Expression)
+	require.NoError(t, err)
+	require.JSONEq(t, `{
+		"kind": "PrefixExpression",
+		"start": {"line": 1, "offset": 1, "byte": 0},
+		"end": {"line": 1, "offset": 3, "byte": 2},
+		"token": {
+			"type": "MINUS",
+			"text": "-",
+			"start": {"line": 1, "offset": 1, "byte": 0},
+			"end": {"line": 1, "offset": 2, "byte": 1}
+		},
+		"operator": "-",
+		"right": {
+			"kind": "Identifier",
+			"start": {"line": 1, "offset": 2, "byte": 1},
+			"end": {"line": 1, "offset": 3, "byte": 2},
+			"token": {
+				"type": "IDENT",
+				"text": "x",
+				"start": {"line": 1, "offset": 2, "byte": 1},
+				"end": {"line": 1, "offset": 3, "byte": 2}
+			},
+			"value": "x"
+		}
+	}`, string(data))
+}
+
+func TestDecode(t *testing.T) {
+	node, err := Decode([]byte(`{"kind": "Identifier", "value": "x"}`))
+	require.NoError(t, err)
+	require.Equal(t, &ast.Identifier{Value: "x"}, node)
+
+	node, err = Decode([]byte(`null`))
+	require.NoError(t, err)
+	require.Nil(t, node)
+}
+
+func TestDecodeError(t *testing.T) {
+	tests := []struct {
+		input   string
+		message string
+	}{
+		{`{"kind": "Nope"}`, `unknown node kind: "Nope"`},
+		{`{"value": "x"}`, `missing node kind`},
+		{`{"kind": 1}`, `invalid node kind: 1`},
+		{`{"kind": "ExpressionStatement", "expression": {"kind": "DebuggerStatement"}}`, `ExpressionStatement: expression: DebuggerStatement is not a Expression`},
+		{`{"kind": "LetStatement", "name": {"kind": "NullExpression"}}`, `LetStatement: name: NullExpression is not a Identifier`},
+		{`[1]`, `cannot unmarshal array`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			_, err := Decode([]byte(tt.input))
+			require.ErrorContains(t, err, tt.message)
+		})
+	}
+}
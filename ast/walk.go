@@ -0,0 +1,517 @@
+package ast
+
+import "fmt"
+
+// A Visitor's Visit method is invoked for each node encountered by Walk.
+// If the result visitor w is not nil, Walk visits each of the children
+// of node with the visitor w, followed by a call of w.Visit(nil).
+type Visitor interface {
+	Visit(node Node) (w Visitor)
+}
+
+// Walk traverses an AST in depth-first order. Imported programs are
+// walked once they have been loaded.
+func Walk(v Visitor, node Node) {
+	if v = v.Visit(node); v == nil {
+		return
+	}
+
+	switch n := node.(type) {
+	case *Program:
+		walkStmts(v, n.Statements)
+	case *PackageStatement:
+		if n.Name != nil {
+			Walk(v, n.Name)
+		}
+	case *ImportStatement:
+		if n.Program != nil {
+			Walk(v, n.Program)
+		}
+	case *Parameter:
+		if n.Name != nil {
+			Walk(v, n.Name)
+		}
+		if n.Type != nil {
+			Walk(v, n.Type)
+		}
+		if n.Pattern != nil {
+			Walk(v, n.Pattern)
+		}
+		if n.Default != nil {
+			Walk(v, n.Default)
+		}
+	case *LetStatement:
+		if n.Name != nil {
+			Walk(v, n.Name)
+		}
+		if n.Type != nil {
+			Walk(v, n.Type)
+		}
+		if n.Pattern != nil {
+			Walk(v, n.Pattern)
+		}
+		if n.Value != nil {
+			Walk(v, n.Value)
+		}
+	case *WhileStatement:
+		if n.Condition != nil {
+			Walk(v, n.Condition)
+		}
+		if n.Body != nil {
+			Walk(v, n.Body)
+		}
+	case *TryStatement:
+		if n.Block != nil {
+			Walk(v, n.Block)
+		}
+		if n.Param != nil {
+			Walk(v, n.Param)
+		}
+		if n.Catch != nil {
+			Walk(v, n.Catch)
+		}
+		if n.Finally != nil {
+			Walk(v, n.Finally)
+		}
+	case *ThrowStatement:
+		if n.Value != nil {
+			Walk(v, n.Value)
+		}
+	case *DeferStatement:
+		if n.Call != nil {
+			Walk(v, n.Call)
+		}
+	case *ForStatement:
+		if n.Key != nil {
+			Walk(v, n.Key)
+		}
+		if n.Value != nil {
+			Walk(v, n.Value)
+		}
+		if n.Iterable != nil {
+			Walk(v, n.Iterable)
+		}
+		if n.Body != nil {
+			Walk(v, n.Body)
+		}
+	case *BranchStatement:
+		if n.Label != nil {
+			Walk(v, n.Label)
+		}
+	case *LabeledStatement:
+		if n.Label != nil {
+			Walk(v, n.Label)
+		}
+		if n.Statement != nil {
+			Walk(v, n.Statement)
+		}
+	case *ReturnStatement:
+		if n.ReturnValue != nil {
+			Walk(v, n.ReturnValue)
+		}
+	case *SwitchStatement:
+		if n.Value != nil {
+			Walk(v, n.Value)
+		}
+		for _, c := range n.Cases {
+			Walk(v, c)
+		}
+		walkStmts(v, n.Default)
+	case *CaseStatement:
+		if n.Value != nil {
+			Walk(v, n.Value)
+		}
+		walkStmts(v, n.Statements)
+	case *ExpressionStatement:
+		if n.Expression != nil {
+			Walk(v, n.Expression)
+		}
+	case *AssignmentExpression:
+		if n.Left != nil {
+			Walk(v, n.Left)
+		}
+		if n.Value != nil {
+			Walk(v, n.Value)
+		}
+	case *TemplateLiteral:
+		walkExprs(v, n.Values)
+	case *PrefixExpression:
+		if n.Right != nil {
+			Walk(v, n.Right)
+		}
+	case *SpreadExpression:
+		if n.Value != nil {
+			Walk(v, n.Value)
+		}
+	case *ConditionalExpression:
+		if n.Condition != nil {
+			Walk(v, n.Condition)
+		}
+		if n.Consequence != nil {
+			Walk(v, n.Consequence)
+		}
+		if n.Alternative != nil {
+			Walk(v, n.Alternative)
+		}
+	case *PipelineExpression:
+		if n.Left != nil {
+			Walk(v, n.Left)
+		}
+		if n.Right != nil {
+			Walk(v, n.Right)
+		}
+	case *InfixExpression:
+		if n.Left != nil {
+			Walk(v, n.Left)
+		}
+		if n.Right != nil {
+			Walk(v, n.Right)
+		}
+	case *IfExpression:
+		if n.Condition != nil {
+			Walk(v, n.Condition)
+		}
+		if n.Concequence != nil {
+			Walk(v, n.Concequence)
+		}
+		if n.Alternative != nil {
+			Walk(v, n.Alternative)
+		}
+	case *ArrayLiteral:
+		walkExprs(v, n.Elements)
+	case *ArrayPattern:
+		walkExprs(v, n.Elements)
+		if n.Rest != nil {
+			Walk(v, n.Rest)
+		}
+	case *IndexExpression:
+		if n.Value != nil {
+			Walk(v, n.Value)
+		}
+		if n.Index != nil {
+			Walk(v, n.Index)
+		}
+	case *SliceExpression:
+		if n.Value != nil {
+			Walk(v, n.Value)
+		}
+		if n.Low != nil {
+			Walk(v, n.Low)
+		}
+		if n.High != nil {
+			Walk(v, n.High)
+		}
+	case *PropertyExpression:
+		if n.Value != nil {
+			Walk(v, n.Value)
+		}
+		if n.Name != nil {
+			Walk(v, n.Name)
+		}
+	case *BlockStatement:
+		walkStmts(v, n.Statements)
+	case *FunctionLiteral:
+		for _, p := range n.Parameters {
+			Walk(v, p)
+		}
+		if n.ReturnType != nil {
+			Walk(v, n.ReturnType)
+		}
+		if n.Body != nil {
+			Walk(v, n.Body)
+		}
+	case *FunctionStatement:
+		if n.Name != nil {
+			Walk(v, n.Name)
+		}
+		for _, p := range n.Parameters {
+			Walk(v, p)
+		}
+		if n.ReturnType != nil {
+			Walk(v, n.ReturnType)
+		}
+		if n.Body != nil {
+			Walk(v, n.Body)
+		}
+	case *CallExpression:
+		if n.Function != nil {
+			Walk(v, n.Function)
+		}
+		walkExprs(v, n.Arguments)
+	case *HashLiteral, *HashPattern:
+		var pairs []*HashPair
+		if h, ok := n.(*HashLiteral); ok {
+			pairs = h.Pairs
+		} else {
+			pairs = n.(*HashPattern).Pairs
+		}
+		for _, p := range pairs {
+			if p.Key != nil {
+				Walk(v, p.Key)
+			}
+			if p.Value != nil {
+				Walk(v, p.Value)
+			}
+		}
+	case *Identifier, *IntegerLiteral, *FloatLiteral, *StringLiteral,
+		*NullExpression, *BooleanExpression, *DebuggerStatement, *Comment:
+		// leaf nodes
+	default:
+		panic(fmt.Sprintf("ast.Walk: unexpected node type %T", n))
+	}
+
+	v.Visit(nil)
+}
+
+func walkStmts(v Visitor, list []Statement) {
+	for _, s := range list {
+		Walk(v, s)
+	}
+}
+
+func walkExprs(v Visitor, list []Expression) {
+	for _, e := range list {
+		Walk(v, e)
+	}
+}
+
+type inspector func(Node) bool
+
+func (f inspector) Visit(node Node) Visitor {
+	if f(node) {
+		return f
+	}
+	return nil
+}
+
+// Inspect traverses an AST in depth-first order. It starts by calling
+// f(node); if f returns true, Inspect invokes f recursively for each of
+// the non-nil children of node, followed by a call of f(nil).
+func Inspect(node Node, f func(Node) bool) {
+	Walk(inspector(f), node)
+}
+
+// Rewrite traverses an AST in depth-first order and replaces each node
+// with the result of f, which is called after the node's children have
+// been rewritten. If f returns nil for an element of a statement, case
+// or parameter list, the element is removed. Rewrite panics if f returns a node which
+// cannot be stored in the parent, such as a Statement where an
+// Expression is expected.
+func Rewrite(node Node, f func(Node) Node) Node {
+	switch n := node.(type) {
+	case *Program:
+		n.Statements = rewriteStmts(n.Statements, f)
+	case *PackageStatement:
+		n.Name = rewriteIdent(n.Name, f)
+	case *ImportStatement:
+		n.Program = rewriteProgram(n.Program, f)
+	case *Parameter:
+		n.Name = rewriteIdent(n.Name, f)
+		n.Type = rewriteIdent(n.Type, f)
+		n.Pattern = rewriteExpr(n.Pattern, f)
+		n.Default = rewriteExpr(n.Default, f)
+	case *LetStatement:
+		n.Name = rewriteIdent(n.Name, f)
+		n.Type = rewriteIdent(n.Type, f)
+		n.Pattern = rewriteExpr(n.Pattern, f)
+		n.Value = rewriteExpr(n.Value, f)
+	case *WhileStatement:
+		n.Condition = rewriteExpr(n.Condition, f)
+		n.Body = rewriteBlock(n.Body, f)
+	case *TryStatement:
+		n.Block = rewriteBlock(n.Block, f)
+		n.Param = rewriteIdent(n.Param, f)
+		n.Catch = rewriteBlock(n.Catch, f)
+		n.Finally = rewriteBlock(n.Finally, f)
+	case *ThrowStatement:
+		n.Value = rewriteExpr(n.Value, f)
+	case *DeferStatement:
+		n.Call = rewriteCall(n.Call, f)
+	case *ForStatement:
+		n.Key = rewriteIdent(n.Key, f)
+		n.Value = rewriteIdent(n.Value, f)
+		n.Iterable = rewriteExpr(n.Iterable, f)
+		n.Body = rewriteBlock(n.Body, f)
+	case *BranchStatement:
+		n.Label = rewriteIdent(n.Label, f)
+	case *LabeledStatement:
+		n.Label = rewriteIdent(n.Label, f)
+		n.Statement = rewriteStmt(n.Statement, f)
+	case *ReturnStatement:
+		n.ReturnValue = rewriteExpr(n.ReturnValue, f)
+	case *SwitchStatement:
+		n.Value = rewriteExpr(n.Value, f)
+		n.Cases = rewriteCases(n.Cases, f)
+		n.Default = rewriteStmts(n.Default, f)
+	case *CaseStatement:
+		n.Value = rewriteExpr(n.Value, f)
+		n.Statements = rewriteStmts(n.Statements, f)
+	case *ExpressionStatement:
+		n.Expression = rewriteExpr(n.Expression, f)
+	case *AssignmentExpression:
+		n.Left = rewriteExpr(n.Left, f)
+		n.Value = rewriteExpr(n.Value, f)
+	case *TemplateLiteral:
+		rewriteExprs(n.Values, f)
+	case *PrefixExpression:
+		n.Right = rewriteExpr(n.Right, f)
+	case *SpreadExpression:
+		n.Value = rewriteExpr(n.Value, f)
+	case *ConditionalExpression:
+		n.Condition = rewriteExpr(n.Condition, f)
+		n.Consequence = rewriteExpr(n.Consequence, f)
+		n.Alternative = rewriteExpr(n.Alternative, f)
+	case *PipelineExpression:
+		n.Left = rewriteExpr(n.Left, f)
+		n.Right = rewriteExpr(n.Right, f)
+	case *InfixExpression:
+		n.Left = rewriteExpr(n.Left, f)
+		n.Right = rewriteExpr(n.Right, f)
+	case *IfExpression:
+		n.Condition = rewriteExpr(n.Condition, f)
+		n.Concequence = rewriteBlock(n.Concequence, f)
+		n.Alternative = rewriteBlock(n.Alternative, f)
+	case *ArrayLiteral:
+		rewriteExprs(n.Elements, f)
+	case *ArrayPattern:
+		rewriteExprs(n.Elements, f)
+		n.Rest = rewriteExpr(n.Rest, f)
+	case *IndexExpression:
+		n.Value = rewriteExpr(n.Value, f)
+		n.Index = rewriteExpr(n.Index, f)
+	case *SliceExpression:
+		n.Value = rewriteExpr(n.Value, f)
+		n.Low = rewriteExpr(n.Low, f)
+		n.High = rewriteExpr(n.High, f)
+	case *PropertyExpression:
+		n.Value = rewriteExpr(n.Value, f)
+		n.Name = rewriteIdent(n.Name, f)
+	case *BlockStatement:
+		n.Statements = rewriteStmts(n.Statements, f)
+	case *FunctionLiteral:
+		n.Parameters = rewriteParams(n.Parameters, f)
+		n.ReturnType = rewriteIdent(n.ReturnType, f)
+		n.Body = rewriteBlock(n.Body, f)
+	case *FunctionStatement:
+		n.Name = rewriteIdent(n.Name, f)
+		n.Parameters = rewriteParams(n.Parameters, f)
+		n.ReturnType = rewriteIdent(n.ReturnType, f)
+		n.Body = rewriteBlock(n.Body, f)
+	case *CallExpression:
+		n.Function = rewriteExpr(n.Function, f)
+		rewriteExprs(n.Arguments, f)
+	case *HashLiteral:
+		rewritePairs(n.Pairs, f)
+	case *HashPattern:
+		rewritePairs(n.Pairs, f)
+	case *Identifier, *IntegerLiteral, *FloatLiteral, *StringLiteral,
+		*NullExpression, *BooleanExpression, *DebuggerStatement, *Comment:
+		// leaf nodes
+	default:
+		panic(fmt.Sprintf("ast.Rewrite: unexpected node type %T", n))
+	}
+	return f(node)
+}
+
+func rewriteStmts(list []Statement, f func(Node) Node) []Statement {
+	var out []Statement
+	for _, s := range list {
+		if s := Rewrite(s, f); s != nil {
+			out = append(out, s.(Statement))
+		}
+	}
+	return out
+}
+
+func rewriteStmt(s Statement, f func(Node) Node) Statement {
+	if s == nil {
+		return nil
+	}
+	if n := Rewrite(s, f); n != nil {
+		return n.(Statement)
+	}
+	return nil
+}
+
+func rewriteExprs(list []Expression, f func(Node) Node) {
+	for i, e := range list {
+		list[i] = rewriteExpr(e, f)
+	}
+}
+
+func rewritePairs(list []*HashPair, f func(Node) Node) {
+	for _, p := range list {
+		p.Key = rewriteExpr(p.Key, f)
+		p.Value = rewriteExpr(p.Value, f)
+	}
+}
+
+func rewriteParams(list []*Parameter, f func(Node) Node) []*Parameter {
+	var out []*Parameter
+	for _, p := range list {
+		if p := Rewrite(p, f); p != nil {
+			out = append(out, p.(*Parameter))
+		}
+	}
+	return out
+}
+
+func rewriteCases(list []*CaseStatement, f func(Node) Node) []*CaseStatement {
+	var out []*CaseStatement
+	for _, c := range list {
+		if c := Rewrite(c, f); c != nil {
+			out = append(out, c.(*CaseStatement))
+		}
+	}
+	return out
+}
+
+func rewriteExpr(e Expression, f func(Node) Node) Expression {
+	if e == nil {
+		return nil
+	}
+	if n := Rewrite(e, f); n != nil {
+		return n.(Expression)
+	}
+	return nil
+}
+
+func rewriteIdent(i *Identifier, f func(Node) Node) *Identifier {
+	if i == nil {
+		return nil
+	}
+	if n := Rewrite(i, f); n != nil {
+		return n.(*Identifier)
+	}
+	return nil
+}
+
+func rewriteProgram(p *Program, f func(Node) Node) *Program {
+	if p == nil {
+		return nil
+	}
+	if n := Rewrite(p, f); n != nil {
+		return n.(*Program)
+	}
+	return nil
+}
+
+func rewriteBlock(b *BlockStatement, f func(Node) Node) *BlockStatement {
+	if b == nil {
+		return nil
+	}
+	if n := Rewrite(b, f); n != nil {
+		return n.(*BlockStatement)
+	}
+	return nil
+}
+
+func rewriteCall(c *CallExpression, f func(Node) Node) *CallExpression {
+	if c == nil {
+		return nil
+	}
+	if n := Rewrite(c, f); n != nil {
+		return n.(*CallExpression)
+	}
+	return nil
+}
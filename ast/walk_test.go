@@ -0,0 +1,184 @@
+package ast_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/icholy/monkey/ast"
+	"github.com/icholy/monkey/parser"
+)
+
+func TestInspect(t *testing.T) {
+	input := `
+		let add = fn(a: int, b) { return a + b; };
+		function f(x) { x["k"].y }
+		while add(1, 2) > 0 { "${add}" }
+		switch 1 { case 2: {3: null}; default: if (true) { [4] } else { -5 } }
+		x = 1.5;
+		for i, v in [1] { v }
+		let [p, ...q] = fn({s}) {};
+		f(...[1], b = 2);
+	`
+	program, err := parser.Parse(input)
+	require.NoError(t, err)
+	var nodes []string
+	ast.Inspect(program, func(n ast.Node) bool {
+		if n != nil {
+			nodes = append(nodes, fmt.Sprintf("%T", n))
+		}
+		return true
+	})
+	require.Equal(t, []string{
+		"*ast.Program",
+		"*ast.LetStatement",
+		"*ast.Identifier",
+		"*ast.FunctionLiteral",
+		"*ast.Parameter",
+		"*ast.Identifier",
+		"*ast.Identifier",
+		"*ast.Parameter",
+		"*ast.Identifier",
+		"*ast.BlockStatement",
+		"*ast.ReturnStatement",
+		"*ast.InfixExpression",
+		"*ast.Identifier",
+		"*ast.Identifier",
+		"*ast.FunctionStatement",
+		"*ast.Identifier",
+		"*ast.Parameter",
+		"*ast.Identifier",
+		"*ast.BlockStatement",
+		"*ast.ExpressionStatement",
+		"*ast.PropertyExpression",
+		"*ast.IndexExpression",
+		"*ast.Identifier",
+		"*ast.StringLiteral",
+		"*ast.Identifier",
+		"*ast.WhileStatement",
+		"*ast.InfixExpression",
+		"*ast.CallExpression",
+		"*ast.Identifier",
+		"*ast.IntegerLiteral",
+		"*ast.IntegerLiteral",
+		"*ast.IntegerLiteral",
+		"*ast.BlockStatement",
+		"*ast.ExpressionStatement",
+		"*ast.TemplateLiteral",
+		"*ast.Identifier",
+		"*ast.SwitchStatement",
+		"*ast.IntegerLiteral",
+		"*ast.CaseStatement",
+		"*ast.IntegerLiteral",
+		"*ast.ExpressionStatement",
+		"*ast.HashLiteral",
+		"*ast.IntegerLiteral",
+		"*ast.NullExpression",
+		"*ast.ExpressionStatement",
+		"*ast.IfExpression",
+		"*ast.BooleanExpression",
+		"*ast.BlockStatement",
+		"*ast.ExpressionStatement",
+		"*ast.ArrayLiteral",
+		"*ast.IntegerLiteral",
+		"*ast.BlockStatement",
+		"*ast.ExpressionStatement",
+		"*ast.PrefixExpression",
+		"*ast.IntegerLiteral",
+		"*ast.ExpressionStatement",
+		"*ast.AssignmentExpression",
+		"*ast.Identifier",
+		"*ast.FloatLiteral",
+		"*ast.ForStatement",
+		"*ast.Identifier",
+		"*ast.Identifier",
+		"*ast.ArrayLiteral",
+		"*ast.IntegerLiteral",
+		"*ast.BlockStatement",
+		"*ast.ExpressionStatement",
+		"*ast.Identifier",
+		"*ast.LetStatement",
+		"*ast.ArrayPattern",
+		"*ast.Identifier",
+		"*ast.Identifier",
+		"*ast.FunctionLiteral",
+		"*ast.Parameter",
+		"*ast.HashPattern",
+		"*ast.Identifier",
+		"*ast.Identifier",
+		"*ast.BlockStatement",
+		"*ast.ExpressionStatement",
+		"*ast.CallExpression",
+		"*ast.Identifier",
+		"*ast.SpreadExpression",
+		"*ast.ArrayLiteral",
+		"*ast.IntegerLiteral",
+		"*ast.AssignmentExpression",
+		"*ast.Identifier",
+		"*ast.IntegerLiteral",
+	}, nodes)
+}
+
+func TestInspectPrune(t *testing.T) {
+	program, err := parser.Parse("let f = fn(x) { y }; z")
+	require.NoError(t, err)
+	var idents []string
+	ast.Inspect(program, func(n ast.Node) bool {
+		if _, ok := n.(*ast.FunctionLiteral); ok {
+			return false
+		}
+		if id, ok := n.(*ast.Identifier); ok {
+			idents = append(idents, id.Value)
+		}
+		return true
+	})
+	require.Equal(t, []string{"f", "z"}, idents)
+}
+
+func TestRewrite(t *testing.T) {
+	program, err := parser.Parse("let x = 1 + 2; debugger; fn(a) { a * 3 }")
+	require.NoError(t, err)
+	// fold integer additions, drop debugger statements, and rename a to b
+	ast.Rewrite(program, func(n ast.Node) ast.Node {
+		switch n := n.(type) {
+		case *ast.InfixExpression:
+			l, lok := n.Left.(*ast.IntegerLiteral)
+			r, rok := n.Right.(*ast.IntegerLiteral)
+			if lok && rok && n.Operator == "+" {
+				return &ast.IntegerLiteral{Token: l.Token, Value: l.Value + r.Value}
+			}
+		case *ast.DebuggerStatement:
+			return nil
+		case *ast.Identifier:
+			if n.Value == "a" {
+				return &ast.Identifier{Token: n.Token, Value: "b"}
+			}
+		}
+		return n
+	})
+	require.Equal(t, "let x = 3;fn(b) { (b * 3); }", program.String())
+}
+
+func TestRewriteRemove(t *testing.T) {
+	program, err := parser.Parse("switch x { case 1: a; case 2: b } let f = fn(a, b) { a }")
+	require.NoError(t, err)
+	// drop the second case and the b parameter
+	ast.Rewrite(program, func(n ast.Node) ast.Node {
+		switch n := n.(type) {
+		case *ast.CaseStatement:
+			if v, ok := n.Value.(*ast.IntegerLiteral); ok && v.Value == 2 {
+				return nil
+			}
+		case *ast.Parameter:
+			if n.Name.Value == "b" {
+				return nil
+			}
+		}
+		return n
+	})
+	cases := program.Statements[0].(*ast.SwitchStatement).Cases
+	require.Len(t, cases, 1)
+	require.Equal(t, "1", cases[0].Value.String())
+	require.Equal(t, "let f = fn(a) { a; };", program.Statements[1].String())
+}
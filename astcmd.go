@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/icholy/monkey/ast"
+	astjson "github.com/icholy/monkey/ast/json"
+	"github.com/icholy/monkey/parser"
+)
+
+// dumpAST prints the syntax tree of a file.
+func dumpAST(args []string) error {
+	fs := flag.NewFlagSet("ast", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print the tree as JSON")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("usage: monkey ast [-json] file")
+	}
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	program, err := parser.ParseReader(f)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		data, err := astjson.Encode(program)
+		if err != nil {
+			return err
+		}
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, data, "", "  "); err != nil {
+			return err
+		}
+		buf.WriteByte('\n')
+		_, err = buf.WriteTo(os.Stdout)
+		return err
+	}
+	depth := 0
+	ast.Inspect(program, func(node ast.Node) bool {
+		if node == nil {
+			depth--
+			return false
+		}
+		name := reflect.TypeOf(node).Elem().Name()
+		fmt.Printf("%s-%s\t%s%s\n", node.Pos(), node.End(), strings.Repeat("  ", depth), name)
+		depth++
+		return true
+	})
+	return nil
+}
@@ -3,8 +3,11 @@ package code
 import (
 	"encoding/binary"
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
+
+	"github.com/icholy/monkey/ast"
 )
 
 type Opcode byte
@@ -38,6 +41,45 @@ const (
 	OpCall
 	OpReturn
 	OpClosure
+	OpStr
+	OpMod
+	OpPow
+	OpBitAnd
+	OpBitOr
+	OpBitXor
+	OpShl
+	OpShr
+	OpBitNot
+	OpSetIndex
+	OpIter
+	OpIterNext
+	OpUnpackArray
+	OpUnpackHash
+	OpConcat
+	OpCallSpread
+	OpMissingArg
+	OpSlice
+	// OpJumpNull jumps when the top of the stack is null, leaving it
+	// there
+	OpJumpNull
+	// OpJumpNotNull jumps when the top of the stack isn't null, leaving
+	// it there, and pops it otherwise
+	OpJumpNotNull
+	// OpThrow raises the value on top of the stack
+	OpThrow
+	// OpDefer pops an array of arguments and the function below it and
+	// calls it when the current function returns
+	OpDefer
+	// OpDup pushes copies of the top n values of the stack
+	OpDup
+	// OpCaptureLocal and OpCaptureFree push a reference to a local or
+	// free variable for OpClosure
+	OpCaptureLocal
+	OpCaptureFree
+	OpSetFree
+	// OpProperty pops a property name and the value below it and pushes
+	// the property. Missing properties are null when the operand is 1.
+	OpProperty
 )
 
 type Definition struct {
@@ -93,6 +135,33 @@ var definitions = map[Opcode]*Definition{
 	OpReturn:        {"OpReturn", []int{}},
 	OpClosure:       {"OpClosure", []int{2, 1}},
 	OpGetFree:       {"OpGetFree", []int{1}},
+	OpStr:           {"OpStr", []int{}},
+	OpMod:           {"OpMod", []int{}},
+	OpPow:           {"OpPow", []int{}},
+	OpBitAnd:        {"OpBitAnd", []int{}},
+	OpBitOr:         {"OpBitOr", []int{}},
+	OpBitXor:        {"OpBitXor", []int{}},
+	OpShl:           {"OpShl", []int{}},
+	OpShr:           {"OpShr", []int{}},
+	OpBitNot:        {"OpBitNot", []int{}},
+	OpSetIndex:      {"OpSetIndex", []int{}},
+	OpIter:          {"OpIter", []int{}},
+	OpIterNext:      {"OpIterNext", []int{1}},
+	OpUnpackArray:   {"OpUnpackArray", []int{2, 1}},
+	OpUnpackHash:    {"OpUnpackHash", []int{2}},
+	OpConcat:        {"OpConcat", []int{2}},
+	OpCallSpread:    {"OpCallSpread", []int{}},
+	OpMissingArg:    {"OpMissingArg", []int{1}},
+	OpSlice:         {"OpSlice", []int{}},
+	OpJumpNull:      {"OpJumpNull", []int{2}},
+	OpJumpNotNull:   {"OpJumpNotNull", []int{2}},
+	OpThrow:         {"OpThrow", []int{}},
+	OpDefer:         {"OpDefer", []int{}},
+	OpDup:           {"OpDup", []int{1}},
+	OpCaptureLocal:  {"OpCaptureLocal", []int{1}},
+	OpCaptureFree:   {"OpCaptureFree", []int{1}},
+	OpSetFree:       {"OpSetFree", []int{1}},
+	OpProperty:      {"OpProperty", []int{1}},
 }
 
 type Instructions []byte
@@ -185,3 +254,50 @@ func Concat(ii ...Instructions) Instructions {
 	}
 	return concatted
 }
+
+// SourceMapping records that the instructions starting at Offset were
+// compiled from Node.
+type SourceMapping struct {
+	Offset int
+	Node   ast.Node
+}
+
+// SourceMap maps instruction offsets back to the source. Mappings are
+// ordered by offset.
+type SourceMap []SourceMapping
+
+// Lookup returns the node which the instruction at offset was compiled
+// from, or nil if there isn't one.
+func (m SourceMap) Lookup(offset int) ast.Node {
+	i := sort.Search(len(m), func(i int) bool {
+		return m[i].Offset > offset
+	})
+	if i == 0 {
+		return nil
+	}
+	return m[i-1].Node
+}
+
+// Handler catches the errors raised by the instructions from Start up
+// to End by jumping to Target. Finally handlers receive the error so
+// that it can be raised again, the others receive the thrown value.
+type Handler struct {
+	Start   int
+	End     int
+	Target  int
+	Finally bool
+}
+
+// Handlers is a function's exception handler table. Inner handlers come
+// before the ones around them.
+type Handlers []Handler
+
+// Lookup returns the innermost handler for the instruction at offset.
+func (hs Handlers) Lookup(offset int) (Handler, bool) {
+	for _, h := range hs {
+		if h.Start <= offset && offset < h.End {
+			return h, true
+		}
+	}
+	return Handler{}, false
+}
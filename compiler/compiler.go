@@ -2,10 +2,12 @@ package compiler
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/icholy/monkey/ast"
 	"github.com/icholy/monkey/code"
 	"github.com/icholy/monkey/object"
+	"github.com/icholy/monkey/token"
 )
 
 type Instruction struct {
@@ -22,6 +24,25 @@ type Compiler struct {
 	symbols   *SymbolTable
 
 	scopes []*Scope
+
+	// node being compiled
+	node ast.Node
+
+	// chains holds the jumps of the optional links in the member and
+	// call chains being compiled, which are patched with the end of
+	// the chain
+	chains [][]int
+	// link is set while compiling the operand of a chain link
+	link bool
+}
+
+type Error struct {
+	Err  error
+	Node ast.Node
+}
+
+func (e Error) Error() string {
+	return fmt.Sprintf("%s-%s: %s", e.Node.Pos(), e.Node.End(), e.Err)
 }
 
 func New() *Compiler {
@@ -39,13 +60,60 @@ func New() *Compiler {
 
 type Scope struct {
 	instructions code.Instructions
+	sourceMap    code.SourceMap
 	prev         Instruction
 	prevprev     Instruction
+	loops        []*loop
+	tries        []*try
+	handlers     code.Handlers
+}
+
+// loop is a loop being compiled.
+type loop struct {
+	label string
+	// start is where continue jumps to
+	start int
+	// breaks are the positions of jumps which need to be patched with
+	// the end of the loop
+	breaks []int
+}
+
+// try is a try statement being compiled. The finally block is inlined
+// wherever a branch leaves the statement, and that code isn't covered
+// by its handlers, so the covered instructions are split into ranges.
+type try struct {
+	finally *ast.BlockStatement
+	// loops is the number of loops around the statement
+	loops int
+	// start is where the current range begins
+	start  int
+	ranges [][2]int
+}
+
+// suspend ends the current range at pos.
+func (t *try) suspend(pos int) {
+	if t.start < pos {
+		t.ranges = append(t.ranges, [2]int{t.start, pos})
+	}
 }
 
 func (s *Scope) undo() {
 	s.instructions = s.instructions[:s.prev.Position]
 	s.prev = s.prevprev
+	for n := len(s.sourceMap); n > 0 && s.sourceMap[n-1].Offset >= len(s.instructions); n-- {
+		s.sourceMap = s.sourceMap[:n-1]
+	}
+}
+
+// mark maps the instruction at pos to node.
+func (s *Scope) mark(pos int, node ast.Node) {
+	if node == nil {
+		return
+	}
+	if n := len(s.sourceMap); n > 0 && s.sourceMap[n-1].Node == node {
+		return
+	}
+	s.sourceMap = append(s.sourceMap, code.SourceMapping{Offset: pos, Node: node})
 }
 
 func (s *Scope) emit(op code.Opcode, operands ...int) int {
@@ -83,6 +151,22 @@ func Compile(node ast.Node) (*Bytecode, error) {
 }
 
 func (c *Compiler) Compile(node ast.Node) error {
+	prev := c.node
+	c.node = node
+	defer func() { c.node = prev }()
+	if err := c.compile(node); err != nil {
+		if _, ok := err.(*Error); ok {
+			return err
+		}
+		return &Error{
+			Node: node,
+			Err:  err,
+		}
+	}
+	return nil
+}
+
+func (c *Compiler) compile(node ast.Node) error {
 	switch node := node.(type) {
 	case *ast.Program:
 		for _, s := range node.Statements {
@@ -102,6 +186,17 @@ func (c *Compiler) Compile(node ast.Node) error {
 			}
 		}
 	case *ast.InfixExpression:
+		if node.Operator == "??" {
+			if err := c.Compile(node.Left); err != nil {
+				return err
+			}
+			pos := c.emit(code.OpJumpNotNull, 9999)
+			if err := c.Compile(node.Right); err != nil {
+				return err
+			}
+			c.rewrite(pos, code.OpJumpNotNull, len(c.instructions()))
+			return nil
+		}
 		if node.Operator == "<" {
 			if err := c.Compile(node.Right); err != nil {
 				return err
@@ -118,24 +213,7 @@ func (c *Compiler) Compile(node ast.Node) error {
 		if err := c.Compile(node.Right); err != nil {
 			return err
 		}
-		switch node.Operator {
-		case "+":
-			c.emit(code.OpAdd)
-		case "-":
-			c.emit(code.OpSub)
-		case "*":
-			c.emit(code.OpMul)
-		case "/":
-			c.emit(code.OpDiv)
-		case ">":
-			c.emit(code.OpGreaterThan)
-		case "==":
-			c.emit(code.OpEqual)
-		case "!=":
-			c.emit(code.OpNotEqual)
-		default:
-			return fmt.Errorf("unknown operator: %s", node.Operator)
-		}
+		return c.binaryOp(node.Operator)
 	case *ast.IfExpression:
 		scope := c.scope()
 		if err := c.Compile(node.Condition); err != nil {
@@ -166,6 +244,23 @@ func (c *Compiler) Compile(node ast.Node) error {
 		c.rewrite(jumpPos, code.OpJump, len(c.instructions()))
 
 		return nil
+	case *ast.ConditionalExpression:
+		if err := c.Compile(node.Condition); err != nil {
+			return err
+		}
+		jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
+		if err := c.Compile(node.Consequence); err != nil {
+			return err
+		}
+		jumpPos := c.emit(code.OpJump, 9999)
+		c.rewrite(jumpNotTruthyPos, code.OpJumpNotTruthy, len(c.instructions()))
+		if err := c.Compile(node.Alternative); err != nil {
+			return err
+		}
+		c.rewrite(jumpPos, code.OpJump, len(c.instructions()))
+		return nil
+	case *ast.PipelineExpression:
+		return c.Compile(node.Call())
 	case *ast.PrefixExpression:
 		if err := c.Compile(node.Right); err != nil {
 			return err
@@ -175,15 +270,22 @@ func (c *Compiler) Compile(node ast.Node) error {
 			c.emit(code.OpMinus)
 		case "!":
 			c.emit(code.OpBang)
+		case "~":
+			c.emit(code.OpBitNot)
 		default:
 			return fmt.Errorf("unknown operator: %s", node.Operator)
 		}
 	case *ast.IntegerLiteral:
 		v := &object.Integer{Value: node.Value}
 		c.emit(code.OpConstant, c.addConstant(v))
+	case *ast.FloatLiteral:
+		v := &object.Float{Value: node.Value}
+		c.emit(code.OpConstant, c.addConstant(v))
 	case *ast.StringLiteral:
 		v := &object.String{Value: node.Value}
 		c.emit(code.OpConstant, c.addConstant(v))
+	case *ast.TemplateLiteral:
+		return c.compileTemplate(node)
 	case *ast.BooleanExpression:
 		if node.Value {
 			c.emit(code.OpTrue)
@@ -191,6 +293,9 @@ func (c *Compiler) Compile(node ast.Node) error {
 			c.emit(code.OpFalse)
 		}
 	case *ast.ArrayLiteral:
+		if hasSpread(node.Elements) {
+			return c.compileSpread(node.Elements)
+		}
 		for _, e := range node.Elements {
 			if err := c.Compile(e); err != nil {
 				return err
@@ -211,12 +316,10 @@ func (c *Compiler) Compile(node ast.Node) error {
 		if err := c.Compile(node.Value); err != nil {
 			return err
 		}
-		symbol := c.symbols.Define(node.Name.Value)
-		if symbol.Scope == GlobalScope {
-			c.emit(code.OpSetGlobal, symbol.Index)
-		} else {
-			c.emit(code.OpSetLocal, symbol.Index)
+		if node.Pattern != nil {
+			return c.compilePattern(node.Pattern, c.declare)
 		}
+		c.setSymbol(c.symbols.Define(node.Name.Value))
 	case *ast.Identifier:
 		symbol, ok := c.symbols.Resolve(node.Value)
 		if !ok {
@@ -225,20 +328,114 @@ func (c *Compiler) Compile(node ast.Node) error {
 		if err := c.loadSymbol(symbol); err != nil {
 			return err
 		}
-	case *ast.IndexExpression:
+	case *ast.WhileStatement:
+		return c.compileWhile(node, "")
+	case *ast.ForStatement:
+		return c.compileFor(node, "")
+	case *ast.LabeledStatement:
+		switch stmt := node.Statement.(type) {
+		case *ast.WhileStatement:
+			return c.compileWhile(stmt, node.Label.Value)
+		case *ast.ForStatement:
+			return c.compileFor(stmt, node.Label.Value)
+		default:
+			return c.Compile(stmt)
+		}
+	case *ast.BranchStatement:
+		return c.compileBranch(node)
+	case *ast.TryStatement:
+		return c.compileTry(node)
+	case *ast.ThrowStatement:
 		if err := c.Compile(node.Value); err != nil {
 			return err
 		}
+		c.emit(code.OpThrow)
+	case *ast.DeferStatement:
+		return c.compileDefer(node)
+	case *ast.SliceExpression:
+		defer c.chain()()
+		if err := c.operand(node.Value); err != nil {
+			return err
+		}
+		if node.Optional {
+			c.skipNull()
+		}
+		for _, bound := range []ast.Expression{node.Low, node.High} {
+			if bound == nil {
+				c.emit(code.OpNull)
+			} else if err := c.Compile(bound); err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpSlice)
+	case *ast.IndexExpression:
+		defer c.chain()()
+		if err := c.operand(node.Value); err != nil {
+			return err
+		}
+		if node.Optional {
+			c.skipNull()
+		}
 		if err := c.Compile(node.Index); err != nil {
 			return err
 		}
 		c.emit(code.OpIndex)
+	case *ast.PropertyExpression:
+		defer c.chain()()
+		if err := c.operand(node.Value); err != nil {
+			return err
+		}
+		if node.Optional {
+			c.skipNull()
+		}
+		c.emit(code.OpConstant, c.addConstant(&object.String{Value: node.Name.Value}))
+		if node.Optional {
+			c.emit(code.OpProperty, 1)
+		} else {
+			c.emit(code.OpProperty, 0)
+		}
+	case *ast.AssignmentExpression:
+		return c.compileAssign(node)
 	case *ast.FunctionLiteral:
 		c.enterScope()
 
-		// make the parameters locals
+		// make the parameters locals, destructured parameters are
+		// unpacked from hidden locals
+		var params []Symbol
 		for _, p := range node.Parameters {
-			c.symbols.Define(p.Name.Value)
+			if p.Pattern != nil {
+				params = append(params, c.symbols.Define("<param>"))
+			} else {
+				params = append(params, c.symbols.Define(p.Name.Value))
+			}
+		}
+		required, fixed := 0, len(node.Parameters)
+		for i, p := range node.Parameters {
+			if p.Rest {
+				fixed--
+			} else if p.Default == nil {
+				required = i + 1
+			}
+		}
+		for i, p := range node.Parameters {
+			if p.Default != nil && i >= required {
+				c.emit(code.OpMissingArg, i)
+				skipPos := c.emit(code.OpJumpNotTruthy, 9999)
+				if err := c.Compile(p.Default); err != nil {
+					return err
+				}
+				c.setSymbol(params[i])
+				c.rewrite(skipPos, code.OpJumpNotTruthy, len(c.instructions()))
+			}
+			if p.Pattern == nil {
+				continue
+			}
+			if err := c.loadSymbol(params[i]); err != nil {
+				return err
+			}
+			if err := c.compilePattern(p.Pattern, c.declare); err != nil {
+				return err
+			}
 		}
 
 		if err := c.Compile(node.Body); err != nil {
@@ -260,19 +457,26 @@ func (c *Compiler) Compile(node ast.Node) error {
 
 		free := c.symbols.Free
 		nLocals := c.symbols.Count
-		instructions := c.leaveScope()
+		fnScope := c.leaveScope()
 
-		// load the free symbols
+		// capture the free symbols, the closure shares them with the
+		// function which declared them
 		for _, s := range free {
-			if err := c.loadSymbol(s); err != nil {
-				return err
+			if s.Scope == FreeScope {
+				c.emit(code.OpCaptureFree, s.Index)
+			} else {
+				c.emit(code.OpCaptureLocal, s.Index)
 			}
 		}
 
 		compiledFn := &object.CompiledFunction{
 			NumParameters: len(node.Parameters),
+			NumDefaults:   fixed - required,
+			Rest:          fixed < len(node.Parameters),
 			NumLocals:     nLocals,
-			Instructions:  instructions,
+			Instructions:  fnScope.instructions,
+			SourceMap:     fnScope.sourceMap,
+			Handlers:      fnScope.handlers,
 		}
 		c.emit(code.OpClosure, c.addConstant(compiledFn), len(free))
 	case *ast.ReturnStatement:
@@ -283,11 +487,22 @@ func (c *Compiler) Compile(node ast.Node) error {
 		} else {
 			c.emit(code.OpNull)
 		}
-		c.emit(code.OpReturn)
+		return c.leave(0, func() { c.emit(code.OpReturn) })
 	case *ast.CallExpression:
-		if err := c.Compile(node.Function); err != nil {
+		defer c.chain()()
+		if err := c.operand(node.Function); err != nil {
 			return err
 		}
+		if node.Optional {
+			c.skipNull()
+		}
+		if hasSpread(node.Arguments) {
+			if err := c.compileSpread(node.Arguments); err != nil {
+				return err
+			}
+			c.emit(code.OpCallSpread)
+			return nil
+		}
 		for _, arg := range node.Arguments {
 			if err := c.Compile(arg); err != nil {
 				return err
@@ -300,6 +515,525 @@ func (c *Compiler) Compile(node ast.Node) error {
 	return nil
 }
 
+func (c *Compiler) binaryOp(operator string) error {
+	switch operator {
+	case "+":
+		c.emit(code.OpAdd)
+	case "-":
+		c.emit(code.OpSub)
+	case "*":
+		c.emit(code.OpMul)
+	case "/":
+		c.emit(code.OpDiv)
+	case "%":
+		c.emit(code.OpMod)
+	case "**":
+		c.emit(code.OpPow)
+	case "&":
+		c.emit(code.OpBitAnd)
+	case "|":
+		c.emit(code.OpBitOr)
+	case "^":
+		c.emit(code.OpBitXor)
+	case "<<":
+		c.emit(code.OpShl)
+	case ">>":
+		c.emit(code.OpShr)
+	case ">":
+		c.emit(code.OpGreaterThan)
+	case "==":
+		c.emit(code.OpEqual)
+	case "!=":
+		c.emit(code.OpNotEqual)
+	default:
+		return fmt.Errorf("unknown operator: %s", operator)
+	}
+	return nil
+}
+
+func (c *Compiler) compileAssign(a *ast.AssignmentExpression) error {
+	switch a.Left.(type) {
+	case *ast.ArrayPattern, *ast.HashPattern:
+		if err := c.Compile(a.Value); err != nil {
+			return err
+		}
+		// targets other than variables are evaluated before their value
+		// so it's moved out of the way into a hidden variable
+		var tmp *Symbol
+		err := c.compilePattern(a.Left, func(target ast.Expression) error {
+			if _, ok := target.(*ast.Identifier); ok {
+				return c.assign(target, func() error { return nil })
+			}
+			if tmp == nil {
+				s := c.symbols.Define("<tmp>")
+				tmp = &s
+			}
+			c.setSymbol(*tmp)
+			return c.assign(target, func() error { return c.loadSymbol(*tmp) })
+		})
+		if err != nil {
+			return err
+		}
+	default:
+		// value pushes the new value, combining it with the old one for
+		// compound assignments. The operands of index and property
+		// targets are on the stack already, so they're copied to read
+		// the old value rather than evaluated again.
+		value := func() error {
+			if a.Operator != "=" {
+				switch a.Left.(type) {
+				case *ast.IndexExpression:
+					c.emit(code.OpDup, 2)
+					c.emit(code.OpIndex)
+				case *ast.PropertyExpression:
+					c.emit(code.OpDup, 2)
+					c.emit(code.OpProperty, 0)
+				default:
+					if err := c.Compile(a.Left); err != nil {
+						return err
+					}
+				}
+			}
+			if err := c.Compile(a.Value); err != nil {
+				return err
+			}
+			if a.Operator != "=" {
+				return c.binaryOp(strings.TrimSuffix(a.Operator, "="))
+			}
+			return nil
+		}
+		if err := c.assign(a.Left, value); err != nil {
+			return err
+		}
+	}
+	// assignments evaluate to null
+	c.emit(code.OpNull)
+	return nil
+}
+
+// assign stores the value pushed by value in target. The operands of
+// the target are evaluated first.
+func (c *Compiler) assign(target ast.Expression, value func() error) error {
+	switch left := target.(type) {
+	case *ast.Identifier:
+		symbol, ok := c.symbols.Resolve(left.Value)
+		if !ok {
+			return fmt.Errorf("invalid identifier: %s", left.Value)
+		}
+		if err := value(); err != nil {
+			return err
+		}
+		switch symbol.Scope {
+		case GlobalScope:
+			c.emit(code.OpSetGlobal, symbol.Index)
+		case LocalScope:
+			c.emit(code.OpSetLocal, symbol.Index)
+		case FreeScope:
+			c.emit(code.OpSetFree, symbol.Index)
+		default:
+			return fmt.Errorf("cannot assign to %s variable: %s", strings.ToLower(string(symbol.Scope)), left.Value)
+		}
+	case *ast.IndexExpression:
+		if err := c.Compile(left.Value); err != nil {
+			return err
+		}
+		if err := c.Compile(left.Index); err != nil {
+			return err
+		}
+		if err := value(); err != nil {
+			return err
+		}
+		c.emit(code.OpSetIndex)
+	case *ast.PropertyExpression:
+		if err := c.Compile(left.Value); err != nil {
+			return err
+		}
+		c.emit(code.OpConstant, c.addConstant(&object.String{Value: left.Name.Value}))
+		if err := value(); err != nil {
+			return err
+		}
+		c.emit(code.OpSetIndex)
+	default:
+		return fmt.Errorf("invalid assignment target")
+	}
+	return nil
+}
+
+// chain starts a member and call chain, unless the node being compiled
+// is a link of an enclosing one. The returned function must be called
+// at the end of the node, it reports whether the chain has optional
+// links.
+func (c *Compiler) chain() func() bool {
+	if c.link {
+		c.link = false
+		return func() bool { return false }
+	}
+	c.chains = append(c.chains, nil)
+	return func() bool {
+		n := len(c.chains) - 1
+		for _, pos := range c.chains[n] {
+			c.rewrite(pos, code.OpJumpNull, len(c.instructions()))
+		}
+		skips := c.chains[n]
+		c.chains = c.chains[:n]
+		return len(skips) > 0
+	}
+}
+
+// operand compiles the operand of a chain link.
+func (c *Compiler) operand(e ast.Expression) error {
+	switch e.(type) {
+	case *ast.CallExpression, *ast.PropertyExpression, *ast.IndexExpression, *ast.SliceExpression:
+		c.link = true
+	}
+	return c.Compile(e)
+}
+
+// skipNull emits a jump over the rest of the chain when the value on
+// top of the stack is null.
+func (c *Compiler) skipNull() {
+	n := len(c.chains) - 1
+	c.chains[n] = append(c.chains[n], c.emit(code.OpJumpNull, 9999))
+}
+
+// compileSpread pushes an array of elems, expanding spread arrays. Runs
+// of other elements are collected into arrays which are concatenated
+// with the spread ones.
+func (c *Compiler) compileSpread(elems []ast.Expression) error {
+	var arrays, run int
+	for _, e := range elems {
+		spread, ok := e.(*ast.SpreadExpression)
+		if !ok {
+			if err := c.Compile(e); err != nil {
+				return err
+			}
+			run++
+			continue
+		}
+		if run > 0 {
+			c.emit(code.OpArray, run)
+			arrays++
+			run = 0
+		}
+		if err := c.Compile(spread.Value); err != nil {
+			return err
+		}
+		arrays++
+	}
+	if run > 0 {
+		c.emit(code.OpArray, run)
+		arrays++
+	}
+	c.emit(code.OpConcat, arrays)
+	return nil
+}
+
+func hasSpread(elems []ast.Expression) bool {
+	for _, e := range elems {
+		if _, ok := e.(*ast.SpreadExpression); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// compilePattern destructures the value on top of the stack into the
+// targets of pattern. Targets which aren't patterns are passed to bind
+// with their value on top of the stack.
+func (c *Compiler) compilePattern(pattern ast.Expression, bind func(ast.Expression) error) error {
+	switch pattern := pattern.(type) {
+	case *ast.ArrayPattern:
+		if pattern.Rest != nil {
+			c.emit(code.OpUnpackArray, len(pattern.Elements), 1)
+		} else {
+			c.emit(code.OpUnpackArray, len(pattern.Elements), 0)
+		}
+		for _, e := range pattern.Elements {
+			if err := c.compilePattern(e, bind); err != nil {
+				return err
+			}
+		}
+		if pattern.Rest != nil {
+			return c.compilePattern(pattern.Rest, bind)
+		}
+	case *ast.HashPattern:
+		for _, p := range pattern.Pairs {
+			if ident, ok := p.Key.(*ast.Identifier); ok {
+				c.emit(code.OpConstant, c.addConstant(&object.String{Value: ident.Value}))
+			} else if err := c.Compile(p.Key); err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpUnpackHash, len(pattern.Pairs))
+		for _, p := range pattern.Pairs {
+			if err := c.compilePattern(p.Value, bind); err != nil {
+				return err
+			}
+		}
+	default:
+		return bind(pattern)
+	}
+	return nil
+}
+
+// declare defines a variable for a destructuring target and stores the
+// value on top of the stack in it.
+func (c *Compiler) declare(target ast.Expression) error {
+	ident, ok := target.(*ast.Identifier)
+	if !ok {
+		return fmt.Errorf("invalid destructuring target: %s", target)
+	}
+	c.setSymbol(c.symbols.Define(ident.Value))
+	return nil
+}
+
+func (c *Compiler) compileWhile(w *ast.WhileStatement, label string) error {
+	start := len(c.instructions())
+	if err := c.Compile(w.Condition); err != nil {
+		return err
+	}
+	exitPos := c.emit(code.OpJumpNotTruthy, 9999)
+	c.enterLoop(label, start)
+	if err := c.Compile(w.Body); err != nil {
+		return err
+	}
+	c.emit(code.OpJump, start)
+	c.rewrite(exitPos, code.OpJumpNotTruthy, len(c.instructions()))
+	c.leaveLoop()
+	return nil
+}
+
+// compileFor stores the iterator in a hidden variable and calls
+// OpIterNext at the top of each iteration. OpIterNext pushes the loop
+// variables followed by true, or just false when the iterator is done.
+// Like in the evaluator, the loop variables are shared by all of the
+// iterations.
+func (c *Compiler) compileFor(f *ast.ForStatement, label string) error {
+	if err := c.Compile(f.Iterable); err != nil {
+		return err
+	}
+	c.emit(code.OpIter)
+	iter := c.symbols.Define("<iter>")
+	c.setSymbol(iter)
+	start := len(c.instructions())
+	if err := c.loadSymbol(iter); err != nil {
+		return err
+	}
+	if f.Key != nil {
+		c.emit(code.OpIterNext, 2)
+	} else {
+		c.emit(code.OpIterNext, 1)
+	}
+	exitPos := c.emit(code.OpJumpNotTruthy, 9999)
+	value := c.symbols.Define(f.Value.Value)
+	if f.Key != nil {
+		key := c.symbols.Define(f.Key.Value)
+		c.setSymbol(value)
+		c.setSymbol(key)
+	} else {
+		c.setSymbol(value)
+	}
+	c.enterLoop(label, start)
+	if err := c.Compile(f.Body); err != nil {
+		return err
+	}
+	c.emit(code.OpJump, start)
+	c.rewrite(exitPos, code.OpJumpNotTruthy, len(c.instructions()))
+	c.leaveLoop()
+	return nil
+}
+
+func (c *Compiler) compileBranch(b *ast.BranchStatement) error {
+	var label string
+	if b.Label != nil {
+		label = b.Label.Value
+	}
+	i := c.findLoop(label)
+	if i < 0 {
+		if label != "" {
+			return fmt.Errorf("undefined loop label: %s", label)
+		}
+		return fmt.Errorf("%s outside loop", b.Token.Text)
+	}
+	loop := c.scope().loops[i]
+	// leave the try statements inside the loop
+	tries := c.scope().tries
+	n := len(tries)
+	for n > 0 && tries[n-1].loops > i {
+		n--
+	}
+	return c.leave(n, func() {
+		if b.Token.Is(token.CONTINUE) {
+			c.emit(code.OpJump, loop.start)
+		} else {
+			loop.breaks = append(loop.breaks, c.emit(code.OpJump, 9999))
+		}
+	})
+}
+
+// compileTry protects the try block with a handler which jumps to the
+// catch block, and the catch block with one which runs the finally
+// block and raises the error again.
+func (c *Compiler) compileTry(t *ast.TryStatement) error {
+	stmt := &try{finally: t.Finally, loops: len(c.scope().loops)}
+	blockRanges, err := c.protect(stmt, t.Block)
+	if err != nil {
+		return err
+	}
+	if t.Finally != nil {
+		if err := c.Compile(t.Finally); err != nil {
+			return err
+		}
+	}
+	ends := []int{c.emit(code.OpJump, 9999)}
+
+	// the catch block starts with the thrown value on the stack
+	var catchRanges [][2]int
+	catchPos := len(c.instructions())
+	if t.Catch != nil {
+		if t.Param != nil {
+			c.setSymbol(c.symbols.Define(t.Param.Value))
+		} else {
+			c.emit(code.OpPop)
+		}
+		if t.Finally != nil {
+			if catchRanges, err = c.protect(stmt, t.Catch); err != nil {
+				return err
+			}
+			if err := c.Compile(t.Finally); err != nil {
+				return err
+			}
+		} else if err := c.Compile(t.Catch); err != nil {
+			return err
+		}
+		ends = append(ends, c.emit(code.OpJump, 9999))
+	}
+
+	// the finally block starts with the error on the stack
+	finallyPos := len(c.instructions())
+	if t.Finally != nil {
+		pending := c.symbols.Define("<err>")
+		c.setSymbol(pending)
+		if err := c.Compile(t.Finally); err != nil {
+			return err
+		}
+		if err := c.loadSymbol(pending); err != nil {
+			return err
+		}
+		c.emit(code.OpThrow)
+	}
+
+	for _, pos := range ends {
+		c.rewrite(pos, code.OpJump, len(c.instructions()))
+	}
+	scope := c.scope()
+	for _, r := range blockRanges {
+		if t.Catch != nil {
+			scope.handlers = append(scope.handlers, code.Handler{Start: r[0], End: r[1], Target: catchPos})
+		} else {
+			scope.handlers = append(scope.handlers, code.Handler{Start: r[0], End: r[1], Target: finallyPos, Finally: true})
+		}
+	}
+	for _, r := range catchRanges {
+		scope.handlers = append(scope.handlers, code.Handler{Start: r[0], End: r[1], Target: finallyPos, Finally: true})
+	}
+	// try statements don't have a value, so the last expression in a
+	// block isn't returned implicitly
+	c.emit(code.OpNull)
+	c.emit(code.OpPop)
+	return nil
+}
+
+// protect compiles block inside the try statement and returns the
+// ranges of instructions it covers.
+func (c *Compiler) protect(t *try, block *ast.BlockStatement) ([][2]int, error) {
+	scope := c.scope()
+	t.start, t.ranges = len(scope.instructions), nil
+	scope.tries = append(scope.tries, t)
+	err := c.Compile(block)
+	scope.tries = scope.tries[:len(scope.tries)-1]
+	t.suspend(len(scope.instructions))
+	return t.ranges, err
+}
+
+// leave calls exit to emit a jump out of the try statements from
+// tries[n] inwards, after inlining their finally blocks. Each finally
+// block is covered by the try statements around it, but not its own.
+func (c *Compiler) leave(n int, exit func()) error {
+	scope := c.scope()
+	tries := scope.tries
+	defer func() {
+		scope.tries = tries
+		for _, t := range tries[n:] {
+			t.start = len(scope.instructions)
+		}
+	}()
+	for i := len(tries) - 1; i >= n; i-- {
+		t := tries[i]
+		t.suspend(len(scope.instructions))
+		scope.tries = tries[:i]
+		if t.finally != nil {
+			if err := c.Compile(t.finally); err != nil {
+				return err
+			}
+		}
+	}
+	exit()
+	return nil
+}
+
+// compileDefer pushes the function and an array of the arguments for
+// OpDefer. The call isn't deferred when an optional link of the chain
+// finds null.
+func (c *Compiler) compileDefer(d *ast.DeferStatement) error {
+	call := d.Call
+	done := c.chain()
+	if err := c.operand(call.Function); err != nil {
+		return err
+	}
+	if call.Optional {
+		c.skipNull()
+	}
+	if hasSpread(call.Arguments) {
+		if err := c.compileSpread(call.Arguments); err != nil {
+			return err
+		}
+	} else {
+		for _, arg := range call.Arguments {
+			if err := c.Compile(arg); err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpArray, len(call.Arguments))
+	}
+	c.emit(code.OpDefer)
+	end := c.emit(code.OpJump, 9999)
+	if done() {
+		c.emit(code.OpPop)
+		c.rewrite(end, code.OpJump, len(c.instructions()))
+	} else {
+		c.scope().undo()
+	}
+	return nil
+}
+
+func (c *Compiler) compileTemplate(t *ast.TemplateLiteral) error {
+	// the first part is always pushed so that OpAdd sees a string
+	c.emit(code.OpConstant, c.addConstant(&object.String{Value: t.Strings[0]}))
+	for i, v := range t.Values {
+		if err := c.Compile(v); err != nil {
+			return err
+		}
+		c.emit(code.OpStr)
+		c.emit(code.OpAdd)
+		if s := t.Strings[i+1]; s != "" {
+			c.emit(code.OpConstant, c.addConstant(&object.String{Value: s}))
+			c.emit(code.OpAdd)
+		}
+	}
+	return nil
+}
+
 func (c *Compiler) loadSymbol(s Symbol) error {
 	switch s.Scope {
 	case GlobalScope:
@@ -316,12 +1050,52 @@ func (c *Compiler) loadSymbol(s Symbol) error {
 	return nil
 }
 
+// setSymbol pops the top of the stack into a global or local.
+func (c *Compiler) setSymbol(s Symbol) {
+	if s.Scope == GlobalScope {
+		c.emit(code.OpSetGlobal, s.Index)
+	} else {
+		c.emit(code.OpSetLocal, s.Index)
+	}
+}
+
+func (c *Compiler) enterLoop(label string, start int) {
+	scope := c.scope()
+	scope.loops = append(scope.loops, &loop{label: label, start: start})
+}
+
+// leaveLoop points the loop's breaks at the current position.
+func (c *Compiler) leaveLoop() {
+	scope := c.scope()
+	loop := scope.loops[len(scope.loops)-1]
+	scope.loops = scope.loops[:len(scope.loops)-1]
+	for _, pos := range loop.breaks {
+		c.rewrite(pos, code.OpJump, len(c.instructions()))
+	}
+}
+
+// findLoop returns the index of the innermost loop with the label, or
+// the innermost loop if the label is empty. It returns -1 if there's no
+// such loop.
+func (c *Compiler) findLoop(label string) int {
+	loops := c.scope().loops
+	for i := len(loops) - 1; i >= 0; i-- {
+		if label == "" || loops[i].label == label {
+			return i
+		}
+	}
+	return -1
+}
+
 func (c *Compiler) instructions() code.Instructions {
 	return c.scope().instructions
 }
 
 func (c *Compiler) emit(op code.Opcode, operands ...int) int {
-	return c.scope().emit(op, operands...)
+	scope := c.scope()
+	pos := scope.emit(op, operands...)
+	scope.mark(pos, c.node)
+	return pos
 }
 
 func (c *Compiler) rewrite(pos int, op code.Opcode, operands ...int) {
@@ -349,21 +1123,25 @@ func (c *Compiler) enterScope() {
 	c.scopes = append(c.scopes, &Scope{})
 }
 
-func (c *Compiler) leaveScope() code.Instructions {
-	ins := c.instructions()
+func (c *Compiler) leaveScope() *Scope {
+	scope := c.scope()
 	c.scopes = c.scopes[:len(c.scopes)-1]
 	c.symbols = c.symbols.Outer
-	return ins
+	return scope
 }
 
 func (c *Compiler) Bytecode() *Bytecode {
 	return &Bytecode{
 		Instructions: c.instructions(),
+		SourceMap:    c.scope().sourceMap,
+		Handlers:     c.scope().handlers,
 		Constants:    c.constants,
 	}
 }
 
 type Bytecode struct {
 	Instructions code.Instructions
+	SourceMap    code.SourceMap
+	Handlers     code.Handlers
 	Constants    []object.Object
 }
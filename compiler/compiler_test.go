@@ -4,6 +4,7 @@ import (
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
 
 	is "gotest.tools/assert/cmp"
 
@@ -15,6 +16,8 @@ import (
 	"github.com/icholy/monkey/parser"
 )
 
+var ignoreSourceMap = cmpopts.IgnoreFields(object.CompiledFunction{}, "SourceMap")
+
 func TestCompile(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -280,6 +283,93 @@ func TestCompile(t *testing.T) {
 				},
 			},
 		},
+		{
+			input: "true ? 1 : 2",
+			expected: &Bytecode{
+				Instructions: code.Concat(
+					// 0000
+					code.Make(code.OpTrue),
+					// 0001
+					code.Make(code.OpJumpNotTruthy, 10),
+					// 0004
+					code.Make(code.OpConstant, 0),
+					// 0007
+					code.Make(code.OpJump, 13),
+					// 0010
+					code.Make(code.OpConstant, 1),
+					// 0013
+					code.Make(code.OpPop),
+				),
+				Constants: []object.Object{
+					object.New(1),
+					object.New(2),
+				},
+			},
+		},
+		{
+			input: "null ?? {}?.b",
+			expected: &Bytecode{
+				Instructions: code.Concat(
+					// 0000
+					code.Make(code.OpNull),
+					// 0001
+					code.Make(code.OpJumpNotNull, 15),
+					// 0004
+					code.Make(code.OpHash, 0),
+					// 0007
+					code.Make(code.OpJumpNull, 15),
+					// 0010
+					code.Make(code.OpConstant, 0),
+					// 0013
+					code.Make(code.OpProperty, 1),
+					// 0015
+					code.Make(code.OpPop),
+				),
+				Constants: []object.Object{
+					object.New("b"),
+				},
+			},
+		},
+		{
+			input: "null?.a.b",
+			expected: &Bytecode{
+				Instructions: code.Concat(
+					// 0000
+					code.Make(code.OpNull),
+					// 0001
+					code.Make(code.OpJumpNull, 14),
+					// 0004
+					code.Make(code.OpConstant, 0),
+					// 0007
+					code.Make(code.OpProperty, 1),
+					// 0009
+					code.Make(code.OpConstant, 1),
+					// 0012
+					code.Make(code.OpProperty, 0),
+					// 0014
+					code.Make(code.OpPop),
+				),
+				Constants: []object.Object{
+					object.New("a"),
+					object.New("b"),
+				},
+			},
+		},
+		{
+			input: "[][:1]",
+			expected: &Bytecode{
+				Instructions: code.Concat(
+					code.Make(code.OpArray, 0),
+					code.Make(code.OpNull),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpSlice),
+					code.Make(code.OpPop),
+				),
+				Constants: []object.Object{
+					object.New(1),
+				},
+			},
+		},
 		{
 			input: "[1, 2, 3][1]",
 			expected: &Bytecode{
@@ -615,7 +705,7 @@ func TestCompile(t *testing.T) {
 						NumLocals:     1,
 						NumParameters: 1,
 						Instructions: code.Concat(
-							code.Make(code.OpGetLocal, 0),
+							code.Make(code.OpCaptureLocal, 0),
 							code.Make(code.OpClosure, 0, 1),
 							code.Make(code.OpReturn),
 						),
@@ -647,8 +737,8 @@ func TestCompile(t *testing.T) {
 						NumLocals:     1,
 						NumParameters: 1,
 						Instructions: code.Concat(
-							code.Make(code.OpGetFree, 0),
-							code.Make(code.OpGetLocal, 0),
+							code.Make(code.OpCaptureFree, 0),
+							code.Make(code.OpCaptureLocal, 0),
 							code.Make(code.OpClosure, 0, 2),
 							code.Make(code.OpReturn),
 						),
@@ -657,7 +747,7 @@ func TestCompile(t *testing.T) {
 						NumLocals:     1,
 						NumParameters: 1,
 						Instructions: code.Concat(
-							code.Make(code.OpGetLocal, 0),
+							code.Make(code.OpCaptureLocal, 0),
 							code.Make(code.OpClosure, 1, 1),
 							code.Make(code.OpReturn),
 						),
@@ -712,8 +802,8 @@ func TestCompile(t *testing.T) {
 						Instructions: code.Concat(
 							code.Make(code.OpConstant, 2),
 							code.Make(code.OpSetLocal, 0),
-							code.Make(code.OpGetFree, 0),
-							code.Make(code.OpGetLocal, 0),
+							code.Make(code.OpCaptureFree, 0),
+							code.Make(code.OpCaptureLocal, 0),
 							code.Make(code.OpClosure, 4, 2),
 							code.Make(code.OpReturn),
 						),
@@ -723,7 +813,7 @@ func TestCompile(t *testing.T) {
 						Instructions: code.Concat(
 							code.Make(code.OpConstant, 1),
 							code.Make(code.OpSetLocal, 0),
-							code.Make(code.OpGetLocal, 0),
+							code.Make(code.OpCaptureLocal, 0),
 							code.Make(code.OpClosure, 5, 1),
 							code.Make(code.OpReturn),
 						),
@@ -731,6 +821,292 @@ func TestCompile(t *testing.T) {
 				},
 			},
 		},
+		{
+			input: `"a${1}"`,
+			expected: &Bytecode{
+				Instructions: code.Concat(
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpStr),
+					code.Make(code.OpAdd),
+					code.Make(code.OpPop),
+				),
+				Constants: []object.Object{
+					object.New("a"),
+					object.New(1),
+				},
+			},
+		},
+		{
+			input: "1.5 / 2",
+			expected: &Bytecode{
+				Instructions: code.Concat(
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpDiv),
+					code.Make(code.OpPop),
+				),
+				Constants: []object.Object{
+					object.New(1.5),
+					object.New(2),
+				},
+			},
+		},
+		{
+			input: "~(1 % 2 ** 3)",
+			expected: &Bytecode{
+				Instructions: code.Concat(
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpConstant, 2),
+					code.Make(code.OpPow),
+					code.Make(code.OpMod),
+					code.Make(code.OpBitNot),
+					code.Make(code.OpPop),
+				),
+				Constants: []object.Object{
+					object.New(1),
+					object.New(2),
+					object.New(3),
+				},
+			},
+		},
+		{
+			input: "let x = 1; x += 2",
+			expected: &Bytecode{
+				Instructions: code.Concat(
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpSetGlobal, 0),
+					code.Make(code.OpGetGlobal, 0),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpAdd),
+					code.Make(code.OpSetGlobal, 0),
+					code.Make(code.OpNull),
+					code.Make(code.OpPop),
+				),
+				Constants: []object.Object{
+					object.New(1),
+					object.New(2),
+				},
+			},
+		},
+		{
+			input: "[1][0] += 2",
+			expected: &Bytecode{
+				Instructions: code.Concat(
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpArray, 1),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpDup, 2),
+					code.Make(code.OpIndex),
+					code.Make(code.OpConstant, 2),
+					code.Make(code.OpAdd),
+					code.Make(code.OpSetIndex),
+					code.Make(code.OpNull),
+					code.Make(code.OpPop),
+				),
+				Constants: []object.Object{
+					object.New(1),
+					object.New(0),
+					object.New(2),
+				},
+			},
+		},
+		{
+			input: "[1][0] = 2",
+			expected: &Bytecode{
+				Instructions: code.Concat(
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpArray, 1),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpConstant, 2),
+					code.Make(code.OpSetIndex),
+					code.Make(code.OpNull),
+					code.Make(code.OpPop),
+				),
+				Constants: []object.Object{
+					object.New(1),
+					object.New(0),
+					object.New(2),
+				},
+			},
+		},
+		{
+			input: "while true { continue; break }",
+			expected: &Bytecode{
+				Instructions: code.Concat(
+					// 0000
+					code.Make(code.OpTrue),
+					// 0001
+					code.Make(code.OpJumpNotTruthy, 13),
+					// 0004
+					code.Make(code.OpJump, 0),
+					// 0007
+					code.Make(code.OpJump, 13),
+					// 0010
+					code.Make(code.OpJump, 0),
+				),
+			},
+		},
+		{
+			input: "for k, v in [1] { v }",
+			expected: &Bytecode{
+				Instructions: code.Concat(
+					// 0000
+					code.Make(code.OpConstant, 0),
+					// 0003
+					code.Make(code.OpArray, 1),
+					// 0006
+					code.Make(code.OpIter),
+					// 0007
+					code.Make(code.OpSetGlobal, 0),
+					// 0010
+					code.Make(code.OpGetGlobal, 0),
+					// 0013
+					code.Make(code.OpIterNext, 2),
+					// 0015
+					code.Make(code.OpJumpNotTruthy, 31),
+					// 0018
+					code.Make(code.OpSetGlobal, 1),
+					// 0021
+					code.Make(code.OpSetGlobal, 2),
+					// 0024
+					code.Make(code.OpGetGlobal, 1),
+					// 0027
+					code.Make(code.OpPop),
+					// 0028
+					code.Make(code.OpJump, 10),
+				),
+				Constants: []object.Object{
+					object.New(1),
+				},
+			},
+		},
+		{
+			input: "let [a, ...b] = []",
+			expected: &Bytecode{
+				Instructions: code.Concat(
+					// 0000
+					code.Make(code.OpArray, 0),
+					// 0003
+					code.Make(code.OpUnpackArray, 1, 1),
+					// 0007
+					code.Make(code.OpSetGlobal, 0),
+					// 0010
+					code.Make(code.OpSetGlobal, 1),
+				),
+			},
+		},
+		{
+			input: "fn(a = 1, ...b) { a }",
+			expected: &Bytecode{
+				Instructions: code.Concat(
+					code.Make(code.OpClosure, 1, 0),
+					code.Make(code.OpPop),
+				),
+				Constants: []object.Object{
+					object.New(1),
+					&object.CompiledFunction{
+						NumLocals:     2,
+						NumParameters: 2,
+						NumDefaults:   1,
+						Rest:          true,
+						Instructions: code.Concat(
+							// 0000
+							code.Make(code.OpMissingArg, 0),
+							// 0002
+							code.Make(code.OpJumpNotTruthy, 10),
+							// 0005
+							code.Make(code.OpConstant, 0),
+							// 0008
+							code.Make(code.OpSetLocal, 0),
+							// 0010
+							code.Make(code.OpGetLocal, 0),
+							// 0012
+							code.Make(code.OpReturn),
+						),
+					},
+				},
+			},
+		},
+		{
+			input: "len(1, ...[2], 3)",
+			expected: &Bytecode{
+				Instructions: code.Concat(
+					// 0000
+					code.Make(code.OpGetBuiltin, object.FindBuiltin("len")),
+					// 0002
+					code.Make(code.OpConstant, 0),
+					// 0005
+					code.Make(code.OpArray, 1),
+					// 0008
+					code.Make(code.OpConstant, 1),
+					// 0011
+					code.Make(code.OpArray, 1),
+					// 0014
+					code.Make(code.OpConstant, 2),
+					// 0017
+					code.Make(code.OpArray, 1),
+					// 0020
+					code.Make(code.OpConcat, 3),
+					// 0023
+					code.Make(code.OpCallSpread),
+					// 0024
+					code.Make(code.OpPop),
+				),
+				Constants: []object.Object{
+					object.New(1),
+					object.New(2),
+					object.New(3),
+				},
+			},
+		},
+		{
+			input: "fn() { defer len(1) }",
+			expected: &Bytecode{
+				Instructions: code.Concat(
+					code.Make(code.OpClosure, 1, 0),
+					code.Make(code.OpPop),
+				),
+				Constants: []object.Object{
+					object.New(1),
+					&object.CompiledFunction{
+						Instructions: code.Concat(
+							// 0000
+							code.Make(code.OpGetBuiltin, object.FindBuiltin("len")),
+							// 0002
+							code.Make(code.OpConstant, 0),
+							// 0005
+							code.Make(code.OpArray, 1),
+							// 0008
+							code.Make(code.OpDefer),
+							// 0009
+							code.Make(code.OpNull),
+							// 0010
+							code.Make(code.OpReturn),
+						),
+					},
+				},
+			},
+		},
+		{
+			input: "let {a} = {}",
+			expected: &Bytecode{
+				Instructions: code.Concat(
+					// 0000
+					code.Make(code.OpHash, 0),
+					// 0003
+					code.Make(code.OpConstant, 0),
+					// 0006
+					code.Make(code.OpUnpackHash, 1),
+					// 0009
+					code.Make(code.OpSetGlobal, 0),
+				),
+				Constants: []object.Object{
+					object.New("a"),
+				},
+			},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.input, func(t *testing.T) {
@@ -738,13 +1114,65 @@ func TestCompile(t *testing.T) {
 			assert.NilError(t, err)
 			actual, err := Compile(program)
 			assert.NilError(t, err)
-			assert.DeepEqual(t, tt.expected.Constants, actual.Constants, cmp.Transformer("Instructions", code.Instructions.String))
+			assert.DeepEqual(t, tt.expected.Constants, actual.Constants, cmp.Transformer("Instructions", code.Instructions.String), ignoreSourceMap)
 			assert.DeepEqual(t, tt.expected.Instructions, actual.Instructions, cmp.Transformer("Instructions", code.Instructions.String))
-			assert.DeepEqual(t, tt.expected.Constants, actual.Constants)
+			assert.DeepEqual(t, tt.expected.Constants, actual.Constants, ignoreSourceMap)
 			assert.DeepEqual(t, tt.expected.Instructions, actual.Instructions)
 		})
 	}
 }
+func TestCompileHandlers(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected code.Handlers
+	}{
+		{
+			input: "try { 1 } catch (e) { 2 } finally { 3 }",
+			expected: code.Handlers{
+				{Start: 0, End: 4, Target: 11},
+				{Start: 14, End: 18, Target: 25, Finally: true},
+			},
+		},
+		{
+			// the finally block inlined before the break isn't covered
+			input: "while true { try { 1; break; 2 } finally { 3 } }",
+			expected: code.Handlers{
+				{Start: 4, End: 8, Target: 26, Finally: true},
+				{Start: 15, End: 19, Target: 26, Finally: true},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			program, err := parser.Parse(tt.input)
+			assert.NilError(t, err)
+			actual, err := Compile(program)
+			assert.NilError(t, err)
+			assert.DeepEqual(t, tt.expected, actual.Handlers)
+		})
+	}
+}
+
+func TestCompileError(t *testing.T) {
+	tests := []struct {
+		input   string
+		message string
+	}{
+		{"x", "1:1-1:2: invalid identifier: x"},
+		{"let f = fn() {\n  1 + y\n}", "2:7-2:8: invalid identifier: y"},
+		{"len = 1", "1:1-1:8: cannot assign to builtin variable: len"},
+		{"1 = 2", "1:1-1:6: invalid assignment target"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			program, err := parser.Parse(tt.input)
+			assert.NilError(t, err)
+			_, err = Compile(program)
+			assert.Error(t, err, tt.message)
+		})
+	}
+}
+
 func TestScopes(t *testing.T) {
 	compiler := New()
 	global := compiler.symbols
@@ -1,9 +1,11 @@
 package evaluator
 
 import (
+	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
+	"math"
+	"os"
 	"strings"
 
 	"github.com/chzyer/readline"
@@ -11,6 +13,7 @@ import (
 	"github.com/icholy/monkey/ast"
 	"github.com/icholy/monkey/object"
 	"github.com/icholy/monkey/parser"
+	"github.com/icholy/monkey/token"
 )
 
 var (
@@ -19,6 +22,9 @@ var (
 	NULL  = &object.Null{}
 
 	builtins = object.BuiltinMap()
+
+	errDivisionByZero = errors.New("division by zero")
+	errNegativeShift  = errors.New("negative shift count")
 )
 
 type Error struct {
@@ -27,7 +33,7 @@ type Error struct {
 }
 
 func (e Error) Error() string {
-	return fmt.Sprintf("%s: %s", e.Node.TokenPos(), e.Err)
+	return fmt.Sprintf("%s-%s: %s", e.Node.Pos(), e.Node.End(), e.Err)
 }
 
 func Eval(node ast.Node, env *object.Env) (object.Object, error) {
@@ -56,20 +62,20 @@ func eval(node ast.Node, env *object.Env) (object.Object, error) {
 		return evalBlockStatement(node, env)
 	case *ast.IfExpression:
 		return evalIfExpression(node, env)
-	case *ast.CallExpression:
-		fn, err := Eval(node.Function, env)
+	case *ast.ConditionalExpression:
+		cond, err := Eval(node.Condition, env)
 		if err != nil {
 			return nil, err
 		}
-		var params []object.Object
-		for _, arg := range node.Arguments {
-			val, err := Eval(arg, env)
-			if err != nil {
-				return nil, err
-			}
-			params = append(params, val)
+		if isTruthy(cond) {
+			return Eval(node.Consequence, env)
 		}
-		return applyFunction(fn, params)
+		return Eval(node.Alternative, env)
+	case *ast.PipelineExpression:
+		return Eval(node.Call(), env)
+	case *ast.CallExpression, *ast.PropertyExpression, *ast.IndexExpression, *ast.SliceExpression:
+		val, _, err := evalChain(node.(ast.Expression), env)
+		return val, err
 	case *ast.FunctionStatement:
 		env.Set(node.Name.Value, &object.Function{
 			Parameters: node.Parameters,
@@ -88,7 +94,27 @@ func eval(node ast.Node, env *object.Env) (object.Object, error) {
 	case *ast.Identifier:
 		return evalIdent(node, env)
 	case *ast.WhileStatement:
-		return evalWhile(node, env)
+		return evalWhile(node, "", env)
+	case *ast.ForStatement:
+		return evalFor(node, "", env)
+	case *ast.LabeledStatement:
+		switch stmt := node.Statement.(type) {
+		case *ast.WhileStatement:
+			return evalWhile(stmt, node.Label.Value, env)
+		case *ast.ForStatement:
+			return evalFor(stmt, node.Label.Value, env)
+		default:
+			return Eval(stmt, env)
+		}
+	case *ast.BranchStatement:
+		var label string
+		if node.Label != nil {
+			label = node.Label.Value
+		}
+		if node.Token.Is(token.CONTINUE) {
+			return &object.Continue{Label: label}, nil
+		}
+		return &object.Break{Label: label}, nil
 	case *ast.ReturnStatement:
 		if node.ReturnValue == nil {
 			return &object.ReturnValue{Value: NULL}, nil
@@ -100,8 +126,12 @@ func eval(node ast.Node, env *object.Env) (object.Object, error) {
 		return &object.ReturnValue{Value: val}, nil
 	case *ast.IntegerLiteral:
 		return &object.Integer{Value: node.Value}, nil
+	case *ast.FloatLiteral:
+		return &object.Float{Value: node.Value}, nil
 	case *ast.StringLiteral:
 		return &object.String{Value: node.Value}, nil
+	case *ast.TemplateLiteral:
+		return evalTemplate(node, env)
 	case *ast.BooleanExpression:
 		return boolToObject(node.Value), nil
 	case *ast.NullExpression:
@@ -117,6 +147,10 @@ func eval(node ast.Node, env *object.Env) (object.Object, error) {
 		if err != nil {
 			return nil, err
 		}
+		// the right side of ?? is only evaluated when the left is null
+		if node.Operator == "??" && !isNull(left) {
+			return left, nil
+		}
 		right, err := Eval(node.Right, env)
 		if err != nil {
 			return nil, err
@@ -124,6 +158,16 @@ func eval(node ast.Node, env *object.Env) (object.Object, error) {
 		return evalInfixExpression(node.Operator, left, right)
 	case *ast.SwitchStatement:
 		return evalSwitch(node, env)
+	case *ast.TryStatement:
+		return evalTry(node, env)
+	case *ast.ThrowStatement:
+		val, err := Eval(node.Value, env)
+		if err != nil {
+			return nil, err
+		}
+		return nil, &object.Exception{Value: val}
+	case *ast.DeferStatement:
+		return evalDefer(node, env)
 	case *ast.LetStatement:
 		val, err := Eval(node.Value, env)
 		if err != nil {
@@ -139,34 +183,20 @@ func eval(node ast.Node, env *object.Env) (object.Object, error) {
 				Object:     val,
 			}
 		}
+		if node.Pattern != nil {
+			if err := destructure(node.Pattern, val, env, declare(env)); err != nil {
+				return nil, err
+			}
+			return NULL, nil
+		}
 		env.Set(node.Name.Value, val)
 		return NULL, nil
-	case *ast.PropertyExpression:
-		left, err := Eval(node.Value, env)
-		if err != nil {
-			return nil, err
-		}
-		return evalProperty(left, node.Name, env)
 	case *ast.ArrayLiteral:
 		return evalArray(node, env)
 	case *ast.HashLiteral:
 		return evalHash(node, env)
 	case *ast.AssignmentExpression:
-		value, err := Eval(node.Value, env)
-		if err != nil {
-			return nil, err
-		}
-		return evalAssign(node.Left, value, env)
-	case *ast.IndexExpression:
-		left, err := Eval(node.Value, env)
-		if err != nil {
-			return nil, err
-		}
-		index, err := Eval(node.Index, env)
-		if err != nil {
-			return nil, err
-		}
-		return evalIndex(left, index)
+		return evalAssignment(node, env)
 	default:
 		return nil, fmt.Errorf("invalid node: %#v", node)
 	}
@@ -195,25 +225,103 @@ func applyFunction(fn object.Object, args []object.Object) (object.Object, error
 	if !ok {
 		return nil, fmt.Errorf("not a function: %s", fn.Type())
 	}
-	if len(function.Parameters) != len(args) {
-		return nil, fmt.Errorf("invalid number of function parameters")
+	if err := checkArgs(function.Parameters, len(args)); err != nil {
+		return nil, err
 	}
 	env := object.NewEnv(function.Env)
 	for i, param := range function.Parameters {
+		var arg object.Object
+		switch {
+		case param.Rest:
+			rest := &object.Array{}
+			if i < len(args) {
+				rest.Elements = append(rest.Elements, args[i:]...)
+			}
+			arg = rest
+		case i < len(args):
+			arg = args[i]
+		default:
+			// defaults are evaluated in the function's environment so
+			// they can refer to the preceding parameters
+			val, err := Eval(param.Default, env)
+			if err != nil {
+				return nil, err
+			}
+			arg = val
+		}
 		if param.Type != nil {
-			if err := typeCheck(param.Type, args[i]); err != nil {
+			if err := typeCheck(param.Type, arg); err != nil {
+				return nil, err
+			}
+		}
+		if param.Pattern != nil {
+			if err := destructure(param.Pattern, arg, env, declare(env)); err != nil {
 				return nil, err
 			}
+			continue
 		}
-		env.Set(param.Name.Value, args[i])
+		env.Set(param.Name.Value, arg)
 	}
 	val, err := Eval(function.Body, env)
+	// deferred calls are made last to first even when the body failed,
+	// and an error from one replaces the earlier result
+	defers := env.Deferred()
+	for i := len(defers) - 1; i >= 0; i-- {
+		if derr := defers[i](); derr != nil {
+			err = derr
+		}
+	}
 	if err != nil {
 		return nil, err
 	}
 	return object.UnwrapReturn(val), nil
 }
 
+// evalDefer evaluates the function and arguments of the call now and
+// defers calling it until the function returns.
+func evalDefer(d *ast.DeferStatement, env *object.Env) (object.Object, error) {
+	fn, skipped, err := evalOperand(d.Call.Function, env)
+	if err != nil {
+		return nil, err
+	}
+	if skipped || d.Call.Optional && isNull(fn) {
+		return NULL, nil
+	}
+	args, err := evalElements(d.Call.Arguments, env)
+	if err != nil {
+		return nil, err
+	}
+	env.Defer(func() error {
+		_, err := applyFunction(fn, args)
+		if _, ok := err.(*Error); err != nil && !ok {
+			err = &Error{Node: d, Err: err}
+		}
+		return err
+	})
+	return NULL, nil
+}
+
+// checkArgs checks that n arguments can be passed to a function with
+// params.
+func checkArgs(params []*ast.Parameter, n int) error {
+	var required int
+	for i, p := range params {
+		if p.Rest {
+			if n < required {
+				return fmt.Errorf("invalid number of function parameters")
+			}
+			return nil
+		}
+		if p.Default == nil {
+			required = i + 1
+		}
+	}
+	if n < required || n > len(params) {
+		return fmt.Errorf("invalid number of function parameters")
+	}
+	return nil
+}
+
 func evalDebugger(env *object.Env) (object.Object, error) {
 	rl, err := readline.New(Prompt)
 	if err != nil {
@@ -266,7 +374,7 @@ func evalSwitch(s *ast.SwitchStatement, env *object.Env) (object.Object, error)
 				if err != nil {
 					return nil, err
 				}
-				if val.Type() == object.RETURN {
+				if isBranch(val) {
 					return val, nil
 				}
 			}
@@ -278,14 +386,14 @@ func evalSwitch(s *ast.SwitchStatement, env *object.Env) (object.Object, error)
 		if err != nil {
 			return nil, err
 		}
-		if val.Type() == object.RETURN {
+		if isBranch(val) {
 			return val, nil
 		}
 	}
 	return NULL, nil
 }
 
-func evalWhile(w *ast.WhileStatement, env *object.Env) (object.Object, error) {
+func evalWhile(w *ast.WhileStatement, label string, env *object.Env) (object.Object, error) {
 	for {
 		ok, err := Eval(w.Condition, env)
 		if err != nil {
@@ -298,13 +406,199 @@ func evalWhile(w *ast.WhileStatement, env *object.Env) (object.Object, error) {
 		if err != nil {
 			return nil, err
 		}
-		if val.Type() == object.RETURN {
-			return val, nil
+		if done, result := loopBranch(val, label); done {
+			if result != nil {
+				return result, nil
+			}
+			break
+		}
+	}
+	return NULL, nil
+}
+
+// evalFor assigns the loop variables in env, so there's one of each for
+// the whole loop. Closures created in the body all see the last values.
+func evalFor(f *ast.ForStatement, label string, env *object.Env) (object.Object, error) {
+	iterable, err := Eval(f.Iterable, env)
+	if err != nil {
+		return nil, err
+	}
+	it, err := object.NewIterator(iterable)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		key, value, ok := it.Next()
+		if !ok {
+			break
+		}
+		if f.Key != nil {
+			env.Set(f.Key.Value, key)
+			env.Set(f.Value.Value, value)
+		} else {
+			env.Set(f.Value.Value, it.Single(key, value))
+		}
+		val, err := Eval(f.Body, env)
+		if err != nil {
+			return nil, err
+		}
+		if done, result := loopBranch(val, label); done {
+			if result != nil {
+				return result, nil
+			}
+			break
 		}
 	}
 	return NULL, nil
 }
 
+// evalTry runs the finally block after the others whatever they did.
+// Branches and errors in the finally block replace the earlier result.
+func evalTry(t *ast.TryStatement, env *object.Env) (object.Object, error) {
+	val, err := Eval(t.Block, env)
+	if err != nil && t.Catch != nil {
+		if t.Param != nil {
+			env.Set(t.Param.Value, thrown(err))
+		}
+		val, err = Eval(t.Catch, env)
+	}
+	if t.Finally != nil {
+		fval, ferr := Eval(t.Finally, env)
+		if ferr != nil {
+			return nil, ferr
+		}
+		if isBranch(fval) {
+			return fval, nil
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if isBranch(val) {
+		return val, nil
+	}
+	return NULL, nil
+}
+
+// thrown returns the value a catch block receives for err. Errors which
+// weren't thrown are converted to error objects.
+func thrown(err error) object.Object {
+	if e, ok := err.(*Error); ok {
+		err = e.Err
+	}
+	if e, ok := err.(*object.Exception); ok {
+		return e.Value
+	}
+	return &object.Error{Message: err.Error()}
+}
+
+// isBranch reports whether val stops the statements around it from
+// running.
+func isBranch(val object.Object) bool {
+	switch val.Type() {
+	case object.RETURN, object.BREAK, object.CONTINUE:
+		return true
+	default:
+		return false
+	}
+}
+
+// loopBranch decides what a loop does after its body evaluated to val.
+// It returns done when the loop should stop, and a result when val
+// targets a statement outside of the loop.
+func loopBranch(val object.Object, label string) (done bool, result object.Object) {
+	switch val := val.(type) {
+	case *object.ReturnValue:
+		return true, val
+	case *object.Break:
+		if val.Label == "" || val.Label == label {
+			return true, nil
+		}
+		return true, val
+	case *object.Continue:
+		if val.Label == "" || val.Label == label {
+			return false, nil
+		}
+		return true, val
+	default:
+		return false, nil
+	}
+}
+
+// evalAssignment evaluates the operands of the target, then its old
+// value for compound assignments, and then the new value. Each of them
+// is only evaluated once.
+func evalAssignment(a *ast.AssignmentExpression, env *object.Env) (object.Object, error) {
+	switch left := a.Left.(type) {
+	case *ast.ArrayPattern, *ast.HashPattern:
+		value, err := Eval(a.Value, env)
+		if err != nil {
+			return nil, err
+		}
+		err = destructure(left, value, env, func(target ast.Expression, val object.Object) error {
+			_, err := evalAssign(target, val, env)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		return NULL, nil
+	case *ast.IndexExpression:
+		dest, err := Eval(left.Value, env)
+		if err != nil {
+			return nil, err
+		}
+		index, err := Eval(left.Index, env)
+		if err != nil {
+			return nil, err
+		}
+		value, err := evalAssignValue(a, env, func() (object.Object, error) {
+			return evalIndex(dest, index)
+		})
+		if err != nil {
+			return nil, err
+		}
+		return evalAssignIndex(dest, index, value, env)
+	case *ast.PropertyExpression:
+		dest, err := Eval(left.Value, env)
+		if err != nil {
+			return nil, err
+		}
+		value, err := evalAssignValue(a, env, func() (object.Object, error) {
+			return evalProperty(dest, left.Name, env)
+		})
+		if err != nil {
+			return nil, err
+		}
+		return evalAssignProperty(dest, left.Name, value)
+	default:
+		value, err := evalAssignValue(a, env, func() (object.Object, error) {
+			return Eval(left, env)
+		})
+		if err != nil {
+			return nil, err
+		}
+		return evalAssign(left, value, env)
+	}
+}
+
+// evalAssignValue evaluates the value of a, combining it with the old
+// value returned by current for compound assignments.
+func evalAssignValue(a *ast.AssignmentExpression, env *object.Env, current func() (object.Object, error)) (object.Object, error) {
+	if a.Operator == "=" {
+		return Eval(a.Value, env)
+	}
+	old, err := current()
+	if err != nil {
+		return nil, err
+	}
+	value, err := Eval(a.Value, env)
+	if err != nil {
+		return nil, err
+	}
+	return evalInfixExpression(strings.TrimSuffix(a.Operator, "="), old, value)
+}
+
 func evalAssign(left ast.Expression, val object.Object, env *object.Env) (object.Object, error) {
 	switch node := left.(type) {
 	case *ast.Identifier:
@@ -317,12 +611,7 @@ func evalAssign(left ast.Expression, val object.Object, env *object.Env) (object
 		if err != nil {
 			return nil, err
 		}
-		hash, ok := dest.(*object.Hash)
-		if !ok {
-			return nil, fmt.Errorf("cannot access property on %s", dest.Type())
-		}
-		hash.Set(&object.String{Value: node.Name.Value}, val)
-		return NULL, nil
+		return evalAssignProperty(dest, node.Name, val)
 	case *ast.IndexExpression:
 		dest, err := Eval(node.Value, env)
 		if err != nil {
@@ -338,6 +627,82 @@ func evalAssign(left ast.Expression, val object.Object, env *object.Env) (object
 	}
 }
 
+// destructure unpacks val into the targets of pattern. Targets which
+// aren't patterns are passed to bind.
+func destructure(pattern ast.Expression, val object.Object, env *object.Env, bind func(ast.Expression, object.Object) error) error {
+	switch pattern := pattern.(type) {
+	case *ast.ArrayPattern:
+		arr, ok := val.(*object.Array)
+		if !ok {
+			return fmt.Errorf("cannot destructure %s as array", val.Type())
+		}
+		for i, elem := range pattern.Elements {
+			var v object.Object = NULL
+			if arr.InRange(i) {
+				v = arr.Elements[i]
+			}
+			if err := destructure(elem, v, env, bind); err != nil {
+				return err
+			}
+		}
+		if pattern.Rest != nil {
+			rest := &object.Array{}
+			if n := len(pattern.Elements); n < len(arr.Elements) {
+				rest.Elements = append(rest.Elements, arr.Elements[n:]...)
+			}
+			return destructure(pattern.Rest, rest, env, bind)
+		}
+		return nil
+	case *ast.HashPattern:
+		hash, ok := val.(*object.Hash)
+		if !ok {
+			return fmt.Errorf("cannot destructure %s as hash", val.Type())
+		}
+		for _, pair := range pattern.Pairs {
+			var key object.Object
+			if ident, ok := pair.Key.(*ast.Identifier); ok {
+				key = &object.String{Value: ident.Value}
+			} else {
+				var err error
+				if key, err = Eval(pair.Key, env); err != nil {
+					return err
+				}
+			}
+			v, ok := hash.Get(key)
+			if !ok {
+				v = NULL
+			}
+			if err := destructure(pair.Value, v, env, bind); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return bind(pattern, val)
+	}
+}
+
+// declare returns a destructure binder which defines variables in env.
+func declare(env *object.Env) func(ast.Expression, object.Object) error {
+	return func(target ast.Expression, val object.Object) error {
+		ident, ok := target.(*ast.Identifier)
+		if !ok {
+			return fmt.Errorf("invalid destructuring target: %s", target)
+		}
+		env.Set(ident.Value, val)
+		return nil
+	}
+}
+
+func evalAssignProperty(dest object.Object, name *ast.Identifier, val object.Object) (object.Object, error) {
+	hash, ok := dest.(*object.Hash)
+	if !ok {
+		return nil, fmt.Errorf("cannot access property on %s", dest.Type())
+	}
+	hash.Set(&object.String{Value: name.Value}, val)
+	return NULL, nil
+}
+
 func evalAssignIndex(dest, index, val object.Object, env *object.Env) (object.Object, error) {
 	switch obj := dest.(type) {
 	case *object.Array:
@@ -345,7 +710,9 @@ func evalAssignIndex(dest, index, val object.Object, env *object.Env) (object.Ob
 		if !ok {
 			return nil, fmt.Errorf("index must be an integer %s", index.Type())
 		}
-		obj.SetAt(int(idx.Value), val)
+		if err := obj.SetAt(int(idx.Value), val); err != nil {
+			return nil, err
+		}
 	case *object.Hash:
 		obj.Set(index, val)
 	default:
@@ -356,11 +723,12 @@ func evalAssignIndex(dest, index, val object.Object, env *object.Env) (object.Ob
 
 func evalImport(i *ast.ImportStatement, env *object.Env) (object.Object, error) {
 	if i.Program == nil {
-		data, err := ioutil.ReadFile(i.Value)
+		f, err := os.Open(i.Value)
 		if err != nil {
 			return nil, fmt.Errorf("import: %s", err)
 		}
-		p, err := parser.Parse(string(data))
+		defer f.Close()
+		p, err := parser.ParseReader(f)
 		if err != nil {
 			return nil, fmt.Errorf("import: %s", err)
 		}
@@ -370,17 +738,134 @@ func evalImport(i *ast.ImportStatement, env *object.Env) (object.Object, error)
 }
 
 func evalProperty(left object.Object, name *ast.Identifier, env *object.Env) (object.Object, error) {
-	hash, ok := left.(*object.Hash)
-	if !ok {
-		return nil, fmt.Errorf("cannot access '%s' of %s", name, left.Type())
+	val, ok, err := property(left, name)
+	if err != nil {
+		return nil, err
 	}
-	val, ok := hash.Get(&object.String{Value: name.Value})
 	if !ok {
 		return nil, fmt.Errorf("property not found: %s", name)
 	}
 	return val, nil
 }
 
+// property returns the named property of a hash or error, and whether
+// it was found.
+func property(left object.Object, name *ast.Identifier) (object.Object, bool, error) {
+	key := &object.String{Value: name.Value}
+	switch left := left.(type) {
+	case *object.Hash:
+		val, ok := left.Get(key)
+		return val, ok, nil
+	case *object.Error:
+		val, ok := left.Get(key)
+		return val, ok, nil
+	default:
+		return nil, false, fmt.Errorf("cannot access '%s' of %s", name, left.Type())
+	}
+}
+
+// evalChain evaluates a call, property, index or slice expression. When
+// an optional link of the chain finds null, the rest of the chain is
+// skipped and evalChain reports true.
+func evalChain(node ast.Expression, env *object.Env) (object.Object, bool, error) {
+	var (
+		operand  ast.Expression
+		optional bool
+	)
+	switch node := node.(type) {
+	case *ast.CallExpression:
+		operand, optional = node.Function, node.Optional
+	case *ast.PropertyExpression:
+		operand, optional = node.Value, node.Optional
+	case *ast.IndexExpression:
+		operand, optional = node.Value, node.Optional
+	case *ast.SliceExpression:
+		operand, optional = node.Value, node.Optional
+	}
+	left, skipped, err := evalOperand(operand, env)
+	if err != nil {
+		return nil, false, err
+	}
+	if skipped || optional && isNull(left) {
+		return NULL, true, nil
+	}
+	var (
+		val   object.Object
+		args  []object.Object
+		index object.Object
+	)
+	switch node := node.(type) {
+	case *ast.CallExpression:
+		if args, err = evalElements(node.Arguments, env); err != nil {
+			return nil, false, err
+		}
+		val, err = applyFunction(left, args)
+	case *ast.PropertyExpression:
+		if optional {
+			val, err = evalOptionalProperty(left, node.Name, env)
+		} else {
+			val, err = evalProperty(left, node.Name, env)
+		}
+	case *ast.IndexExpression:
+		if index, err = Eval(node.Index, env); err != nil {
+			return nil, false, err
+		}
+		val, err = evalIndex(left, index)
+	case *ast.SliceExpression:
+		val, err = evalSlice(node, left, env)
+	}
+	return val, false, err
+}
+
+// evalOperand evaluates the operand of a chain link, continuing the
+// chain when it's a link itself.
+func evalOperand(e ast.Expression, env *object.Env) (object.Object, bool, error) {
+	switch e.(type) {
+	case *ast.CallExpression, *ast.PropertyExpression, *ast.IndexExpression, *ast.SliceExpression:
+		val, skipped, err := evalChain(e, env)
+		if _, ok := err.(*Error); err != nil && !ok {
+			err = &Error{Node: e, Err: err}
+		}
+		return val, skipped, err
+	default:
+		val, err := Eval(e, env)
+		return val, false, err
+	}
+}
+
+func evalSlice(s *ast.SliceExpression, value object.Object, env *object.Env) (object.Object, error) {
+	var err error
+	low, high := object.Object(NULL), object.Object(NULL)
+	if s.Low != nil {
+		if low, err = Eval(s.Low, env); err != nil {
+			return nil, err
+		}
+	}
+	if s.High != nil {
+		if high, err = Eval(s.High, env); err != nil {
+			return nil, err
+		}
+	}
+	return object.Slice(value, low, high)
+}
+
+// evalOptionalProperty is like evalProperty, but it returns null when
+// left doesn't have the property.
+func evalOptionalProperty(left object.Object, name *ast.Identifier, env *object.Env) (object.Object, error) {
+	val, ok, err := property(left, name)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return NULL, nil
+	}
+	return val, nil
+}
+
+func isNull(obj object.Object) bool {
+	return obj.Type() == object.NULL
+}
+
 func evalIndex(left, index object.Object) (object.Object, error) {
 	switch obj := left.(type) {
 	case *object.Hash:
@@ -388,6 +873,11 @@ func evalIndex(left, index object.Object) (object.Object, error) {
 			return value, nil
 		}
 		return NULL, nil
+	case *object.Error:
+		if value, ok := obj.Get(index); ok {
+			return value, nil
+		}
+		return NULL, nil
 	case *object.Array:
 		idx, ok := index.(*object.Integer)
 		if !ok {
@@ -405,6 +895,21 @@ func evalIndex(left, index object.Object) (object.Object, error) {
 	}
 }
 
+func evalTemplate(t *ast.TemplateLiteral, env *object.Env) (object.Object, error) {
+	var b strings.Builder
+	for i, s := range t.Strings {
+		b.WriteString(s)
+		if i < len(t.Values) {
+			val, err := Eval(t.Values[i], env)
+			if err != nil {
+				return nil, err
+			}
+			b.WriteString(object.ToString(val))
+		}
+	}
+	return &object.String{Value: b.String()}, nil
+}
+
 func evalHash(h *ast.HashLiteral, env *object.Env) (object.Object, error) {
 	hash := object.NewHash()
 	for _, p := range h.Pairs {
@@ -422,15 +927,37 @@ func evalHash(h *ast.HashLiteral, env *object.Env) (object.Object, error) {
 }
 
 func evalArray(a *ast.ArrayLiteral, env *object.Env) (object.Object, error) {
+	elements, err := evalElements(a.Elements, env)
+	if err != nil {
+		return nil, err
+	}
+	return &object.Array{Elements: elements}, nil
+}
+
+// evalElements evaluates the elements of an array literal or the
+// arguments of a call, expanding spread arrays.
+func evalElements(exprs []ast.Expression, env *object.Env) ([]object.Object, error) {
 	var elements []object.Object
-	for _, e := range a.Elements {
+	for _, e := range exprs {
+		if spread, ok := e.(*ast.SpreadExpression); ok {
+			val, err := Eval(spread.Value, env)
+			if err != nil {
+				return nil, err
+			}
+			arr, ok := val.(*object.Array)
+			if !ok {
+				return nil, fmt.Errorf("cannot spread %s", val.Type())
+			}
+			elements = append(elements, arr.Elements...)
+			continue
+		}
 		val, err := Eval(e, env)
 		if err != nil {
 			return nil, err
 		}
 		elements = append(elements, val)
 	}
-	return &object.Array{Elements: elements}, nil
+	return elements, nil
 }
 
 func evalIdent(i *ast.Identifier, env *object.Env) (object.Object, error) {
@@ -470,7 +997,7 @@ func evalBlockStatement(block *ast.BlockStatement, env *object.Env) (object.Obje
 		if err != nil {
 			return nil, err
 		}
-		if last.Type() == object.RETURN {
+		if isBranch(last) {
 			return last, nil
 		}
 	}
@@ -493,11 +1020,19 @@ func evalInfixExpression(operator string, left, right object.Object) (object.Obj
 		return boolToObject(!ok), nil
 	case "in":
 		return evalInfixInExpression(left, right)
+	case "??":
+		if isNull(left) {
+			return right, nil
+		}
+		return left, nil
 	case "||":
 		return boolToObject(isTruthy(left) || isTruthy(right)), nil
 	case "&&":
 		return boolToObject(isTruthy(left) && isTruthy(right)), nil
 	}
+	if isNumeric(left) && isNumeric(right) && (left.Type() == object.FLOAT || right.Type() == object.FLOAT) {
+		return evalFloatInfixExpression(operator, toFloat(left), toFloat(right))
+	}
 	if left.Type() != right.Type() {
 		return nil, fmt.Errorf("type mismatch: %s %s %s", left.Type(), operator, right.Type())
 	}
@@ -532,6 +1067,9 @@ func objectsEqual(left, right object.Object) (bool, error) {
 	if left == NULL || right == NULL {
 		return left == right, nil
 	}
+	if isNumeric(left) && isNumeric(right) && left.Type() != right.Type() {
+		return toFloat(left) == toFloat(right), nil
+	}
 	if left.Type() != right.Type() {
 		return false, fmt.Errorf("type mismatch: %s == %s", left.Type(), right.Type())
 	}
@@ -544,6 +1082,10 @@ func objectsEqual(left, right object.Object) (bool, error) {
 		lint := left.(*object.Integer)
 		rint := right.(*object.Integer)
 		return lint.Value == rint.Value, nil
+	case object.FLOAT:
+		lfloat := left.(*object.Float)
+		rfloat := right.(*object.Float)
+		return lfloat.Value == rfloat.Value, nil
 	default:
 		return left == right, nil
 	}
@@ -579,7 +1121,36 @@ func evalIntegerInfixExpression(operator string, left, right *object.Integer) (o
 	case "*":
 		return &object.Integer{Value: left.Value * right.Value}, nil
 	case "/":
+		if right.Value == 0 {
+			return nil, errDivisionByZero
+		}
 		return &object.Integer{Value: left.Value / right.Value}, nil
+	case "%":
+		if right.Value == 0 {
+			return nil, errDivisionByZero
+		}
+		return &object.Integer{Value: left.Value % right.Value}, nil
+	case "**":
+		if right.Value < 0 {
+			return &object.Float{Value: math.Pow(float64(left.Value), float64(right.Value))}, nil
+		}
+		return &object.Integer{Value: intPow(left.Value, right.Value)}, nil
+	case "&":
+		return &object.Integer{Value: left.Value & right.Value}, nil
+	case "|":
+		return &object.Integer{Value: left.Value | right.Value}, nil
+	case "^":
+		return &object.Integer{Value: left.Value ^ right.Value}, nil
+	case "<<":
+		if right.Value < 0 {
+			return nil, errNegativeShift
+		}
+		return &object.Integer{Value: left.Value << uint64(right.Value)}, nil
+	case ">>":
+		if right.Value < 0 {
+			return nil, errNegativeShift
+		}
+		return &object.Integer{Value: left.Value >> uint64(right.Value)}, nil
 	case "<":
 		return boolToObject(left.Value < right.Value), nil
 	case ">":
@@ -597,6 +1168,70 @@ func evalIntegerInfixExpression(operator string, left, right *object.Integer) (o
 	}
 }
 
+func evalFloatInfixExpression(operator string, left, right float64) (object.Object, error) {
+	switch operator {
+	case "+":
+		return &object.Float{Value: left + right}, nil
+	case "-":
+		return &object.Float{Value: left - right}, nil
+	case "*":
+		return &object.Float{Value: left * right}, nil
+	case "/":
+		if right == 0 {
+			return nil, errDivisionByZero
+		}
+		return &object.Float{Value: left / right}, nil
+	case "%":
+		if right == 0 {
+			return nil, errDivisionByZero
+		}
+		return &object.Float{Value: math.Mod(left, right)}, nil
+	case "**":
+		return &object.Float{Value: math.Pow(left, right)}, nil
+	case "<":
+		return boolToObject(left < right), nil
+	case ">":
+		return boolToObject(left > right), nil
+	case ">=":
+		return boolToObject(left >= right), nil
+	case "<=":
+		return boolToObject(left <= right), nil
+	case "==":
+		return boolToObject(left == right), nil
+	case "!=":
+		return boolToObject(left != right), nil
+	default:
+		return nil, fmt.Errorf("unknown operator: %s %s %s", object.FLOAT, operator, object.FLOAT)
+	}
+}
+
+func intPow(x, n int64) int64 {
+	result := int64(1)
+	for n > 0 {
+		if n&1 == 1 {
+			result *= x
+		}
+		x *= x
+		n >>= 1
+	}
+	return result
+}
+
+func isNumeric(obj object.Object) bool {
+	return obj.Type() == object.INTEGER || obj.Type() == object.FLOAT
+}
+
+func toFloat(obj object.Object) float64 {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return float64(obj.Value)
+	case *object.Float:
+		return obj.Value
+	default:
+		return 0
+	}
+}
+
 func isTruthy(obj object.Object) bool {
 	switch obj {
 	case FALSE, NULL:
@@ -640,6 +1275,11 @@ func evalPrefixExpression(operator string, right object.Object) (object.Object,
 		return evalBangOperatorExpression(right)
 	case "-":
 		return evalMinusPrefixOperatorExpression(right)
+	case "~":
+		if right, ok := right.(*object.Integer); ok {
+			return &object.Integer{Value: ^right.Value}, nil
+		}
+		return nil, fmt.Errorf("unknown operator: ~%s", right.Type())
 	default:
 		return nil, fmt.Errorf("unknown operator: %s%s", operator, right.Type())
 	}
@@ -659,8 +1299,11 @@ func evalBangOperatorExpression(right object.Object) (object.Object, error) {
 }
 
 func evalMinusPrefixOperatorExpression(right object.Object) (object.Object, error) {
-	if obj, ok := right.(*object.Integer); ok {
+	switch obj := right.(type) {
+	case *object.Integer:
 		return &object.Integer{Value: -obj.Value}, nil
+	case *object.Float:
+		return &object.Float{Value: -obj.Value}, nil
 	}
 	return nil, fmt.Errorf("unknown operator: -%s", right.Type())
 }
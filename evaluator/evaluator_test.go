@@ -17,6 +17,22 @@ func TestEvaluator(t *testing.T) {
 		RequireEqualEval(t, "(2 * 2) + 1", &object.Integer{5})
 	})
 
+	t.Run("float", func(t *testing.T) {
+		RequireEqualEval(t, "1.5", &object.Float{Value: 1.5})
+		RequireEqualEval(t, "-2.5", &object.Float{Value: -2.5})
+		RequireEqualEval(t, "1.5 + 1", &object.Float{Value: 2.5})
+		RequireEqualEval(t, "1 / 2.0", &object.Float{Value: 0.5})
+		RequireEqualEval(t, "2 * 0.25", &object.Float{Value: 0.5})
+		RequireEqualEval(t, "1.5 > 1", TRUE)
+		RequireEqualEval(t, "1 == 1.0", TRUE)
+		RequireEqualEval(t, "0.1 != 0.1", FALSE)
+		RequireEqualEval(t, "{1.0: 2}[1]", &object.Integer{Value: 2})
+		RequireEqualEval(t, "{1: 2}[1.0]", &object.Integer{Value: 2})
+		RequireEqualEval(t, "{1.5: 2}[1.5]", &object.Integer{Value: 2})
+		RequireEqualEval(t, "len({1: 1, 1.0: 2})", &object.Integer{Value: 1})
+		RequireEvalError(t, `1.5 + "x"`, "1:1-1:10: type mismatch: FLOAT + STRING")
+	})
+
 	t.Run("boolean expressions", func(t *testing.T) {
 		RequireEqualEval(t, "true", TRUE)
 		RequireEqualEval(t, "false", FALSE)
@@ -55,12 +71,40 @@ func TestEvaluator(t *testing.T) {
 	})
 
 	t.Run("errors", func(t *testing.T) {
-		RequireEvalError(t, "-true", "1:1: unknown operator: -BOOLEAN")
-		RequireEvalError(t, "5 + true;", "1:3: type mismatch: INTEGER + BOOLEAN")
-		RequireEvalError(t, "5 + true; 5;", "1:3: type mismatch: INTEGER + BOOLEAN")
-		RequireEvalError(t, "true + false", "1:6: unknown operator: BOOLEAN + BOOLEAN")
-		RequireEvalError(t, "5; true + false; 5", "1:9: unknown operator: BOOLEAN + BOOLEAN")
-		RequireEvalError(t, "if (10 > 1) { true + false; }", "1:20: unknown operator: BOOLEAN + BOOLEAN")
+		RequireEvalError(t, "-true", "1:1-1:6: unknown operator: -BOOLEAN")
+		RequireEvalError(t, "5 + true;", "1:1-1:9: type mismatch: INTEGER + BOOLEAN")
+		RequireEvalError(t, "5 + true; 5;", "1:1-1:9: type mismatch: INTEGER + BOOLEAN")
+		RequireEvalError(t, "true + false", "1:1-1:13: unknown operator: BOOLEAN + BOOLEAN")
+		RequireEvalError(t, "5; true + false; 5", "1:4-1:16: unknown operator: BOOLEAN + BOOLEAN")
+		RequireEvalError(t, "if (10 > 1) { true + false; }", "1:15-1:27: unknown operator: BOOLEAN + BOOLEAN")
+		RequireEvalError(t, `"日本" + 1`, "1:1-1:9: type mismatch: STRING + INTEGER")
+		RequireEvalError(t, "1 / 0", "1:1-1:6: division by zero")
+		RequireEvalError(t, "1 % 0", "1:1-1:6: division by zero")
+		RequireEvalError(t, "1.5 / 0", "1:1-1:8: division by zero")
+		RequireEvalError(t, "1 >> -1", "1:1-1:8: negative shift count")
+		RequireEvalError(t, "1.5 & 1", "1:1-1:8: unknown operator: FLOAT & FLOAT")
+		RequireEvalError(t, "~true", "1:1-1:6: unknown operator: ~BOOLEAN")
+	})
+
+	t.Run("arithmetic and bitwise operators", func(t *testing.T) {
+		RequireEqualEval(t, "7 % 3", object.New(1))
+		RequireEqualEval(t, "-7 % 3", object.New(-1))
+		RequireEqualEval(t, "7.5 % 2", object.New(1.5))
+		RequireEqualEval(t, "2 ** 10", object.New(1024))
+		RequireEqualEval(t, "2 ** 3 ** 2", object.New(512))
+		RequireEqualEval(t, "2 ** -1", object.New(0.5))
+		RequireEqualEval(t, "6 & 3 | 8 ^ 1", object.New(11))
+		RequireEqualEval(t, "1 << 4 >> 2", object.New(4))
+		RequireEqualEval(t, "~5", object.New(-6))
+	})
+
+	t.Run("compound assignment", func(t *testing.T) {
+		RequireEqualEval(t, "let x = 5; x += 2; x *= 3; x -= 1; x /= 4; x %= 3; x", object.New(2))
+		RequireEqualEval(t, "let a = [1, 2]; a[0] += 10; a[0]", object.New(11))
+		RequireEqualEval(t, `let h = {"x": 1}; h.x += 1; h.x`, object.New(2))
+		RequireEvalError(t, "let s = 1; s += true", "1:12-1:21: type mismatch: INTEGER + BOOLEAN")
+		RequireEqualEval(t, "let n = 0; let f = fn() { n += 1; 0 }; let a = [1, 2]; a[f()] += 10; [a, n]", object.New([]interface{}{[]interface{}{11, 2}, 1}))
+		RequireEqualEval(t, `let log = []; let h = {"x": 1}; let f = fn() { append(log, "h"); h }; let g = fn() { append(log, "v"); 1 }; f().x += g(); f()["x"] = g(); [h.x, log]`, object.New([]interface{}{1, []interface{}{"h", "v", "h", "v"}}))
 	})
 
 	t.Run("let statement", func(t *testing.T) {
@@ -74,6 +118,13 @@ func TestEvaluator(t *testing.T) {
 		RequireEqualEval(t, "let twice = fn(f, x) { return f(f(x)) }; let inc = fn(x) { x + 1}; twice(inc, 0)", &object.Integer{2})
 	})
 
+	t.Run("closures", func(t *testing.T) {
+		RequireEqualEval(t, "fn() { let x = 1; let g = fn() { x }; x = 2; g() }()", &object.Integer{2})
+		RequireEqualEval(t, "let counter = fn() { let n = 0; fn() { n += 1; n } }; let c = counter(); c(); c()", &object.Integer{2})
+		RequireEqualEval(t, "let f = fn() { let n = 0; [fn() { n += 1 }, fn() { n }] }; let p = f(); p[0](); p[0](); p[1]()", &object.Integer{2})
+		RequireEqualEval(t, "fn() { let n = 0; let inc = fn() { fn() { n += 1 } }; inc()(); n }()", &object.Integer{1})
+	})
+
 	t.Run("strings", func(t *testing.T) {
 		RequireEqualEval(t, `"hello" + "world"`, &object.String{"helloworld"})
 		RequireEqualEval(t, `"foo" != "bar"`, TRUE)
@@ -84,11 +135,19 @@ func TestEvaluator(t *testing.T) {
 		RequireEqualEval(t, `"aaa" == "aaa"`, TRUE)
 	})
 
+	t.Run("template literal", func(t *testing.T) {
+		RequireEqualEval(t, `let x = 1; let y = 2; "x=${x}, y=${y + 1}"`, object.New("x=1, y=3"))
+		RequireEqualEval(t, `"${"a"}${[1]}${true}"`, object.New("a[\n  1\n]true"))
+		RequireEqualEval(t, `"${"${1}"}"`, object.New("1"))
+		RequireEvalError(t, `"${y}"`, "1:4-1:5: identifier not found: y")
+	})
+
 	t.Run("builtin", func(t *testing.T) {
 		RequireEqualEval(t, `len("hello world")`, &object.Integer{11})
 		RequireEqualEval(t, `len("")`, &object.Integer{0})
-		RequireEvalError(t, `len(1)`, "1:4: len: invalid argument type INTEGER")
-		RequireEvalError(t, `len("one", "two")`, "1:4: len: wrong number of arguments")
+		RequireEqualEval(t, `len("日本語")`, &object.Integer{3})
+		RequireEvalError(t, `len(1)`, "1:1-1:7: len: invalid argument type INTEGER")
+		RequireEvalError(t, `len("one", "two")`, "1:1-1:18: len: wrong number of arguments")
 		RequireEqualEval(t, `len([])`, &object.Integer{0})
 		RequireEqualEval(t, `let x = append([], 1, 2); x[(len(x) - 1)]`, &object.Integer{2})
 	})
@@ -130,6 +189,67 @@ func TestEvaluator(t *testing.T) {
 		RequireEqualEval(t, "{}[0]", NULL)
 		RequireEqualEval(t, "let x = { true: 123, false: 321 }; x[false]", &object.Integer{321})
 		RequireEqualEval(t, `"test"[0]`, &object.String{"t"})
+		RequireEqualEval(t, `"héllo"[1]`, &object.String{"é"})
+		RequireEqualEval(t, `"日本語"[2]`, &object.String{"語"})
+		RequireEqualEval(t, "[1, 2, 3][-1]", &object.Integer{3})
+		RequireEqualEval(t, `"héllo"[-4]`, &object.String{"é"})
+		RequireEqualEval(t, `"héllo"[4]`, &object.String{"o"})
+		RequireEqualEval(t, `"héllo"[-5]`, &object.String{"h"})
+		RequireEvalError(t, `"héllo"[5]`, "1:1-1:11: 5 out of range")
+		RequireEvalError(t, `"héllo"[-6]`, "1:1-1:12: -6 out of range")
+		RequireEvalError(t, `"abc"[3]`, "1:1-1:9: 3 out of range")
+		RequireEqualEval(t, "let x = [1, 2]; x[-2] = 5; x[0]", &object.Integer{5})
+		RequireEvalError(t, "[1, 2, 3][-4]", "1:1-1:14: -4 not in range")
+	})
+
+	t.Run("slice", func(t *testing.T) {
+		RequireEqualEval(t, "[1, 2, 3, 4][1:3]", &object.Array{Elements: []object.Object{&object.Integer{2}, &object.Integer{3}}})
+		RequireEqualEval(t, "[1, 2, 3][:-1]", &object.Array{Elements: []object.Object{&object.Integer{1}, &object.Integer{2}}})
+		RequireEqualEval(t, "[1, 2, 3][3:]", &object.Array{Elements: []object.Object{}})
+		RequireEqualEval(t, `"héllo"[1:3]`, &object.String{"él"})
+		RequireEqualEval(t, `"héllo"[-2:]`, &object.String{"lo"})
+		RequireEqualEval(t, "let x = [1, 2]; let y = x[:]; y[0] = 5; x[0]", &object.Integer{1})
+		RequireEqualEval(t, "let x = [1, 2]; let y = rest(x); y[0] = 5; x[1]", &object.Integer{2})
+		RequireEvalError(t, "[1, 2][1:0]", "1:1-1:12: invalid slice indices: 1 > 0")
+		RequireEvalError(t, "[1, 2][:3]", "1:1-1:11: slice bound 3 out of range")
+		RequireEvalError(t, `[1, 2]["a":]`, "1:1-1:13: slice bound must be an integer, got STRING")
+		RequireEvalError(t, "{}[1:]", "1:1-1:7: cannot slice HASH")
+	})
+
+	t.Run("arrow functions", func(t *testing.T) {
+		RequireEqualEval(t, "let double = x => x * 2; double(4)", &object.Integer{8})
+		RequireEqualEval(t, "((a, b) => { let c = a + b; c * 2 })(1, 2)", &object.Integer{6})
+		RequireEqualEval(t, "(() => 5)()", &object.Integer{5})
+		RequireEqualEval(t, "let add = x => y => x + y; add(1)(2)", &object.Integer{3})
+		RequireEqualEval(t, "((a, b = 2, ...c) => a + b + len(c))(1)", &object.Integer{3})
+		RequireEqualEval(t, "(([a, b]) => a + b)([3, 4])", &object.Integer{7})
+		RequireEqualEval(t, `(() => ({"a": 1}))()["a"]`, &object.Integer{1})
+		RequireEqualEval(t, "3 |> (x => x + 1)", &object.Integer{4})
+	})
+
+	t.Run("try", func(t *testing.T) {
+		RequireEqualEval(t, "let x = 0; try { x = 1 / 0 } catch (e) { x = e.message }; x", &object.String{"division by zero"})
+		RequireEqualEval(t, "let x = 0; try { 1 / 0 } catch (e) { x = type(e) }; x", &object.String{"ERROR"})
+		RequireEqualEval(t, "let x = 0; try { throw 42 } catch (e) { x = e }; x", &object.Integer{42})
+		RequireEqualEval(t, "let x = 0; try { [][1] } catch { x = 1 }; x", &object.Integer{1})
+		RequireEqualEval(t, `let f = fn() { throw "x" }; let r = 0; try { f() } catch (e) { r = e }; r`, &object.String{"x"})
+		RequireEqualEval(t, `let log = []; let f = fn() { try { return 1 } finally { append(log, "f") } }; f(); log`, &object.Array{Elements: []object.Object{&object.String{"f"}}})
+		RequireEqualEval(t, "let f = fn() { try { return 1 } finally { return 2 } }; f()", &object.Integer{2})
+		RequireEqualEval(t, "let n = 0; while true { try { break } finally { n += 1 } }; n", &object.Integer{1})
+		RequireEqualEval(t, `let log = []; try { try { throw 1 } catch (e) { throw e + 1 } finally { append(log, "f") } } catch (e) { append(log, e) }; log`, &object.Array{Elements: []object.Object{&object.String{"f"}, &object.Integer{2}}})
+		RequireEqualEval(t, "let f = fn() { try { 1 } catch (e) { 2 } }; f()", NULL)
+		RequireEvalError(t, `throw "boom"`, `1:1-1:13: uncaught exception: "boom"`)
+		RequireEvalError(t, "try { 1 / 0 } finally { 1 }", "1:7-1:12: division by zero")
+		RequireEvalError(t, "try { 1 / 0 } catch (e) { throw e }", "1:27-1:34: division by zero")
+	})
+
+	t.Run("defer", func(t *testing.T) {
+		RequireEqualEval(t, "let log = []; let f = fn() { defer append(log, 1); defer append(log, 2); append(log, 0) }; f(); log", &object.Array{Elements: []object.Object{&object.Integer{0}, &object.Integer{2}, &object.Integer{1}}})
+		RequireEqualEval(t, "let log = []; let f = fn(x) { defer append(log, x); x = 2; return x }; [f(1), log]", &object.Array{Elements: []object.Object{&object.Integer{2}, &object.Array{Elements: []object.Object{&object.Integer{1}}}}})
+		RequireEqualEval(t, "let log = []; let f = fn() { defer append(log, 1); throw 2 }; try { f() } catch (e) { append(log, e) }; log", &object.Array{Elements: []object.Object{&object.Integer{1}, &object.Integer{2}}})
+		RequireEqualEval(t, "let log = []; let f = fn() { for x in [1, 2] { defer append(log, x) }; defer fn() { append(log, 0) }() }; f(); log", &object.Array{Elements: []object.Object{&object.Integer{0}, &object.Integer{2}, &object.Integer{1}}})
+		RequireEqualEval(t, "let f = fn() { defer fn() { throw 2 }(); 1 }; let r = 0; try { f() } catch (e) { r = e }; r", &object.Integer{2})
+		RequireEvalError(t, "let f = fn() { defer len(1, 2); 1 }; f()", "1:16-1:32: len: wrong number of arguments")
 	})
 
 	t.Run("function statement", func(t *testing.T) {
@@ -147,18 +267,123 @@ func TestEvaluator(t *testing.T) {
 		RequireEqualEval(t, `function foo() { let x = true; while (x) { return "hello"; x = false;  }}; foo()`, &object.String{Value: "hello"})
 	})
 
+	t.Run("for loop", func(t *testing.T) {
+		RequireEqualEval(t, "let s = 0; for x in [1, 2, 3] { s += x }; s", &object.Integer{6})
+		RequireEqualEval(t, "let s = 0; for i, x in [5, 6] { s += i * x }; s", &object.Integer{6})
+		RequireEqualEval(t, `let s = ""; for k in {"a": 1} { s += k }; s`, &object.String{"a"})
+		RequireEqualEval(t, `let s = 0; for k, v in {"a": 1, "b": 2} { s += v }; s`, &object.Integer{3})
+		RequireEqualEval(t, `let s = ""; for k in {"e": 1, "b": 2, "d": 3, "a": 4, "c": 5} { s += k }; s`, &object.String{"ebdac"})
+		RequireEqualEval(t, `let h = {"b": 1, "a": 2}; h["c"] = 3; h["b"] = 4; let s = ""; for k, v in h { s += k + str(v) }; s`, &object.String{"b4a2c3"})
+		RequireEqualEval(t, `let s = ""; for ch in "héllo" { s = ch + s }; s`, &object.String{"olléh"})
+		RequireEqualEval(t, "let s = 0; for i in 4 { s += i }; s", &object.Integer{6})
+		RequireEqualEval(t, "let fs = []; for x in [1, 2] { fs = append(fs, fn() { x }) }; fs[0]() + fs[1]()", &object.Integer{4})
+		RequireEqualEval(t, "fn() { let fs = []; for i, x in [1, 2] { fs = append(fs, fn() { i + x }) }; fs[0]() + fs[1]() }()", &object.Integer{6})
+		RequireEqualEval(t, `function f() { for x in [1, 2] { return x } }; f()`, &object.Integer{1})
+		RequireEvalError(t, "for x in true {}", "1:1-1:17: cannot iterate over BOOLEAN")
+	})
+
+	t.Run("break and continue", func(t *testing.T) {
+		RequireEqualEval(t, "let i = 0; while true { i += 1; if i == 3 { break } }; i", &object.Integer{3})
+		RequireEqualEval(t, "let s = 0; for x in 5 { if x % 2 == 0 { continue } s += x }; s", &object.Integer{4})
+		RequireEqualEval(t, "let s = 0; switch 1 { default: for x in 3 { switch x { case 1: break } s += 1 } } s", &object.Integer{1})
+		input := `
+			let s = 0;
+			outer: for i in 3 {
+				for j in 3 {
+					if j > i { continue outer }
+					if i == 2 { break outer }
+					s += 1
+				}
+			}
+			s
+		`
+		RequireEqualEval(t, input, &object.Integer{3})
+		RequireEqualEval(t, "function f() { while true { for x in [1] { return 7 } } }; f()", &object.Integer{7})
+	})
+
+	t.Run("destructuring", func(t *testing.T) {
+		RequireEqualEval(t, "let [a, [b, c], ...rest] = [1, [2, 3], 4, 5]; a + b + c + len(rest)", &object.Integer{8})
+		RequireEqualEval(t, "let [a, b, ...rest] = [1]; [b, len(rest)]", &object.Array{Elements: []object.Object{NULL, &object.Integer{0}}})
+		RequireEqualEval(t, `let {name, "age": age, inner: {x}} = {"name": "bob", "age": 3, "inner": {"x": 4}}; name + str(age + x)`, &object.String{"bob7"})
+		RequireEqualEval(t, `let {missing} = {}; missing`, NULL)
+		RequireEqualEval(t, `let f = fn([a, b], {c}) { a + b + c }; f([1, 2], {"c": 3})`, &object.Integer{6})
+		RequireEqualEval(t, "let a = 1; let b = 2; [a, b] = [b, a]; a * 10 + b", &object.Integer{21})
+		RequireEqualEval(t, `let h = {}; {"x": h.x, "y": h["y"]} = {"x": 1, "y": 2}; h.x + h.y`, &object.Integer{3})
+		RequireEvalError(t, "let [a] = 1", "1:1-1:12: cannot destructure INTEGER as array")
+		RequireEvalError(t, "let {a} = [1]", "1:1-1:14: cannot destructure ARRAY as hash")
+	})
+
+	t.Run("default and rest parameters", func(t *testing.T) {
+		RequireEqualEval(t, "let f = fn(a, b = 10) { a + b }; f(1) * 100 + f(1, 2)", &object.Integer{1103})
+		RequireEqualEval(t, "let f = fn(x, y = x * 2) { y }; f(3)", &object.Integer{6})
+		RequireEqualEval(t, "let f = fn(a, ...rest) { len(rest) }; f(1) * 10 + f(1, 2, 3)", &object.Integer{2})
+		RequireEqualEval(t, "let f = fn([a, b] = [1, 2]) { a + b }; f()", &object.Integer{3})
+		RequireEvalError(t, "fn(a, b = 1) { a }()", "1:1-1:21: invalid number of function parameters")
+		RequireEvalError(t, "fn(a, b = 1) { a }(1, 2, 3)", "1:1-1:28: invalid number of function parameters")
+	})
+
+	t.Run("spread", func(t *testing.T) {
+		RequireEqualEval(t, "let xs = [2, 3]; [1, ...xs, 4][2]", &object.Integer{3})
+		RequireEqualEval(t, "let f = fn(a, b, c) { a * 100 + b * 10 + c }; let xs = [2, 3]; f(1, ...xs)", &object.Integer{123})
+		RequireEqualEval(t, `len(...["abc"])`, &object.Integer{3})
+		RequireEqualEval(t, "let h = 0; let t = 0; [h, ...t] = [1, 2, 3]; len(t)", &object.Integer{2})
+		RequireEvalError(t, "[...1]", "1:1-1:7: cannot spread INTEGER")
+	})
+
+	t.Run("optional chaining", func(t *testing.T) {
+		RequireEqualEval(t, `let x = {"a": {"b": 1}}; x?.a?.b`, &object.Integer{1})
+		RequireEqualEval(t, `let x = {"a": {"b": 1}}; x?.a?.c?.d`, NULL)
+		RequireEqualEval(t, `let x = null; x?.a`, NULL)
+		RequireEqualEval(t, `let x = null; x?.[fail()]`, NULL)
+		RequireEqualEval(t, `let f = null; f?.(fail())`, NULL)
+		RequireEqualEval(t, `let x = {"k": 2}; x?.["k"]`, &object.Integer{2})
+		RequireEqualEval(t, `len?.("abc")`, &object.Integer{3})
+		RequireEvalError(t, `[1]?.a`, "1:1-1:7: cannot access 'a' of ARRAY")
+		RequireEqualEval(t, `let a = null; a?.b.c`, NULL)
+		RequireEqualEval(t, `let a = null; a?.b()`, NULL)
+		RequireEqualEval(t, `let a = null; a?.b[0].c(fail())[1:]`, NULL)
+		RequireEqualEval(t, `let a = {"b": {"c": 1}}; a?.b.c`, &object.Integer{1})
+		RequireEqualEval(t, `let a = {"b": fn(x) { x }}; a?.b(null?.c.d)`, NULL)
+		RequireEqualEval(t, `let f = fn(a) { defer a?.b(); 1 }; f(null)`, &object.Integer{1})
+		RequireEvalError(t, `let a = {}; a?.b.c`, "1:13-1:19: cannot access 'c' of NULL")
+	})
+
+	t.Run("null coalescing", func(t *testing.T) {
+		RequireEqualEval(t, "null ?? 1", &object.Integer{1})
+		RequireEqualEval(t, "false ?? 1", FALSE)
+		RequireEqualEval(t, "2 ?? fail()", &object.Integer{2})
+		RequireEqualEval(t, `let x = {}; x?.port ?? 80`, &object.Integer{80})
+	})
+
+	t.Run("conditional", func(t *testing.T) {
+		RequireEqualEval(t, "true ? 1 : 2", &object.Integer{1})
+		RequireEqualEval(t, "null ? 1 : 2", &object.Integer{2})
+		RequireEqualEval(t, "false ? fail() : 0 ? 3 : 4", &object.Integer{3})
+	})
+
+	t.Run("pipeline", func(t *testing.T) {
+		RequireEqualEval(t, `"abc" |> len`, &object.Integer{3})
+		RequireEqualEval(t, "let sub = fn(a, b) { a - b }; 10 |> sub(3) |> sub(2)", &object.Integer{5})
+		RequireEqualEval(t, "let f = fn(a, ...r) { r }; 1 |> f(...[2, 3])", &object.Array{Elements: []object.Object{&object.Integer{2}, &object.Integer{3}}})
+		RequireEvalError(t, "1 |> 2", "1:1-1:7: not a function: INTEGER")
+	})
+
 	t.Run("property access", func(t *testing.T) {
 		RequireEqualEval(t, `let x = { "foo": 123 }; x.foo`, &object.Integer{123})
 		RequireEqualEval(t, `let x = { "foo": { "bar": true } }; x.foo.bar`, TRUE)
 		RequireEqualEval(t, `let x = {}; x.foo = 123; x.foo`, &object.Integer{123})
+		RequireEqualEval(t, `let x = {}; x?.foo`, NULL)
+		RequireEvalError(t, `let x = {}; x.foo`, "1:13-1:18: property not found: foo")
+		RequireEvalError(t, `let x = {}; x.foo += 1`, "1:13-1:23: property not found: foo")
+		RequireEvalError(t, `[1].a`, "1:1-1:6: cannot access 'a' of ARRAY")
 	})
 
 	t.Run("type checking", func(t *testing.T) {
 		RequireEqualEval(t, "fn(x: integer){x}(123)", &object.Integer{123})
-		RequireEvalError(t, "fn(x: integer){x}(false)", "1:18: wrong type: expected INTEGER, got BOOLEAN")
-		RequireEvalError(t, "let x: boolean = 123", "1:1: wrong type: expected BOOLEAN, got INTEGER")
+		RequireEvalError(t, "fn(x: integer){x}(false)", "1:1-1:25: wrong type: expected INTEGER, got BOOLEAN")
+		RequireEvalError(t, "let x: boolean = 123", "1:1-1:21: wrong type: expected BOOLEAN, got INTEGER")
 		RequireEqualEval(t, "let x: integer = 123; x", &object.TypedObject{ObjectType: object.INTEGER, Object: &object.Integer{123}})
-		RequireEvalError(t, "let x: boolean = false; x = 123", "1:27: wrong type: expected BOOLEAN, got INTEGER")
+		RequireEvalError(t, "let x: boolean = false; x = 123", "1:25-1:32: wrong type: expected BOOLEAN, got INTEGER")
 	})
 
 	t.Run("in expression", func(t *testing.T) {
@@ -3,19 +3,44 @@ package evaluator
 import (
 	"fmt"
 	"io"
-	"io/ioutil"
 	"log"
+	"strings"
 
 	"github.com/icholy/monkey/compiler"
 	"github.com/icholy/monkey/vm"
 
 	"github.com/chzyer/readline"
 
+	"github.com/icholy/monkey/lexer"
 	"github.com/icholy/monkey/object"
 	"github.com/icholy/monkey/parser"
 )
 
-var Prompt = ">> "
+var (
+	Prompt         = ">> "
+	ContinuePrompt = "... "
+)
+
+// readInput reads lines until they form a complete program. An empty
+// continuation line stops early so the parse error gets reported.
+func readInput(rl *readline.Instance) (string, error) {
+	defer rl.SetPrompt(Prompt)
+	var input strings.Builder
+	for {
+		line, err := rl.Readline()
+		if err != nil {
+			return "", err
+		}
+		input.WriteString(line)
+		input.WriteByte('\n')
+		p := parser.New(lexer.New(input.String()))
+		p.ParseProgram()
+		if !p.Incomplete() || line == "" {
+			return input.String(), nil
+		}
+		rl.SetPrompt(ContinuePrompt)
+	}
+}
 
 func REPL(in io.Reader, out io.Writer) {
 	rl, err := readline.New(Prompt)
@@ -25,11 +50,11 @@ func REPL(in io.Reader, out io.Writer) {
 	defer rl.Close()
 	env := object.NewEnv(nil)
 	for {
-		line, err := rl.Readline()
+		input, err := readInput(rl)
 		if err != nil {
 			log.Fatal(err)
 		}
-		program, err := parser.Parse(line)
+		program, err := parser.Parse(input)
 		if err != nil {
 			fmt.Println(err)
 		} else {
@@ -59,11 +84,11 @@ func REPL2(in io.Reader, out io.Writer) {
 	}
 
 	for {
-		line, err := rl.Readline()
+		input, err := readInput(rl)
 		if err != nil {
 			log.Fatal(err)
 		}
-		program, err := parser.Parse(line)
+		program, err := parser.Parse(input)
 		if err != nil {
 			fmt.Println(err)
 			continue
@@ -87,11 +112,7 @@ func REPL2(in io.Reader, out io.Writer) {
 }
 
 func Run(in io.Reader) error {
-	data, err := ioutil.ReadAll(in)
-	if err != nil {
-		return err
-	}
-	program, err := parser.Parse(string(data))
+	program, err := parser.ParseReader(in)
 	if err != nil {
 		return err
 	}
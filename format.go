@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+
+	"github.com/icholy/monkey/printer"
+)
+
+// format prints files in canonical form. With no files it formats
+// stdin.
+func format(args []string) error {
+	fs := flag.NewFlagSet("fmt", flag.ExitOnError)
+	write := fs.Bool("w", false, "write the result to the file instead of stdout")
+	diff := fs.Bool("d", false, "print diffs instead of the formatted source")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: monkey fmt [-w] [-d] [file ...]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		if *write {
+			return errors.New("cannot use -w with stdin")
+		}
+		src, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		return formatSource("<stdin>", src, false, *diff)
+	}
+	for _, name := range fs.Args() {
+		src, err := os.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		if err := formatSource(name, src, *write, *diff); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func formatSource(name string, src []byte, write, diff bool) error {
+	res, err := printer.Source(src)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	if bytes.Equal(src, res) {
+		if !write && !diff {
+			os.Stdout.Write(res)
+		}
+		return nil
+	}
+	if diff {
+		d, err := diffSource(name, src, res)
+		if err != nil {
+			return err
+		}
+		os.Stdout.Write(d)
+	}
+	if write {
+		info, err := os.Stat(name)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(name, res, info.Mode().Perm())
+	}
+	if !diff {
+		os.Stdout.Write(res)
+	}
+	return nil
+}
+
+// diffSource returns the output of diff -u between the original and
+// formatted source.
+func diffSource(name string, src, res []byte) ([]byte, error) {
+	dir, err := os.MkdirTemp("", "monkeyfmt")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	a, b := dir+"/a", dir+"/b"
+	if err := os.WriteFile(a, src, 0644); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(b, res, 0644); err != nil {
+		return nil, err
+	}
+	out, err := exec.Command("diff", "-u", "--label", name+".orig", "--label", name, a, b).Output()
+	// diff exits with 1 when the files differ
+	var exit *exec.ExitError
+	if errors.As(err, &exit) && exit.ExitCode() == 1 {
+		err = nil
+	}
+	return out, err
+}
@@ -1,62 +1,162 @@
 package lexer
 
 import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/icholy/monkey/token"
 )
 
+var (
+	ErrUnterminatedComment   = errors.New("unterminated block comment")
+	ErrUnterminatedString    = errors.New("unterminated string literal")
+	ErrUnterminatedRawString = errors.New("unterminated raw string literal")
+	ErrInvalidEscape         = errors.New("invalid escape sequence")
+	ErrIllegalCharacter      = errors.New("illegal character")
+	ErrInvalidFloat          = errors.New("invalid float literal")
+)
+
+// Error is a problem found while tokenizing. Err is the cause and can be
+// compared against the Err* values using errors.Is.
+type Error struct {
+	Pos token.Pos
+	Err error
+}
+
+func (e Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Pos, e.Err)
+}
+
+func (e Error) Unwrap() error {
+	return e.Err
+}
+
 func New(input string) *Lexer {
-	return &Lexer{
-		input:  input,
-		ch:     input[0],
+	return NewReader(strings.NewReader(input))
+}
+
+// NewReader returns a lexer which reads its input from r as needed
+// instead of requiring the whole program up front.
+func NewReader(r io.Reader) *Lexer {
+	rr, ok := r.(io.RuneReader)
+	if !ok {
+		rr = bufio.NewReader(r)
+	}
+	l := &Lexer{
+		r:      rr,
 		offset: 1,
 		line:   1,
 	}
+	l.read()
+	l.shebang()
+	return l
 }
 
 type Lexer struct {
-	input  string
-	pos    int
-	ch     byte
+	r      io.RuneReader
+	err    error
+	width  int
+	ch     rune
 	line   int
 	offset int
+	// byte offset of ch from the start of the input
+	byte int
+
+	// one rune of lookahead
+	next      rune
+	nextWidth int
+	peeked    bool
+
+	// text of the token being read
+	text strings.Builder
+
+	comments bool
+	errors   []Error
+
+	// brace depth of each template literal interpolation we're inside of
+	templates []int
+}
+
+// KeepComments makes NextToken return comments as COMMENT tokens
+// instead of skipping them.
+func (l *Lexer) KeepComments(keep bool) {
+	l.comments = keep
 }
 
-func (l *Lexer) peek() byte {
-	next := l.pos + 1
-	if next >= len(l.input) {
-		return 0
+func (l *Lexer) Errors() []Error {
+	return l.errors
+}
+
+func (l *Lexer) error(pos token.Pos, err error) {
+	l.errors = append(l.errors, Error{Pos: pos, Err: err})
+}
+
+func (l *Lexer) peek() rune {
+	if !l.peeked {
+		l.next, l.nextWidth = l.readRune()
+		l.peeked = true
+	}
+	return l.next
+}
+
+func (l *Lexer) readRune() (rune, int) {
+	if l.err != nil {
+		return 0, 0
+	}
+	ch, width, err := l.r.ReadRune()
+	if err != nil {
+		l.err = err
+		if err != io.EOF {
+			l.error(l.Pos(), err)
+		}
+		return 0, 0
 	}
-	return l.input[next]
+	return ch, width
 }
 
 func (l *Lexer) Pos() token.Pos {
 	return token.Pos{
 		Line:   l.line,
 		Offset: l.offset,
+		Byte:   l.byte,
 	}
 }
 
 func (l *Lexer) read() {
-	l.pos++
-	if l.pos >= len(l.input) {
-		l.ch = 0
-	} else {
-		l.offset++
-		if isNewline(l.ch) {
+	if l.width > 0 {
+		l.byte += l.width
+		if l.ch == '\n' {
 			l.offset = 1
 			l.line++
+		} else {
+			l.offset++
 		}
-		l.ch = l.input[l.pos]
 	}
+	if l.peeked {
+		l.ch, l.width = l.next, l.nextWidth
+		l.peeked = false
+		return
+	}
+	l.ch, l.width = l.readRune()
+}
+
+// save adds the current character to the token text and advances.
+func (l *Lexer) save() {
+	l.text.WriteRune(l.ch)
+	l.read()
 }
 
 func (l *Lexer) charToken(typ token.TokenType) token.Token {
 	return token.Token{Type: typ, Text: string(l.ch), Pos: l.Pos()}
 }
 
-var bytetokens = map[byte]token.TokenType{
+var runetokens = map[rune]token.TokenType{
 	';': token.SEMICOLON,
 	':': token.COLON,
 	'(': token.LPAREN,
@@ -65,25 +165,65 @@ var bytetokens = map[byte]token.TokenType{
 	'}': token.RBRACE,
 	'[': token.LBRACKET,
 	']': token.RBRACKET,
-	'+': token.PLUS,
-	'-': token.MINUS,
-	'*': token.ASTERISK,
-	'/': token.SLASH,
+	'^': token.CARET,
+	'~': token.TILDE,
 	',': token.COMMA,
-	'.': token.DOT,
 	0:   token.EOF,
 }
 
 func (l *Lexer) NextToken() token.Token {
-	l.whitespace()
+	tok := l.token()
+	tok.End = l.Pos()
+	return tok
+}
+
+func (l *Lexer) token() token.Token {
 	var tok token.Token
-	tok.Pos = l.Pos()
+	for {
+		l.whitespace()
+		tok.Pos = l.Pos()
+		if !l.isComment() {
+			break
+		}
+		text, ok := l.comment()
+		if !ok {
+			l.error(tok.Pos, ErrUnterminatedComment)
+			tok.Type = token.EOF
+			return tok
+		}
+		if l.comments {
+			tok.Type = token.COMMENT
+			tok.Text = text
+			return tok
+		}
+	}
+
+	if n := len(l.templates); n > 0 {
+		switch l.ch {
+		case '{':
+			l.templates[n-1]++
+		case '}':
+			if l.templates[n-1] == 0 {
+				l.read()
+				text, interp := l.str(tok.Pos)
+				tok.Text = text
+				if interp {
+					tok.Type = token.TEMPLATE_MIDDLE
+				} else {
+					tok.Type = token.TEMPLATE_TAIL
+					l.templates = l.templates[:n-1]
+				}
+				return tok
+			}
+			l.templates[n-1]--
+		}
+	}
 
-	if typ, ok := bytetokens[l.ch]; ok {
+	if typ, ok := runetokens[l.ch]; ok {
 		if typ == token.EOF {
 			tok.Type = token.EOF
 		} else {
-			tok = token.NewByte(typ, l.ch)
+			tok = token.New(typ, string(l.ch))
 		}
 		tok.Pos = l.Pos()
 		l.read()
@@ -92,27 +232,58 @@ func (l *Lexer) NextToken() token.Token {
 
 	switch l.ch {
 	case '<':
-		if l.peek() == '=' {
+		switch l.peek() {
+		case '=':
 			l.read()
 			tok.Type = token.LT_EQ
 			tok.Text = "<="
-		} else {
+		case '<':
+			l.read()
+			tok.Type = token.SHL
+			tok.Text = "<<"
+		default:
 			tok = l.charToken(token.LT)
 		}
 	case '>':
-		if l.peek() == '=' {
+		switch l.peek() {
+		case '=':
 			l.read()
 			tok.Type = token.GT_EQ
 			tok.Text = ">="
-		} else {
+		case '>':
+			l.read()
+			tok.Type = token.SHR
+			tok.Text = ">>"
+		default:
 			tok = l.charToken(token.GT)
 		}
+	case '+':
+		tok = l.assignToken(token.PLUS, token.PLUS_ASSIGN)
+	case '-':
+		tok = l.assignToken(token.MINUS, token.MINUS_ASSIGN)
+	case '/':
+		tok = l.assignToken(token.SLASH, token.SLASH_ASSIGN)
+	case '%':
+		tok = l.assignToken(token.PERCENT, token.PERCENT_ASSIGN)
+	case '*':
+		if l.peek() == '*' {
+			l.read()
+			tok.Type = token.POWER
+			tok.Text = "**"
+		} else {
+			tok = l.assignToken(token.ASTERISK, token.ASTERISK_ASSIGN)
+		}
 	case '=':
-		if l.peek() == '=' {
+		switch l.peek() {
+		case '=':
 			l.read()
 			tok.Type = token.EQ
 			tok.Text = "=="
-		} else {
+		case '>':
+			l.read()
+			tok.Type = token.ARROW
+			tok.Text = "=>"
+		default:
 			tok = l.charToken(token.ASSIGN)
 		}
 	case '!':
@@ -124,16 +295,58 @@ func (l *Lexer) NextToken() token.Token {
 			tok = l.charToken(token.BANG)
 		}
 	case '"':
-		tok.Text = l.str()
+		l.read()
+		text, interp := l.str(tok.Pos)
+		tok.Text = text
+		if interp {
+			tok.Type = token.TEMPLATE_HEAD
+			l.templates = append(l.templates, 0)
+		} else {
+			tok.Type = token.STRING
+		}
+		return tok
+	case '`':
+		tok.Text = l.rawstr(tok.Pos)
 		tok.Type = token.STRING
 		return tok
+	case '.':
+		if l.peek() != '.' {
+			tok = l.charToken(token.DOT)
+			break
+		}
+		l.read()
+		if l.peek() != '.' {
+			// two dots are two DOT tokens
+			return token.Token{Type: token.DOT, Text: ".", Pos: tok.Pos}
+		}
+		l.read()
+		tok.Type = token.ELLIPSIS
+		tok.Text = "..."
 	case '|':
-		if l.peek() == '|' {
+		switch l.peek() {
+		case '|':
 			l.read()
 			tok.Type = token.OR
 			tok.Text = "||"
-		} else {
-			tok = l.charToken(token.ILLEGAL)
+		case '>':
+			l.read()
+			tok.Type = token.PIPELINE
+			tok.Text = "|>"
+		default:
+			tok = l.charToken(token.PIPE)
+		}
+	case '?':
+		switch l.peek() {
+		case '.':
+			l.read()
+			tok.Type = token.QUESTION_DOT
+			tok.Text = "?."
+		case '?':
+			l.read()
+			tok.Type = token.NULLISH
+			tok.Text = "??"
+		default:
+			tok = l.charToken(token.QUESTION)
 		}
 	case '&':
 		if l.peek() == '&' {
@@ -141,7 +354,7 @@ func (l *Lexer) NextToken() token.Token {
 			tok.Type = token.AND
 			tok.Text = "&&"
 		} else {
-			tok = l.charToken(token.ILLEGAL)
+			tok = l.charToken(token.AMPERSAND)
 		}
 	default:
 		if isLetter(l.ch) {
@@ -150,12 +363,25 @@ func (l *Lexer) NextToken() token.Token {
 			return tok
 		}
 		if isDigit(l.ch) {
-			tok.Text = l.number()
-			tok.Type = token.INT
+			tok.Text, tok.Type = l.number(tok.Pos)
 			return tok
 		}
 		tok = l.charToken(token.ILLEGAL)
 	}
+	if tok.Type == token.ILLEGAL {
+		l.error(tok.Pos, fmt.Errorf("%w %q", ErrIllegalCharacter, l.ch))
+	}
+	l.read()
+	return tok
+}
+
+// assignToken returns a compound assignment token if the current
+// character is followed by '='.
+func (l *Lexer) assignToken(typ, assign token.TokenType) token.Token {
+	if l.peek() != '=' {
+		return l.charToken(typ)
+	}
+	tok := token.Token{Type: assign, Text: string(l.ch) + "=", Pos: l.Pos()}
 	l.read()
 	return tok
 }
@@ -166,67 +392,199 @@ func (l *Lexer) whitespace() {
 	}
 }
 
-func (l *Lexer) str() string {
-	l.read()
-	var escaped bool
-	var b strings.Builder
+func (l *Lexer) shebang() {
+	if l.ch == '#' && l.peek() == '!' {
+		for l.ch != 0 && !isNewline(l.ch) {
+			l.read()
+		}
+	}
+}
+
+func (l *Lexer) isComment() bool {
+	return l.ch == '/' && (l.peek() == '/' || l.peek() == '*')
+}
+
+func (l *Lexer) comment() (string, bool) {
+	l.text.Reset()
+	l.save()
+	if l.ch == '/' {
+		for l.ch != 0 && !isNewline(l.ch) {
+			l.save()
+		}
+		return l.text.String(), true
+	}
+	l.save()
 	for l.ch != 0 {
-		if escaped {
-			switch l.ch {
-			case 't':
-				b.WriteByte('\t')
-			case 'r':
-				b.WriteByte('\r')
-			case 'n':
-				b.WriteByte('\n')
-			default:
-				b.WriteByte(l.ch)
-			}
-			escaped = false
+		if l.ch == '*' && l.peek() == '/' {
+			l.save()
+			l.save()
+			return l.text.String(), true
+		}
+		l.save()
+	}
+	return l.text.String(), false
+}
+
+// str reads the string contents up to and including the closing quote.
+// If it stops at the start of an interpolation instead, interp is true.
+func (l *Lexer) str(pos token.Pos) (text string, interp bool) {
+	var b strings.Builder
+	for l.ch != 0 && l.ch != '"' {
+		if l.ch == '$' && l.peek() == '{' {
+			l.read()
+			interp = true
+			break
+		}
+		if l.ch == '\\' {
+			l.read()
+			l.escape(&b)
 		} else {
-			if l.ch == '"' {
-				break
-			}
-			if l.ch == '\\' {
-				escaped = true
-			} else {
-				b.WriteByte(l.ch)
-			}
+			b.WriteRune(l.ch)
 		}
 		l.read()
 	}
+	if l.ch == 0 {
+		l.error(pos, ErrUnterminatedString)
+	}
 	l.read()
-	return b.String()
+	return b.String(), interp
 }
 
-func (l *Lexer) ident() string {
-	start := l.pos
-	for isLetter(l.ch) {
+func (l *Lexer) rawstr(pos token.Pos) string {
+	l.read()
+	var b strings.Builder
+	for l.ch != 0 && l.ch != '`' {
+		if l.ch != '\r' {
+			b.WriteRune(l.ch)
+		}
 		l.read()
 	}
-	return l.input[start:l.pos]
+	if l.ch == 0 {
+		l.error(pos, ErrUnterminatedRawString)
+	}
+	l.read()
+	return b.String()
 }
 
-func (l *Lexer) number() string {
-	start := l.pos
-	for isDigit(l.ch) {
+func (l *Lexer) escape(b *strings.Builder) {
+	pos := l.Pos()
+	switch l.ch {
+	case 't':
+		b.WriteByte('\t')
+	case 'r':
+		b.WriteByte('\r')
+	case 'n':
+		b.WriteByte('\n')
+	case 'x':
+		var digits strings.Builder
+		for i := 0; i < 2 && isHexDigit(l.peek()); i++ {
+			l.read()
+			digits.WriteRune(l.ch)
+		}
+		if digits.Len() != 2 {
+			l.error(pos, fmt.Errorf("%w: \\x%s", ErrInvalidEscape, digits.String()))
+			return
+		}
+		v, _ := strconv.ParseUint(digits.String(), 16, 8)
+		b.WriteRune(rune(v))
+	case 'u':
+		if l.peek() != '{' {
+			l.error(pos, fmt.Errorf("%w: \\u", ErrInvalidEscape))
+			return
+		}
+		l.read()
+		var digits strings.Builder
+		for isHexDigit(l.peek()) {
+			l.read()
+			digits.WriteRune(l.ch)
+		}
+		if l.peek() != '}' || digits.Len() == 0 {
+			l.error(pos, fmt.Errorf("%w: \\u{%s", ErrInvalidEscape, digits.String()))
+			return
+		}
 		l.read()
+		v, err := strconv.ParseUint(digits.String(), 16, 32)
+		if err != nil || !utf8.ValidRune(rune(v)) {
+			l.error(pos, fmt.Errorf("%w: \\u{%s}", ErrInvalidEscape, digits.String()))
+			return
+		}
+		b.WriteRune(rune(v))
+	default:
+		b.WriteRune(l.ch)
 	}
-	return l.input[start:l.pos]
 }
 
-func isLetter(ch byte) bool {
-	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_'
+func (l *Lexer) ident() string {
+	l.text.Reset()
+	for isLetter(l.ch) || unicode.IsDigit(l.ch) {
+		l.save()
+	}
+	return l.text.String()
 }
 
-func isWhitespace(ch byte) bool {
+func (l *Lexer) number(pos token.Pos) (string, token.TokenType) {
+	l.text.Reset()
+	if l.ch == '0' && isBasePrefix(l.peek()) {
+		l.save()
+		l.save()
+		for isHexDigit(l.ch) || l.ch == '_' {
+			l.save()
+		}
+		return l.text.String(), token.INT
+	}
+	var typ token.TokenType = token.INT
+	l.digits()
+	if l.ch == '.' && isDigit(l.peek()) {
+		typ = token.FLOAT
+		l.save()
+		l.digits()
+	}
+	if l.ch == 'e' || l.ch == 'E' {
+		typ = token.FLOAT
+		l.save()
+		if l.ch == '+' || l.ch == '-' {
+			l.save()
+		}
+		if !isDigit(l.ch) {
+			l.error(pos, fmt.Errorf("%w %s: exponent has no digits", ErrInvalidFloat, l.text.String()))
+			return l.text.String(), token.ILLEGAL
+		}
+		l.digits()
+	}
+	return l.text.String(), typ
+}
+
+func (l *Lexer) digits() {
+	for isDigit(l.ch) || l.ch == '_' {
+		l.save()
+	}
+}
+
+func isLetter(ch rune) bool {
+	return unicode.IsLetter(ch) || ch == '_'
+}
+
+func isWhitespace(ch rune) bool {
 	return ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r'
 }
 
-func isNewline(ch byte) bool {
+func isNewline(ch rune) bool {
 	return ch == '\n' || ch == '\r'
 }
 
-func isDigit(ch byte) bool {
+func isDigit(ch rune) bool {
 	return '0' <= ch && ch <= '9'
 }
+
+func isHexDigit(ch rune) bool {
+	return isDigit(ch) || 'a' <= ch && ch <= 'f' || 'A' <= ch && ch <= 'F'
+}
+
+func isBasePrefix(ch rune) bool {
+	switch ch {
+	case 'x', 'X', 'o', 'O', 'b', 'B':
+		return true
+	default:
+		return false
+	}
+}
@@ -1,7 +1,11 @@
 package lexer
 
 import (
+	"errors"
+	"reflect"
+	"strings"
 	"testing"
+	"testing/iotest"
 
 	"github.com/icholy/monkey/token"
 )
@@ -11,6 +15,7 @@ func ExpectTokens(t *testing.T, input string, expected []token.Token) {
 	for i, e := range expected {
 		tok := l.NextToken()
 		tok.Pos = token.Pos{}
+		tok.End = token.Pos{}
 		if tok != e {
 			t.Fatalf("test[%d] - wrong token. want=%s, got=%s", i, e, tok)
 		}
@@ -84,7 +89,7 @@ func TestNextToken(t *testing.T) {
 	})
 
 	t.Run("one character operators", func(t *testing.T) {
-		input := `<!-/*5>`
+		input := `<!-/ *5>`
 		ExpectTokens(t, input, []token.Token{
 			token.New(token.LT, "<"),
 			token.New(token.BANG, "!"),
@@ -97,6 +102,34 @@ func TestNextToken(t *testing.T) {
 		})
 	})
 
+	t.Run("arithmetic and bitwise operators", func(t *testing.T) {
+		input := `% ** & | ^ ~ << >> += -= *= /= %= a&&b||c <<= >=`
+		ExpectTokens(t, input, []token.Token{
+			token.New(token.PERCENT, "%"),
+			token.New(token.POWER, "**"),
+			token.New(token.AMPERSAND, "&"),
+			token.New(token.PIPE, "|"),
+			token.New(token.CARET, "^"),
+			token.New(token.TILDE, "~"),
+			token.New(token.SHL, "<<"),
+			token.New(token.SHR, ">>"),
+			token.New(token.PLUS_ASSIGN, "+="),
+			token.New(token.MINUS_ASSIGN, "-="),
+			token.New(token.ASTERISK_ASSIGN, "*="),
+			token.New(token.SLASH_ASSIGN, "/="),
+			token.New(token.PERCENT_ASSIGN, "%="),
+			token.New(token.IDENT, "a"),
+			token.New(token.AND, "&&"),
+			token.New(token.IDENT, "b"),
+			token.New(token.OR, "||"),
+			token.New(token.IDENT, "c"),
+			token.New(token.SHL, "<<"),
+			token.New(token.ASSIGN, "="),
+			token.New(token.GT_EQ, ">="),
+			token.New(token.EOF, ""),
+		})
+	})
+
 	t.Run("more keywords", func(t *testing.T) {
 		input := `
 			if (true) {
@@ -195,6 +228,87 @@ func TestNextToken(t *testing.T) {
 		})
 	})
 
+	t.Run("ellipsis", func(t *testing.T) {
+		ExpectTokens(t, `[...a] a..b`, []token.Token{
+			token.New(token.LBRACKET, "["),
+			token.New(token.ELLIPSIS, "..."),
+			token.New(token.IDENT, "a"),
+			token.New(token.RBRACKET, "]"),
+			token.New(token.IDENT, "a"),
+			token.New(token.DOT, "."),
+			token.New(token.DOT, "."),
+			token.New(token.IDENT, "b"),
+			token.New(token.EOF, ""),
+		})
+	})
+
+	t.Run("null operators", func(t *testing.T) {
+		ExpectTokens(t, `a?.b ?? c`, []token.Token{
+			token.New(token.IDENT, "a"),
+			token.New(token.QUESTION_DOT, "?."),
+			token.New(token.IDENT, "b"),
+			token.New(token.NULLISH, "??"),
+			token.New(token.IDENT, "c"),
+			token.New(token.EOF, ""),
+		})
+	})
+
+	t.Run("conditional and pipeline", func(t *testing.T) {
+		ExpectTokens(t, `a ? b : c |> d | e`, []token.Token{
+			token.New(token.IDENT, "a"),
+			token.New(token.QUESTION, "?"),
+			token.New(token.IDENT, "b"),
+			token.New(token.COLON, ":"),
+			token.New(token.IDENT, "c"),
+			token.New(token.PIPELINE, "|>"),
+			token.New(token.IDENT, "d"),
+			token.New(token.PIPE, "|"),
+			token.New(token.IDENT, "e"),
+			token.New(token.EOF, ""),
+		})
+	})
+
+	t.Run("exceptions", func(t *testing.T) {
+		ExpectTokens(t, `try {} catch (e) { throw e } finally {}`, []token.Token{
+			token.New(token.TRY, "try"),
+			token.New(token.LBRACE, "{"),
+			token.New(token.RBRACE, "}"),
+			token.New(token.CATCH, "catch"),
+			token.New(token.LPAREN, "("),
+			token.New(token.IDENT, "e"),
+			token.New(token.RPAREN, ")"),
+			token.New(token.LBRACE, "{"),
+			token.New(token.THROW, "throw"),
+			token.New(token.IDENT, "e"),
+			token.New(token.RBRACE, "}"),
+			token.New(token.FINALLY, "finally"),
+			token.New(token.LBRACE, "{"),
+			token.New(token.RBRACE, "}"),
+			token.New(token.EOF, ""),
+		})
+	})
+
+	t.Run("defer", func(t *testing.T) {
+		ExpectTokens(t, `defer f()`, []token.Token{
+			token.New(token.DEFER, "defer"),
+			token.New(token.IDENT, "f"),
+			token.New(token.LPAREN, "("),
+			token.New(token.RPAREN, ")"),
+			token.New(token.EOF, ""),
+		})
+	})
+
+	t.Run("arrow", func(t *testing.T) {
+		ExpectTokens(t, `x => x == 1`, []token.Token{
+			token.New(token.IDENT, "x"),
+			token.New(token.ARROW, "=>"),
+			token.New(token.IDENT, "x"),
+			token.New(token.EQ, "=="),
+			token.New(token.INT, "1"),
+			token.New(token.EOF, ""),
+		})
+	})
+
 	t.Run("dot access", func(t *testing.T) {
 		ExpectTokens(t, `foo.bar()`, []token.Token{
 			token.New(token.IDENT, "foo"),
@@ -249,4 +363,290 @@ func TestNextToken(t *testing.T) {
 		})
 	})
 
+	t.Run("comments", func(t *testing.T) {
+		input := `
+			// line comment
+			let x = 1; // trailing
+			/* block
+			   comment */ x / 2
+		`
+		ExpectTokens(t, input, []token.Token{
+			token.New(token.LET, "let"),
+			token.New(token.IDENT, "x"),
+			token.New(token.ASSIGN, "="),
+			token.New(token.INT, "1"),
+			token.New(token.SEMICOLON, ";"),
+			token.New(token.IDENT, "x"),
+			token.New(token.SLASH, "/"),
+			token.New(token.INT, "2"),
+			token.New(token.EOF, ""),
+		})
+	})
+
+	t.Run("keep comments", func(t *testing.T) {
+		l := New("x // foo\n/* bar */")
+		l.KeepComments(true)
+		expected := []token.Token{
+			token.New(token.IDENT, "x"),
+			token.New(token.COMMENT, "// foo"),
+			token.New(token.COMMENT, "/* bar */"),
+			token.New(token.EOF, ""),
+		}
+		for i, e := range expected {
+			tok := l.NextToken()
+			tok.Pos = token.Pos{}
+			tok.End = token.Pos{}
+			if tok != e {
+				t.Fatalf("test[%d] - wrong token. want=%s, got=%s", i, e, tok)
+			}
+		}
+	})
+
+	t.Run("shebang", func(t *testing.T) {
+		ExpectTokens(t, "#!/usr/bin/env monkey\nfoo", []token.Token{
+			token.New(token.IDENT, "foo"),
+			token.New(token.EOF, ""),
+		})
+	})
+
+	t.Run("unterminated block comment", func(t *testing.T) {
+		l := New("x /* foo")
+		l.NextToken()
+		if tok := l.NextToken(); tok.Type != token.EOF {
+			t.Fatalf("expected EOF, got %s", tok)
+		}
+		errs := l.Errors()
+		if len(errs) != 1 || errs[0].Error() != "1:3: unterminated block comment" {
+			t.Fatalf("unexpected errors: %v", errs)
+		}
+	})
+
+	t.Run("floats", func(t *testing.T) {
+		ExpectTokens(t, "1.5 2 0.25e3 1E-2 3.x", []token.Token{
+			token.New(token.FLOAT, "1.5"),
+			token.New(token.INT, "2"),
+			token.New(token.FLOAT, "0.25e3"),
+			token.New(token.FLOAT, "1E-2"),
+			token.New(token.INT, "3"),
+			token.New(token.DOT, "."),
+			token.New(token.IDENT, "x"),
+			token.New(token.EOF, ""),
+		})
+		l := New("1e+ 2e x")
+		for _, text := range []string{"1e+", "2e"} {
+			if tok := l.NextToken(); tok.Type != token.ILLEGAL || tok.Text != text {
+				t.Fatalf("expected ILLEGAL %q, got %s", text, tok)
+			}
+		}
+		errs := l.Errors()
+		if len(errs) != 2 || errs[0].Error() != "1:1: invalid float literal 1e+: exponent has no digits" || errs[1].Error() != "1:5: invalid float literal 2e: exponent has no digits" {
+			t.Fatalf("unexpected errors: %v", errs)
+		}
+		if !errors.Is(errs[0], ErrInvalidFloat) {
+			t.Fatalf("expected invalid float, got %v", errs[0])
+		}
+	})
+
+	t.Run("integer bases", func(t *testing.T) {
+		ExpectTokens(t, "0xFF 0o755 0b1010 1_000_000 0x_ff 0_1 0_0", []token.Token{
+			token.New(token.INT, "0xFF"),
+			token.New(token.INT, "0o755"),
+			token.New(token.INT, "0b1010"),
+			token.New(token.INT, "1_000_000"),
+			token.New(token.INT, "0x_ff"),
+			token.New(token.INT, "0_1"),
+			token.New(token.INT, "0_0"),
+			token.New(token.EOF, ""),
+		})
+	})
+
+	t.Run("unicode", func(t *testing.T) {
+		ExpectTokens(t, `let café = "naïve"; größe2`, []token.Token{
+			token.New(token.LET, "let"),
+			token.New(token.IDENT, "café"),
+			token.New(token.ASSIGN, "="),
+			token.New(token.STRING, "naïve"),
+			token.New(token.SEMICOLON, ";"),
+			token.New(token.IDENT, "größe2"),
+			token.New(token.EOF, ""),
+		})
+	})
+
+	t.Run("escapes", func(t *testing.T) {
+		ExpectTokens(t, `"\x41\u{e9}\u{1F600}"`, []token.Token{
+			token.New(token.STRING, "Aé😀"),
+			token.New(token.EOF, ""),
+		})
+		l := New(`"\u{110000}" "\xZ"`)
+		l.NextToken()
+		l.NextToken()
+		errs := l.Errors()
+		if len(errs) != 2 {
+			t.Fatalf("expected 2 errors, got %v", errs)
+		}
+		for _, err := range errs {
+			if !errors.Is(err, ErrInvalidEscape) {
+				t.Fatalf("expected invalid escape, got %v", err)
+			}
+		}
+	})
+
+	t.Run("unicode positions", func(t *testing.T) {
+		l := New("\"é\" x\n  y")
+		expected := []token.Pos{
+			{Line: 1, Offset: 1, Byte: 0},
+			{Line: 1, Offset: 5, Byte: 5},
+			{Line: 2, Offset: 3, Byte: 9},
+		}
+		for i, e := range expected {
+			if pos := l.NextToken().Pos; pos != e {
+				t.Fatalf("test[%d] - wrong position. want=%s, got=%s", i, e, pos)
+			}
+		}
+	})
+
+	t.Run("end positions", func(t *testing.T) {
+		l := New("let café = \"naïve\";\n  x >= 1.5 // done")
+		l.KeepComments(true)
+		expected := []struct {
+			start, end token.Pos
+		}{
+			{token.Pos{Line: 1, Offset: 1, Byte: 0}, token.Pos{Line: 1, Offset: 4, Byte: 3}},
+			{token.Pos{Line: 1, Offset: 5, Byte: 4}, token.Pos{Line: 1, Offset: 9, Byte: 9}},
+			{token.Pos{Line: 1, Offset: 10, Byte: 10}, token.Pos{Line: 1, Offset: 11, Byte: 11}},
+			{token.Pos{Line: 1, Offset: 12, Byte: 12}, token.Pos{Line: 1, Offset: 19, Byte: 20}},
+			{token.Pos{Line: 1, Offset: 19, Byte: 20}, token.Pos{Line: 1, Offset: 20, Byte: 21}},
+			{token.Pos{Line: 2, Offset: 3, Byte: 24}, token.Pos{Line: 2, Offset: 4, Byte: 25}},
+			{token.Pos{Line: 2, Offset: 5, Byte: 26}, token.Pos{Line: 2, Offset: 7, Byte: 28}},
+			{token.Pos{Line: 2, Offset: 8, Byte: 29}, token.Pos{Line: 2, Offset: 11, Byte: 32}},
+			{token.Pos{Line: 2, Offset: 12, Byte: 33}, token.Pos{Line: 2, Offset: 19, Byte: 40}},
+			{token.Pos{Line: 2, Offset: 19, Byte: 40}, token.Pos{Line: 2, Offset: 19, Byte: 40}},
+		}
+		for i, e := range expected {
+			tok := l.NextToken()
+			if tok.Pos != e.start || tok.End != e.end {
+				t.Fatalf("test[%d] - wrong range for %s. want=%s-%s, got=%s-%s", i, tok, e.start, e.end, tok.Pos, tok.End)
+			}
+		}
+	})
+
+	t.Run("template", func(t *testing.T) {
+		ExpectTokens(t, `"x=${x}, y=${ {"a": y}["a"] + 1}!" "${"${z}"}"`, []token.Token{
+			token.New(token.TEMPLATE_HEAD, "x="),
+			token.New(token.IDENT, "x"),
+			token.New(token.TEMPLATE_MIDDLE, ", y="),
+			token.New(token.LBRACE, "{"),
+			token.New(token.STRING, "a"),
+			token.New(token.COLON, ":"),
+			token.New(token.IDENT, "y"),
+			token.New(token.RBRACE, "}"),
+			token.New(token.LBRACKET, "["),
+			token.New(token.STRING, "a"),
+			token.New(token.RBRACKET, "]"),
+			token.New(token.PLUS, "+"),
+			token.New(token.INT, "1"),
+			token.New(token.TEMPLATE_TAIL, "!"),
+			token.New(token.TEMPLATE_HEAD, ""),
+			token.New(token.TEMPLATE_HEAD, ""),
+			token.New(token.IDENT, "z"),
+			token.New(token.TEMPLATE_TAIL, ""),
+			token.New(token.TEMPLATE_TAIL, ""),
+			token.New(token.EOF, ""),
+		})
+		ExpectTokens(t, `"\${x}"`, []token.Token{
+			token.New(token.STRING, "${x}"),
+			token.New(token.EOF, ""),
+		})
+	})
+
+	t.Run("raw strings", func(t *testing.T) {
+		ExpectTokens(t, "`a\\d+\n${x}\\n` + `\r\n`", []token.Token{
+			token.New(token.STRING, "a\\d+\n${x}\\n"),
+			token.New(token.PLUS, "+"),
+			token.New(token.STRING, "\n"),
+			token.New(token.EOF, ""),
+		})
+	})
+
+	t.Run("unterminated strings", func(t *testing.T) {
+		tests := []struct {
+			input string
+			err   string
+		}{
+			{`x = "abc`, "1:5: unterminated string literal"},
+			{"x = `abc", "1:5: unterminated raw string literal"},
+			{`"${x}abc`, "1:5: unterminated string literal"},
+		}
+		for _, tt := range tests {
+			l := New(tt.input)
+			for l.NextToken().Type != token.EOF {
+			}
+			if errs := l.Errors(); len(errs) != 1 || errs[0].Error() != tt.err {
+				t.Fatalf("%s: unexpected errors: %v", tt.input, errs)
+			}
+		}
+	})
+
+	t.Run("illegal characters", func(t *testing.T) {
+		l := New("a @ b $ #")
+		var illegal int
+		for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+			if tok.Type == token.ILLEGAL {
+				illegal++
+			}
+		}
+		if illegal != 3 {
+			t.Fatalf("expected 3 illegal tokens, got %d", illegal)
+		}
+		errs := l.Errors()
+		if len(errs) != 3 {
+			t.Fatalf("expected 3 errors, got %v", errs)
+		}
+		if !errors.Is(errs[0], ErrIllegalCharacter) {
+			t.Fatalf("expected illegal character, got %v", errs[0])
+		}
+		if want := "1:3: illegal character '@'"; errs[0].Error() != want {
+			t.Fatalf("expected %q, got %q", want, errs[0].Error())
+		}
+		if want := (token.Pos{Line: 1, Offset: 9, Byte: 8}); errs[2].Pos != want {
+			t.Fatalf("expected %s, got %s", want, errs[2].Pos)
+		}
+	})
+
+	t.Run("reader", func(t *testing.T) {
+		input := "let s = `${x}`; // done\n/* x */ 0x1F >= 2.5e3"
+		var want, got []token.Token
+		l := New(input)
+		l.KeepComments(true)
+		for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+			want = append(want, tok)
+		}
+		l = NewReader(iotest.OneByteReader(strings.NewReader(input)))
+		l.KeepComments(true)
+		for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+			got = append(got, tok)
+		}
+		if !reflect.DeepEqual(want, got) {
+			t.Fatalf("want %v, got %v", want, got)
+		}
+	})
+
+	t.Run("reader error", func(t *testing.T) {
+		l := NewReader(iotest.TimeoutReader(strings.NewReader("abc def")))
+		for l.NextToken().Type != token.EOF {
+		}
+		errs := l.Errors()
+		if len(errs) != 1 || !errors.Is(errs[0], iotest.ErrTimeout) {
+			t.Fatalf("unexpected errors: %v", errs)
+		}
+	})
+
+	t.Run("empty input", func(t *testing.T) {
+		for _, input := range []string{"", " ", "\n\t\r\n"} {
+			ExpectTokens(t, input, []token.Token{
+				token.New(token.EOF, ""),
+			})
+		}
+	})
+
 }
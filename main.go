@@ -1,25 +1,57 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"log"
 	"os"
 
 	"github.com/icholy/monkey/evaluator"
+	"github.com/icholy/monkey/parser"
 )
 
 func main() {
 
 	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "tokens":
+			if err := tokens(os.Args[2:]); err != nil {
+				fatal(err)
+			}
+			return
+		case "ast":
+			if err := dumpAST(os.Args[2:]); err != nil {
+				fatal(err)
+			}
+			return
+		case "fmt":
+			if err := format(os.Args[2:]); err != nil {
+				fatal(err)
+			}
+			return
+		}
 		f, err := os.Open(os.Args[1])
 		if err != nil {
 			log.Fatal(err)
 		}
 		defer f.Close()
 		if err := evaluator.Run(f); err != nil {
-			log.Fatal(err)
+			fatal(err)
 		}
 		return
 	}
 
 	evaluator.REPL2(os.Stdin, os.Stdout)
 }
+
+// fatal exits after printing err, with one line per syntax error.
+func fatal(err error) {
+	var list parser.ErrorList
+	if errors.As(err, &list) {
+		for _, err := range list {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		os.Exit(1)
+	}
+	log.Fatal(err)
+}
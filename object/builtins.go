@@ -26,7 +26,7 @@ var Builtins = []*Builtin{
 			}
 			switch obj := args[0].(type) {
 			case *String:
-				return &Integer{Value: int64(len(obj.Value))}, nil
+				return &Integer{Value: int64(obj.Len())}, nil
 			case *Array:
 				return &Integer{Value: int64(len(obj.Elements))}, nil
 			case *Hash:
@@ -81,8 +81,9 @@ var Builtins = []*Builtin{
 			if len(arr.Elements) == 0 {
 				return &Array{}, nil
 			}
+			// copy so the result doesn't alias arr
 			return &Array{
-				Elements: arr.Elements[1:],
+				Elements: append([]Object{}, arr.Elements[1:]...),
 			}, nil
 		}),
 	},
@@ -135,7 +136,7 @@ var Builtins = []*Builtin{
 			if v.Type() == STRING {
 				return v, nil
 			}
-			return &String{Value: v.Inspect(0)}, nil
+			return &String{Value: ToString(v)}, nil
 		}),
 	},
 	&Builtin{
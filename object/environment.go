@@ -7,6 +7,9 @@ import (
 type Env struct {
 	parent *Env
 	store  map[string]Object
+	// defers holds the calls deferred by the function the environment
+	// was created for
+	defers []func() error
 }
 
 func NewEnv(parent *Env) *Env {
@@ -53,3 +56,13 @@ func (e *Env) Locals() Object {
 	}
 	return hash
 }
+
+// Defer adds fn to the calls to make when the function returns.
+func (e *Env) Defer(fn func() error) {
+	e.defers = append(e.defers, fn)
+}
+
+// Deferred returns the deferred calls in the order they were added.
+func (e *Env) Deferred() []func() error {
+	return e.defers
+}
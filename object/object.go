@@ -2,8 +2,11 @@ package object
 
 import (
 	"fmt"
+	"math"
+	"sort"
 	"strconv"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/icholy/monkey/code"
 
@@ -14,9 +17,12 @@ type ObjectType string
 
 const (
 	INTEGER           = "INTEGER"
+	FLOAT             = "FLOAT"
 	NULL              = "NULL"
 	BOOLEAN           = "BOOLEAN"
 	RETURN            = "RETURN"
+	BREAK             = "BREAK"
+	CONTINUE          = "CONTINUE"
 	FUNCTION          = "FUNCTION"
 	STRING            = "STRING"
 	BUILTIN           = "BUILTIN"
@@ -24,6 +30,8 @@ const (
 	HASH              = "HASH"
 	COMPILED_FUNCTION = "COMPILED_FUNCTION"
 	CLOSURE           = "CLOSURE"
+	ITERATOR          = "ITERATOR"
+	ERROR             = "ERROR"
 )
 
 var MaxDepth = 10
@@ -34,6 +42,7 @@ func space(depth int) string {
 
 var types = map[string]ObjectType{
 	"integer":  INTEGER,
+	"float":    FLOAT,
 	"boolean":  BOOLEAN,
 	"string":   STRING,
 	"array":    ARRAY,
@@ -59,14 +68,24 @@ func New(value interface{}) Object {
 	switch value := value.(type) {
 	case int:
 		return &Integer{Value: int64(value)}
+	case float64:
+		return &Float{Value: value}
 	case bool:
 		return &Boolean{Value: value}
 	case string:
 		return &String{Value: value}
 	case map[interface{}]interface{}:
+		// add the keys in a fixed order since hashes keep insertion order
+		keys := make([]interface{}, 0, len(value))
+		for k := range value {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j])
+		})
 		h := NewHash()
-		for k, v := range value {
-			h.Set(New(k), New(v))
+		for _, k := range keys {
+			h.Set(New(k), New(value[k]))
 		}
 		return h
 	case []interface{}:
@@ -100,6 +119,27 @@ func (i *Integer) KeyValue() KeyValue       { return i.Value }
 func (i *Integer) Inspect(depth int) string { return fmt.Sprintf("%d", i.Value) }
 func (i *Integer) Type() ObjectType         { return INTEGER }
 
+type Float struct {
+	Value float64
+}
+
+// KeyValue returns integral floats as integers so that 1.0 and 1,
+// which are equal, are also the same hash key.
+func (f *Float) KeyValue() KeyValue {
+	if f.Value == math.Trunc(f.Value) && f.Value >= math.MinInt64 && f.Value < math.MaxInt64 {
+		return int64(f.Value)
+	}
+	return f.Value
+}
+func (f *Float) Inspect(depth int) string {
+	s := strconv.FormatFloat(f.Value, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".eIN") {
+		s += ".0"
+	}
+	return s
+}
+func (f *Float) Type() ObjectType { return FLOAT }
+
 type Boolean struct {
 	Value bool
 }
@@ -112,11 +152,50 @@ type String struct {
 	Value string
 }
 
+// At returns the character at rune index i, negative indices count from
+// the end. Only the runes up to i are decoded so indexing near the start
+// of a long string stays cheap.
 func (s *String) At(i int) (Object, error) {
-	if i < 0 || i >= len(s.Value) {
-		return nil, fmt.Errorf("%d out of range", i)
+	str := s.Value
+	if i >= 0 {
+		// ascii prefix, byte and rune indices are the same
+		n := 0
+		for n <= i && n < len(str) && str[n] < utf8.RuneSelf {
+			n++
+		}
+		if n > i {
+			return &String{Value: str[i : i+1]}, nil
+		}
+		str = str[n:]
+		for j := n; len(str) > 0; j++ {
+			r, size := utf8.DecodeRuneInString(str)
+			if j == i {
+				return &String{Value: string(r)}, nil
+			}
+			str = str[size:]
+		}
+	} else {
+		for j := -1; len(str) > 0; j-- {
+			r, size := utf8.DecodeLastRuneInString(str)
+			if j == i {
+				return &String{Value: string(r)}, nil
+			}
+			str = str[:len(str)-size]
+		}
+	}
+	return nil, fmt.Errorf("%d out of range", i)
+}
+
+func (s *String) Len() int {
+	return utf8.RuneCountInString(s.Value)
+}
+
+// ToString returns the value used when obj is converted to a string.
+func ToString(obj Object) string {
+	if s, ok := obj.(*String); ok {
+		return s.Value
 	}
-	return &String{Value: string(s.Value[i])}, nil
+	return obj.Inspect(0)
 }
 
 func (s *String) KeyValue() KeyValue       { return s.Value }
@@ -144,6 +223,56 @@ func UnwrapReturn(obj Object) Object {
 	return obj
 }
 
+// Break and Continue are returned by the statements with the same name
+// and passed up to the loop they target. Label is empty for the
+// innermost loop.
+type Break struct {
+	Label string
+}
+
+func (b *Break) KeyValue() KeyValue       { return b }
+func (b *Break) Inspect(depth int) string { return "break" }
+func (b *Break) Type() ObjectType         { return BREAK }
+
+type Continue struct {
+	Label string
+}
+
+func (c *Continue) KeyValue() KeyValue       { return c }
+func (c *Continue) Inspect(depth int) string { return "continue" }
+func (c *Continue) Type() ObjectType         { return CONTINUE }
+
+// Error is a runtime error caught by a try statement. Message doesn't
+// include the position of the error.
+type Error struct {
+	Message string
+}
+
+func (e *Error) KeyValue() KeyValue       { return e }
+func (e *Error) Inspect(depth int) string { return fmt.Sprintf("error(%q)", e.Message) }
+func (e *Error) Type() ObjectType         { return ERROR }
+
+// Get returns the property named by key, the only one is "message".
+func (e *Error) Get(key Object) (Object, bool) {
+	if s, ok := key.(*String); ok && s.Value == "message" {
+		return &String{Value: e.Message}, true
+	}
+	return nil, false
+}
+
+// Exception is the error used to unwind the stack when a value is
+// thrown.
+type Exception struct {
+	Value Object
+}
+
+func (e *Exception) Error() string {
+	if err, ok := e.Value.(*Error); ok {
+		return err.Message
+	}
+	return fmt.Sprintf("uncaught exception: %s", e.Value.Inspect(0))
+}
+
 type Function struct {
 	Parameters []*ast.Parameter
 	Body       *ast.BlockStatement
@@ -157,7 +286,11 @@ func (f *Function) Type() ObjectType { return FUNCTION }
 func (f *Function) Inspect(depth int) string {
 	var params []string
 	for _, p := range f.Parameters {
-		params = append(params, p.Name.Value)
+		if p.Pattern != nil {
+			params = append(params, p.Pattern.String())
+		} else {
+			params = append(params, p.Name.Value)
+		}
 	}
 	return fmt.Sprintf("fn(%s)", strings.Join(params, ", "))
 }
@@ -170,11 +303,21 @@ func (a *Array) InRange(i int) bool {
 	return i >= 0 && i < len(a.Elements)
 }
 
+// At returns the element at index i. Negative indexes count back from
+// the end.
 func (a *Array) At(i int) (Object, error) {
-	if !a.InRange(i) {
+	j := a.index(i)
+	if !a.InRange(j) {
 		return nil, fmt.Errorf("%d not in range", i)
 	}
-	return a.Elements[i], nil
+	return a.Elements[j], nil
+}
+
+func (a *Array) index(i int) int {
+	if i < 0 {
+		return i + len(a.Elements)
+	}
+	return i
 }
 
 func (a *Array) Append(v Object) {
@@ -182,10 +325,11 @@ func (a *Array) Append(v Object) {
 }
 
 func (a *Array) SetAt(i int, v Object) error {
-	if !a.InRange(i) {
+	j := a.index(i)
+	if !a.InRange(j) {
 		return fmt.Errorf("%d not in range", i)
 	}
-	a.Elements[i] = v
+	a.Elements[j] = v
 	return nil
 }
 
@@ -212,8 +356,11 @@ type HashPair struct {
 	Value Object
 }
 
+// Hash is a map which keeps its keys in insertion order. Setting an
+// existing key doesn't move it.
 type Hash struct {
 	pairs map[KeyValue]*HashPair
+	keys  []KeyValue
 }
 
 func NewHash() *Hash {
@@ -223,7 +370,11 @@ func NewHash() *Hash {
 }
 
 func (h *Hash) Set(key, value Object) {
-	h.pairs[key.KeyValue()] = &HashPair{
+	k := key.KeyValue()
+	if _, ok := h.pairs[k]; !ok {
+		h.keys = append(h.keys, k)
+	}
+	h.pairs[k] = &HashPair{
 		Key:   key,
 		Value: value,
 	}
@@ -244,17 +395,28 @@ func (h *Hash) Get(key Object) (Object, bool) {
 }
 
 func (h *Hash) Delete(key Object) {
-	delete(h.pairs, key.KeyValue())
+	k := key.KeyValue()
+	if _, ok := h.pairs[k]; !ok {
+		return
+	}
+	delete(h.pairs, k)
+	for i, kk := range h.keys {
+		if kk == k {
+			h.keys = append(h.keys[:i], h.keys[i+1:]...)
+			break
+		}
+	}
 }
 
 func (h *Hash) Len() int {
 	return len(h.pairs)
 }
 
+// Pairs returns the pairs in insertion order.
 func (h *Hash) Pairs() []*HashPair {
 	var pairs []*HashPair
-	for _, p := range h.pairs {
-		pairs = append(pairs, p)
+	for _, k := range h.keys {
+		pairs = append(pairs, h.pairs[k])
 	}
 	return pairs
 }
@@ -269,7 +431,7 @@ func (h *Hash) Inspect(depth int) string {
 		return "{}"
 	}
 	var pairs []string
-	for _, p := range h.pairs {
+	for _, p := range h.Pairs() {
 		key := p.Key.Inspect(depth + 1)
 		value := p.Value.Inspect(depth + 1)
 		pairs = append(pairs, fmt.Sprintf("%s%s: %s", space(depth+1), key, value))
@@ -279,8 +441,16 @@ func (h *Hash) Inspect(depth int) string {
 
 type CompiledFunction struct {
 	Instructions  code.Instructions
+	SourceMap     code.SourceMap
+	Handlers      code.Handlers
 	NumLocals     int
 	NumParameters int
+	// NumDefaults is the number of trailing parameters, before the rest
+	// parameter, which have default values
+	NumDefaults int
+	// Rest is set when the last parameter collects the remaining
+	// arguments
+	Rest bool
 }
 
 func (cf *CompiledFunction) Type() ObjectType { return COMPILED_FUNCTION }
@@ -289,6 +459,64 @@ func (cf *CompiledFunction) Inspect(depth int) string {
 }
 func (cf *CompiledFunction) KeyValue() KeyValue { return cf }
 
+// Slice returns a copy of the part of an array or string from low up
+// to high. Either bound may be null, and negative bounds count back from
+// the end.
+func Slice(value, low, high Object) (Object, error) {
+	switch value := value.(type) {
+	case *Array:
+		lo, hi, err := sliceBounds(low, high, len(value.Elements))
+		if err != nil {
+			return nil, err
+		}
+		elements := make([]Object, hi-lo)
+		copy(elements, value.Elements[lo:hi])
+		return &Array{Elements: elements}, nil
+	case *String:
+		runes := []rune(value.Value)
+		lo, hi, err := sliceBounds(low, high, len(runes))
+		if err != nil {
+			return nil, err
+		}
+		return &String{Value: string(runes[lo:hi])}, nil
+	default:
+		return nil, fmt.Errorf("cannot slice %s", value.Type())
+	}
+}
+
+func sliceBounds(low, high Object, n int) (int, int, error) {
+	lo, err := sliceBound(low, 0, n)
+	if err != nil {
+		return 0, 0, err
+	}
+	hi, err := sliceBound(high, n, n)
+	if err != nil {
+		return 0, 0, err
+	}
+	if lo > hi {
+		return 0, 0, fmt.Errorf("invalid slice indices: %d > %d", lo, hi)
+	}
+	return lo, hi, nil
+}
+
+func sliceBound(bound Object, def, n int) (int, error) {
+	switch bound := bound.(type) {
+	case *Null:
+		return def, nil
+	case *Integer:
+		i := int(bound.Value)
+		if i < 0 {
+			i += n
+		}
+		if i < 0 || i > n {
+			return 0, fmt.Errorf("slice bound %d out of range", bound.Value)
+		}
+		return i, nil
+	default:
+		return 0, fmt.Errorf("slice bound must be an integer, got %s", bound.Type())
+	}
+}
+
 type Closure struct {
 	Fn   *CompiledFunction
 	Free []Object
@@ -297,3 +525,73 @@ type Closure struct {
 func (c *Closure) Type() ObjectType         { return CLOSURE }
 func (c *Closure) Inspect(depth int) string { return fmt.Sprintf("Closure(%d)", c.Fn.NumParameters) }
 func (c *Closure) KeyValue() KeyValue       { return c }
+
+// Iterator steps through the elements of an array, hash, string or
+// integer. The key is the hash key for hashes and the index for
+// everything else.
+type Iterator struct {
+	obj   Object
+	i     int
+	pairs []*HashPair
+	runes []rune
+}
+
+func NewIterator(obj Object) (*Iterator, error) {
+	it := &Iterator{obj: obj}
+	switch obj := obj.(type) {
+	case *Array, *Integer:
+	case *Hash:
+		it.pairs = obj.Pairs()
+	case *String:
+		it.runes = []rune(obj.Value)
+	default:
+		return nil, fmt.Errorf("cannot iterate over %s", obj.Type())
+	}
+	return it, nil
+}
+
+// Next returns the next key and value. It returns false when there are
+// no elements left.
+func (it *Iterator) Next() (key, value Object, ok bool) {
+	i := it.i
+	switch obj := it.obj.(type) {
+	case *Array:
+		if i >= len(obj.Elements) {
+			return nil, nil, false
+		}
+		key, value = &Integer{Value: int64(i)}, obj.Elements[i]
+	case *Integer:
+		if int64(i) >= obj.Value {
+			return nil, nil, false
+		}
+		key = &Integer{Value: int64(i)}
+		value = key
+	case *Hash:
+		if i >= len(it.pairs) {
+			return nil, nil, false
+		}
+		key, value = it.pairs[i].Key, it.pairs[i].Value
+	case *String:
+		if i >= len(it.runes) {
+			return nil, nil, false
+		}
+		key, value = &Integer{Value: int64(i)}, &String{Value: string(it.runes[i])}
+	default:
+		return nil, nil, false
+	}
+	it.i++
+	return key, value, true
+}
+
+// Single returns the element a loop with one variable sees: the key for
+// hashes and the value for everything else.
+func (it *Iterator) Single(key, value Object) Object {
+	if _, ok := it.obj.(*Hash); ok {
+		return key
+	}
+	return value
+}
+
+func (it *Iterator) Type() ObjectType         { return ITERATOR }
+func (it *Iterator) Inspect(depth int) string { return fmt.Sprintf("Iterator(%s)", it.obj.Type()) }
+func (it *Iterator) KeyValue() KeyValue       { return it }
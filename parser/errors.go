@@ -0,0 +1,59 @@
+package parser
+
+import (
+	"fmt"
+
+	"github.com/icholy/monkey/token"
+)
+
+// MaxErrors is the number of errors after which the parser gives up.
+const MaxErrors = 10
+
+// Error is a single syntax error.
+type Error struct {
+	Pos token.Pos
+	Msg string
+	// Err is the lexer error this was reported for, if any
+	Err error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Pos, e.Msg)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// ErrorList is a list of errors. The zero value is an empty list ready to use.
+type ErrorList []*Error
+
+func (l *ErrorList) Add(pos token.Pos, msg string) {
+	*l = append(*l, &Error{Pos: pos, Msg: msg})
+}
+
+func (l ErrorList) Error() string {
+	switch len(l) {
+	case 0:
+		return "no errors"
+	case 1:
+		return l[0].Error()
+	}
+	return fmt.Sprintf("%s (and %d more errors)", l[0], len(l)-1)
+}
+
+func (l ErrorList) Unwrap() []error {
+	errs := make([]error, len(l))
+	for i, err := range l {
+		errs[i] = err
+	}
+	return errs
+}
+
+// Err returns an error equivalent to this list, or nil if it's empty.
+func (l ErrorList) Err() error {
+	if len(l) == 0 {
+		return nil
+	}
+	return l
+}
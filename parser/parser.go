@@ -3,7 +3,10 @@ package parser
 import (
 	"errors"
 	"fmt"
+	"io"
+	"reflect"
 	"strconv"
+	"strings"
 
 	"github.com/icholy/monkey/ast"
 	"github.com/icholy/monkey/lexer"
@@ -13,12 +16,16 @@ import (
 const (
 	_ int = iota
 	LOWEST
+	PIPELINE
+	CONDITIONAL
+	COALESCE
 	ANDOR
 	EQUALS
 	LESSGREATER
 	SUM
 	PRODUCT
 	PREFIX
+	POWER
 	CALL
 	INDEX
 	ASSIGN
@@ -34,7 +41,19 @@ type Parser struct {
 
 	cur    token.Token
 	peek   token.Token
-	errors []string
+	errors ErrorList
+	eof    bool
+	// bad is set when an error is found and cleared by sync
+	bad bool
+	// depth is the number of braces opened before cur, including cur
+	depth int
+
+	comments []*ast.Comment
+	// loops holds the labels of the enclosing loops in the current
+	// function, unlabeled loops use ""
+	loops []string
+	// fn is set while parsing a function body
+	fn bool
 
 	precedences map[token.TokenType]int
 	prefixFns   map[token.TokenType]prefixFn
@@ -42,11 +61,18 @@ type Parser struct {
 }
 
 func Parse(input string) (*ast.Program, error) {
-	l := lexer.New(input)
+	return parse(lexer.New(input))
+}
+
+func ParseReader(r io.Reader) (*ast.Program, error) {
+	return parse(lexer.NewReader(r))
+}
+
+func parse(l *lexer.Lexer) (*ast.Program, error) {
 	p := New(l)
 	prog := p.ParseProgram()
-	if errs := p.Errors(); len(errs) != 0 {
-		return nil, errors.New(errs[0])
+	if err := p.Err(); err != nil {
+		return nil, err
 	}
 	return prog, nil
 }
@@ -55,66 +81,123 @@ func New(l *lexer.Lexer) *Parser {
 	p := &Parser{
 		l: l,
 	}
+	l.KeepComments(true)
 	p.precedences = map[token.TokenType]int{
-		token.EQ:       EQUALS,
-		token.NE:       EQUALS,
-		token.IN:       EQUALS,
-		token.LT:       LESSGREATER,
-		token.LT_EQ:    LESSGREATER,
-		token.GT:       LESSGREATER,
-		token.GT_EQ:    LESSGREATER,
-		token.OR:       ANDOR,
-		token.AND:      ANDOR,
-		token.PLUS:     SUM,
-		token.MINUS:    SUM,
-		token.SLASH:    PRODUCT,
-		token.ASTERISK: PRODUCT,
-		token.LPAREN:   CALL,
-		token.LBRACKET: INDEX,
-		token.DOT:      INDEX,
-		token.ASSIGN:   ASSIGN,
+		token.EQ:              EQUALS,
+		token.NE:              EQUALS,
+		token.IN:              EQUALS,
+		token.LT:              LESSGREATER,
+		token.LT_EQ:           LESSGREATER,
+		token.GT:              LESSGREATER,
+		token.GT_EQ:           LESSGREATER,
+		token.OR:              ANDOR,
+		token.AND:             ANDOR,
+		token.NULLISH:         COALESCE,
+		token.QUESTION:        CONDITIONAL,
+		token.PIPELINE:        PIPELINE,
+		token.PLUS:            SUM,
+		token.MINUS:           SUM,
+		token.PIPE:            SUM,
+		token.CARET:           SUM,
+		token.SLASH:           PRODUCT,
+		token.ASTERISK:        PRODUCT,
+		token.PERCENT:         PRODUCT,
+		token.AMPERSAND:       PRODUCT,
+		token.SHL:             PRODUCT,
+		token.SHR:             PRODUCT,
+		token.POWER:           POWER,
+		token.LPAREN:          CALL,
+		token.LBRACKET:        INDEX,
+		token.DOT:             INDEX,
+		token.QUESTION_DOT:    INDEX,
+		token.ASSIGN:          ASSIGN,
+		token.PLUS_ASSIGN:     ASSIGN,
+		token.MINUS_ASSIGN:    ASSIGN,
+		token.ASTERISK_ASSIGN: ASSIGN,
+		token.SLASH_ASSIGN:    ASSIGN,
+		token.PERCENT_ASSIGN:  ASSIGN,
 	}
 	p.prefixFns = map[token.TokenType]prefixFn{
-		token.IDENT:    p.identExpr,
-		token.INT:      p.integerExpr,
-		token.STRING:   p.stringLit,
-		token.BANG:     p.prefixExpr,
-		token.MINUS:    p.prefixExpr,
-		token.TRUE:     p.booleanExpr,
-		token.FALSE:    p.booleanExpr,
-		token.NULL:     p.nullExpr,
-		token.LPAREN:   p.groupesExpr,
-		token.LBRACKET: p.arrayExpr,
-		token.IF:       p.ifExpr,
-		token.FN:       p.fnExpr,
-		token.LBRACE:   p.hashExpr,
+		token.IDENT:         p.identExpr,
+		token.INT:           p.integerExpr,
+		token.FLOAT:         p.floatExpr,
+		token.STRING:        p.stringLit,
+		token.TEMPLATE_HEAD: p.templateLit,
+		token.BANG:          p.prefixExpr,
+		token.MINUS:         p.prefixExpr,
+		token.TILDE:         p.prefixExpr,
+		token.TRUE:          p.booleanExpr,
+		token.FALSE:         p.booleanExpr,
+		token.NULL:          p.nullExpr,
+		token.LPAREN:        p.groupesExpr,
+		token.LBRACKET:      p.arrayExpr,
+		token.IF:            p.ifExpr,
+		token.FN:            p.fnExpr,
+		token.LBRACE:        p.hashExpr,
 	}
 	p.infixFns = map[token.TokenType]infixFn{
-		token.PLUS:     p.infixExpr,
-		token.MINUS:    p.infixExpr,
-		token.SLASH:    p.infixExpr,
-		token.ASTERISK: p.infixExpr,
-		token.EQ:       p.infixExpr,
-		token.NE:       p.infixExpr,
-		token.LT:       p.infixExpr,
-		token.LT_EQ:    p.infixExpr,
-		token.GT:       p.infixExpr,
-		token.GT_EQ:    p.infixExpr,
-		token.OR:       p.infixExpr,
-		token.AND:      p.infixExpr,
-		token.IN:       p.infixExpr,
-		token.LPAREN:   p.callExpr,
-		token.LBRACKET: p.indexExpr,
-		token.ASSIGN:   p.assignExpr,
-		token.DOT:      p.propertyExpr,
+		token.PLUS:            p.infixExpr,
+		token.MINUS:           p.infixExpr,
+		token.SLASH:           p.infixExpr,
+		token.ASTERISK:        p.infixExpr,
+		token.PERCENT:         p.infixExpr,
+		token.POWER:           p.infixExpr,
+		token.AMPERSAND:       p.infixExpr,
+		token.PIPE:            p.infixExpr,
+		token.CARET:           p.infixExpr,
+		token.SHL:             p.infixExpr,
+		token.SHR:             p.infixExpr,
+		token.EQ:              p.infixExpr,
+		token.NE:              p.infixExpr,
+		token.LT:              p.infixExpr,
+		token.LT_EQ:           p.infixExpr,
+		token.GT:              p.infixExpr,
+		token.GT_EQ:           p.infixExpr,
+		token.OR:              p.infixExpr,
+		token.AND:             p.infixExpr,
+		token.NULLISH:         p.infixExpr,
+		token.QUESTION:        p.conditionalExpr,
+		token.PIPELINE:        p.pipelineExpr,
+		token.IN:              p.infixExpr,
+		token.LPAREN:          p.callExpr,
+		token.LBRACKET:        p.indexExpr,
+		token.ASSIGN:          p.assignExpr,
+		token.PLUS_ASSIGN:     p.assignExpr,
+		token.MINUS_ASSIGN:    p.assignExpr,
+		token.ASTERISK_ASSIGN: p.assignExpr,
+		token.SLASH_ASSIGN:    p.assignExpr,
+		token.PERCENT_ASSIGN:  p.assignExpr,
+		token.DOT:             p.propertyExpr,
+		token.QUESTION_DOT:    p.optionalExpr,
 	}
 	p.next()
 	p.next()
 	return p
 }
 
-func (p *Parser) Errors() []string {
-	return p.errors
+// Errors returns the lexer errors followed by the parser errors.
+func (p *Parser) Errors() ErrorList {
+	var errs ErrorList
+	for _, err := range p.l.Errors() {
+		errs = append(errs, &Error{Pos: err.Pos, Msg: err.Err.Error(), Err: err})
+	}
+	return append(errs, p.errors...)
+}
+
+func (p *Parser) Err() error {
+	return p.Errors().Err()
+}
+
+// Incomplete reports whether the first error was caused by the input
+// ending early. More input might make the program valid.
+func (p *Parser) Incomplete() bool {
+	if errs := p.l.Errors(); len(errs) != 0 {
+		err := errs[0].Err
+		return err == lexer.ErrUnterminatedComment ||
+			err == lexer.ErrUnterminatedString ||
+			err == lexer.ErrUnterminatedRawString
+	}
+	return p.eof
 }
 
 func (p *Parser) precedence(t token.Token) int {
@@ -126,17 +209,35 @@ func (p *Parser) precedence(t token.Token) int {
 
 func (p *Parser) next() {
 	p.cur = p.peek
+	switch p.cur.Type {
+	case token.LBRACE:
+		p.depth++
+	case token.RBRACE:
+		p.depth--
+	}
 	p.peek = p.l.NextToken()
+	for p.peek.Is(token.COMMENT) {
+		comment := &ast.Comment{Token: p.peek, Text: p.peek.Text}
+		comment.SetSpan(p.peek.Pos, p.peek.End)
+		p.comments = append(p.comments, comment)
+		p.peek = p.l.NextToken()
+	}
 }
 
 func (p *Parser) ParseProgram() *ast.Program {
 	program := &ast.Program{}
-	for !p.cur.Is(token.EOF) {
+	start := p.cur.Pos
+	for !p.cur.Is(token.EOF) && len(p.errors) < MaxErrors {
 		if stmt := p.stmt(); stmt != nil {
 			program.Statements = append(program.Statements, stmt)
 		}
+		if p.bad {
+			p.sync(0)
+		}
 		p.next()
 	}
+	p.span(program, start)
+	program.Comments = p.comments
 	return program
 }
 
@@ -151,20 +252,181 @@ func (p *Parser) nullExpr() ast.Expression {
 	return &ast.NullExpression{Token: p.cur}
 }
 
-func (p *Parser) whileStmt() *ast.WhileStatement {
+func (p *Parser) whileStmt(label string) *ast.WhileStatement {
 	while := &ast.WhileStatement{Token: p.cur}
 	p.next()
 	while.Condition = p.expression(LOWEST)
 	if !p.expectPeek(token.LBRACE) {
 		return nil
 	}
-	while.Body = p.blockStmt()
+	while.Body = p.loopBody(label)
 	p.semicolon()
 	return while
 }
 
+func (p *Parser) tryStmt() *ast.TryStatement {
+	stmt := &ast.TryStatement{Token: p.cur}
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	stmt.Block = p.blockStmt()
+	if !p.peek.Is(token.CATCH) && !p.peek.Is(token.FINALLY) {
+		p.errorAt(p.peek, "expected CATCH or FINALLY, got %s instead", p.peek)
+		return nil
+	}
+	if p.peek.Is(token.CATCH) {
+		p.next()
+		if p.peek.Is(token.LPAREN) {
+			p.next()
+			if !p.expectPeek(token.IDENT) {
+				return nil
+			}
+			stmt.Param = p.ident()
+			if !p.expectPeek(token.RPAREN) {
+				return nil
+			}
+		}
+		if !p.expectPeek(token.LBRACE) {
+			return nil
+		}
+		stmt.Catch = p.blockStmt()
+	}
+	if p.peek.Is(token.FINALLY) {
+		p.next()
+		if !p.expectPeek(token.LBRACE) {
+			return nil
+		}
+		stmt.Finally = p.blockStmt()
+	}
+	p.semicolon()
+	return stmt
+}
+
+func (p *Parser) throwStmt() *ast.ThrowStatement {
+	stmt := &ast.ThrowStatement{Token: p.cur}
+	p.next()
+	stmt.Value = p.expression(LOWEST)
+	p.semicolon()
+	return stmt
+}
+
+func (p *Parser) deferStmt() *ast.DeferStatement {
+	stmt := &ast.DeferStatement{Token: p.cur}
+	if !p.fn {
+		p.errorAt(stmt.Token, "defer outside function")
+	}
+	p.next()
+	expr := p.expression(LOWEST)
+	call, ok := expr.(*ast.CallExpression)
+	if !ok {
+		if expr != nil {
+			p.errorAt(stmt.Token, "expression in defer must be function call")
+		}
+		return nil
+	}
+	stmt.Call = call
+	p.semicolon()
+	return stmt
+}
+
+func (p *Parser) forStmt(label string) *ast.ForStatement {
+	stmt := &ast.ForStatement{Token: p.cur}
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	stmt.Value = p.ident()
+	if p.peek.Is(token.COMMA) {
+		p.next()
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		stmt.Key = stmt.Value
+		stmt.Value = p.ident()
+	}
+	if !p.expectPeek(token.IN) {
+		return nil
+	}
+	p.next()
+	stmt.Iterable = p.expression(LOWEST)
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	stmt.Body = p.loopBody(label)
+	p.semicolon()
+	return stmt
+}
+
+// loopBody parses the body of a loop with the given label.
+func (p *Parser) loopBody(label string) *ast.BlockStatement {
+	p.loops = append(p.loops, label)
+	defer func() { p.loops = p.loops[:len(p.loops)-1] }()
+	return p.blockStmt()
+}
+
+// fnBody parses a function body. Branches inside it can't refer to the
+// loops around it.
+func (p *Parser) fnBody() *ast.BlockStatement {
+	loops, fn := p.loops, p.fn
+	p.loops, p.fn = nil, true
+	defer func() { p.loops, p.fn = loops, fn }()
+	return p.blockStmt()
+}
+
+func (p *Parser) labeledStmt() *ast.LabeledStatement {
+	stmt := &ast.LabeledStatement{Token: p.cur}
+	stmt.Label = p.ident()
+	p.next()
+	p.next()
+	start := p.cur.Pos
+	switch p.cur.Type {
+	case token.WHILE:
+		if s := p.whileStmt(stmt.Label.Value); s != nil {
+			stmt.Statement = s
+		}
+	case token.FOR:
+		if s := p.forStmt(stmt.Label.Value); s != nil {
+			stmt.Statement = s
+		}
+	default:
+		p.errorf("expected loop after label %s, got %s instead", stmt.Label, p.cur)
+	}
+	if stmt.Statement == nil {
+		return nil
+	}
+	p.span(stmt.Statement, start)
+	return stmt
+}
+
+func (p *Parser) branchStmt() *ast.BranchStatement {
+	stmt := &ast.BranchStatement{Token: p.cur}
+	// a label on the next line starts a new statement
+	if p.peek.Is(token.IDENT) && p.peek.Line == p.cur.Line {
+		p.next()
+		stmt.Label = p.ident()
+	}
+	switch {
+	case len(p.loops) == 0:
+		p.errorAt(stmt.Token, "%s outside loop", stmt.Token.Text)
+	case stmt.Label != nil && !p.hasLoop(stmt.Label.Value):
+		p.errorf("undefined loop label: %s", stmt.Label)
+	}
+	p.semicolon()
+	return stmt
+}
+
+// hasLoop reports whether there's an enclosing loop with the label.
+func (p *Parser) hasLoop(label string) bool {
+	for _, l := range p.loops {
+		if l == label {
+			return true
+		}
+	}
+	return false
+}
+
 func (p *Parser) caseStmt() *ast.CaseStatement {
 	stmt := &ast.CaseStatement{Token: p.cur}
+	start := p.cur.Pos
 	p.next()
 	stmt.Value = p.expression(LOWEST)
 	if !p.expectPeek(token.COLON) {
@@ -176,6 +438,7 @@ func (p *Parser) caseStmt() *ast.CaseStatement {
 			stmt.Statements = append(stmt.Statements, s)
 		}
 	}
+	p.span(stmt, start)
 	return stmt
 }
 
@@ -216,6 +479,33 @@ func (p *Parser) debuggerStmt() *ast.DebuggerStatement {
 	return debugger
 }
 
+// sync skips ahead after an error so that the next token starts a new
+// statement in the block at depth. It stops after a semicolon or before
+// a closing brace or statement keyword, skipping any braces the broken
+// statement left open.
+func (p *Parser) sync(depth int) {
+	p.bad = false
+	if p.cur.Is(token.SEMICOLON) && p.depth == depth {
+		return
+	}
+	for !p.peek.Is(token.EOF) && p.depth >= depth {
+		if p.depth > depth {
+			p.next()
+			continue
+		}
+		switch p.peek.Type {
+		case token.SEMICOLON:
+			p.next()
+			return
+		case token.RBRACE, token.LET, token.RETURN, token.FUNCTION, token.WHILE, token.FOR,
+			token.BREAK, token.CONTINUE, token.IMPORT, token.PACKAGE, token.SWITCH, token.DEBUGGER,
+			token.TRY, token.THROW, token.DEFER:
+			return
+		}
+		p.next()
+	}
+}
+
 func (p *Parser) semicolon() {
 	for p.peek.Is(token.SEMICOLON) {
 		p.next()
@@ -224,14 +514,26 @@ func (p *Parser) semicolon() {
 
 func (p *Parser) blockStmt() *ast.BlockStatement {
 	block := &ast.BlockStatement{Token: p.cur}
+	depth := p.depth
 	p.next()
 	for !p.cur.Is(token.RBRACE) && !p.cur.Is(token.EOF) {
 		stmt := p.stmt()
 		if stmt != nil {
 			block.Statements = append(block.Statements, stmt)
 		}
+		if p.bad {
+			p.sync(depth)
+			// the error was at the closing brace of this block
+			if p.depth < depth {
+				break
+			}
+		}
 		p.next()
 	}
+	if p.cur.Is(token.EOF) {
+		p.errorf("expected RBRACE, got %s instead", p.cur)
+	}
+	p.span(block, block.Token.Pos)
 	return block
 }
 
@@ -260,6 +562,89 @@ func (p *Parser) hashExpr() ast.Expression {
 	return hash
 }
 
+// pattern parses an identifier, or an array or hash pattern, which
+// values are destructured into.
+func (p *Parser) pattern() ast.Expression {
+	switch p.cur.Type {
+	case token.IDENT:
+		return p.ident()
+	case token.LBRACKET:
+		return p.arrayPattern()
+	case token.LBRACE:
+		return p.hashPattern()
+	default:
+		p.errorf("expected pattern, got %s instead", p.cur)
+		return nil
+	}
+}
+
+func (p *Parser) arrayPattern() *ast.ArrayPattern {
+	pattern := &ast.ArrayPattern{Token: p.cur}
+	for !p.peek.Is(token.RBRACKET) {
+		p.next()
+		if p.cur.Is(token.ELLIPSIS) {
+			p.next()
+			if pattern.Rest = p.pattern(); pattern.Rest == nil {
+				return nil
+			}
+			break
+		}
+		elem := p.pattern()
+		if elem == nil {
+			return nil
+		}
+		pattern.Elements = append(pattern.Elements, elem)
+		if !p.peek.Is(token.RBRACKET) && !p.expectPeek(token.COMMA) {
+			return nil
+		}
+	}
+	if !p.expectPeek(token.RBRACKET) {
+		return nil
+	}
+	p.span(pattern, pattern.Token.Pos)
+	return pattern
+}
+
+func (p *Parser) hashPattern() *ast.HashPattern {
+	pattern := &ast.HashPattern{Token: p.cur}
+	for !p.peek.Is(token.RBRACE) {
+		p.next()
+		var key ast.Expression
+		switch p.cur.Type {
+		case token.IDENT:
+			key = p.ident()
+		case token.STRING:
+			key = p.stringLit()
+			p.span(key, p.cur.Pos)
+		default:
+			p.errorf("expected hash pattern key, got %s instead", p.cur)
+			return nil
+		}
+		pair := &ast.HashPair{Key: key}
+		if ident, ok := key.(*ast.Identifier); ok && !p.peek.Is(token.COLON) {
+			// {name} is short for {name: name}
+			pair.Value = &ast.Identifier{Span: ident.Span, Token: ident.Token, Value: ident.Value}
+		} else {
+			if !p.expectPeek(token.COLON) {
+				return nil
+			}
+			p.next()
+			if pair.Value = p.pattern(); pair.Value == nil {
+				return nil
+			}
+		}
+		pattern.Pairs = append(pattern.Pairs, pair)
+		if !p.peek.Is(token.RBRACE) && !p.expectPeek(token.COMMA) {
+			return nil
+		}
+	}
+	if !p.expectPeek(token.RBRACE) {
+		return nil
+	}
+	p.span(pattern, pattern.Token.Pos)
+	return pattern
+}
+
 func (p *Parser) ifExpr() ast.Expression {
 	expr := &ast.IfExpression{Token: p.cur}
 	p.next()
@@ -282,18 +667,42 @@ func (p *Parser) ifExpr() ast.Expression {
 
 func (p *Parser) fnParameters() []*ast.Parameter {
 	var params []*ast.Parameter
-	for p.peek.Is(token.IDENT) {
+	for p.peek.Is(token.IDENT) || p.peek.Is(token.LBRACKET) || p.peek.Is(token.LBRACE) || p.peek.Is(token.ELLIPSIS) {
 		p.next()
 		param := &ast.Parameter{Token: p.cur}
-		param.Name = &ast.Identifier{Token: p.cur, Value: p.cur.Text}
-		if p.peek.Is(token.COLON) {
+		if p.cur.Is(token.ELLIPSIS) {
+			param.Rest = true
+			p.next()
+		}
+		if !p.cur.Is(token.IDENT) {
+			param.Pattern = p.pattern()
+			if param.Pattern == nil {
+				return nil
+			}
+		} else {
+			param.Name = p.ident()
+		}
+		if param.Name != nil && !param.Rest && p.peek.Is(token.COLON) {
 			p.next()
 			if !p.expectPeek(token.IDENT) {
 				return nil
 			}
-			param.Type = &ast.Identifier{Token: p.cur, Value: p.cur.Text}
+			param.Type = p.ident()
+		}
+		if !param.Rest && p.peek.Is(token.ASSIGN) {
+			p.next()
+			p.next()
+			param.Default = p.expression(LOWEST)
 		}
+		p.span(param, param.Token.Pos)
 		params = append(params, param)
+		if param.Rest {
+			if p.peek.Is(token.COMMA) {
+				p.errorAt(p.peek, "rest parameter must be last")
+				return nil
+			}
+			break
+		}
 		if p.peek.Is(token.COMMA) {
 			p.next()
 		}
@@ -307,7 +716,7 @@ func (p *Parser) fnParameters() []*ast.Parameter {
 func (p *Parser) functionStmt() ast.Statement {
 	stmt := &ast.FunctionStatement{Token: p.cur}
 	p.next()
-	stmt.Name = &ast.Identifier{Token: p.cur, Value: p.cur.Text}
+	stmt.Name = p.ident()
 	if !p.expectPeek(token.LPAREN) {
 		return nil
 	}
@@ -317,12 +726,12 @@ func (p *Parser) functionStmt() ast.Statement {
 		if !p.expectPeek(token.IDENT) {
 			return nil
 		}
-		stmt.ReturnType = &ast.Identifier{Token: p.cur, Value: p.cur.Text}
+		stmt.ReturnType = p.ident()
 	}
 	if !p.expectPeek(token.LBRACE) {
 		return nil
 	}
-	stmt.Body = p.blockStmt()
+	stmt.Body = p.fnBody()
 	p.semicolon()
 	return stmt
 }
@@ -338,33 +747,177 @@ func (p *Parser) fnExpr() ast.Expression {
 		if !p.expectPeek(token.IDENT) {
 			return nil
 		}
-		expr.ReturnType = &ast.Identifier{Token: p.cur, Value: p.cur.Text}
+		expr.ReturnType = p.ident()
 	}
 	if !p.expectPeek(token.LBRACE) {
 		return nil
 	}
-	expr.Body = p.blockStmt()
+	expr.Body = p.fnBody()
 	return expr
 }
 
+// groupesExpr parses a parenthesized expression or the parameter list
+// of an arrow function. The contents are parsed as expressions and
+// converted into parameters once the => is found.
 func (p *Parser) groupesExpr() ast.Expression {
-	p.next()
-	expr := p.expression(LOWEST)
-	if !p.expectPeek(token.RPAREN) {
+	if p.peek.Is(token.RPAREN) {
+		p.next()
+		if !p.expectPeek(token.ARROW) {
+			return nil
+		}
+		return p.arrowExpr(nil)
+	}
+	exprs := p.delimitedExpr(token.RPAREN)
+	if exprs == nil {
 		return nil
 	}
+	if p.peek.Is(token.ARROW) {
+		p.next()
+		params := p.arrowParams(exprs)
+		if params == nil {
+			return nil
+		}
+		return p.arrowExpr(params)
+	}
+	if _, ok := exprs[0].(*ast.SpreadExpression); ok || len(exprs) != 1 {
+		p.expectPeek(token.ARROW)
+		return nil
+	}
+	return exprs[0]
+}
+
+// arrowParams converts the expressions in the parentheses before an
+// arrow into parameters.
+func (p *Parser) arrowParams(exprs []ast.Expression) []*ast.Parameter {
+	var params []*ast.Parameter
+	for i, expr := range exprs {
+		if expr == nil {
+			return nil
+		}
+		param := &ast.Parameter{
+			Span: ast.Span{From: expr.Pos(), To: expr.End()},
+		}
+		switch e := expr.(type) {
+		case *ast.SpreadExpression:
+			if i != len(exprs)-1 {
+				p.errorAt(e.Token, "rest parameter must be last")
+				return nil
+			}
+			param.Token = e.Token
+			param.Rest = true
+			expr = e.Value
+		case *ast.AssignmentExpression:
+			if e.Operator == "=" {
+				param.Default = e.Value
+				expr = e.Left
+			}
+		}
+		var tok token.Token
+		switch target := assignPattern(expr).(type) {
+		case *ast.Identifier:
+			param.Name, tok = target, target.Token
+		case *ast.ArrayPattern:
+			param.Pattern, tok = target, target.Token
+		case *ast.HashPattern:
+			param.Pattern, tok = target, target.Token
+		default:
+			p.errorf("invalid arrow function parameter %s", exprs[i])
+			return nil
+		}
+		if !param.Rest {
+			param.Token = tok
+		}
+		params = append(params, param)
+	}
+	return params
+}
+
+// arrowExpr parses the body of an arrow function, the current token is
+// the =>. An expression body is returned implicitly.
+func (p *Parser) arrowExpr(params []*ast.Parameter) ast.Expression {
+	expr := &ast.FunctionLiteral{Token: p.cur, Parameters: params}
+	if p.peek.Is(token.LBRACE) {
+		p.next()
+		expr.Body = p.fnBody()
+		return expr
+	}
+	p.next()
+	start := p.cur.Pos
+	ret := &ast.ReturnStatement{Token: expr.Token}
+	ret.ReturnValue = p.expression(LOWEST)
+	p.span(ret, start)
+	expr.Body = &ast.BlockStatement{
+		Token:      expr.Token,
+		Statements: []ast.Statement{ret},
+	}
+	p.span(expr.Body, start)
 	return expr
 }
 
 func (p *Parser) identExpr() ast.Expression {
-	return &ast.Identifier{Token: p.cur, Value: p.cur.Text}
+	ident := p.ident()
+	if p.peek.Is(token.ARROW) {
+		p.next()
+		return p.arrowExpr([]*ast.Parameter{
+			{Span: ident.Span, Token: ident.Token, Name: ident},
+		})
+	}
+	return ident
+}
+
+// ident returns an identifier for the current token.
+func (p *Parser) ident() *ast.Identifier {
+	ident := &ast.Identifier{Token: p.cur, Value: p.cur.Text}
+	p.span(ident, p.cur.Pos)
+	return ident
+}
+
+// span sets the source range of node to run from start to the end of
+// the current token.
+func (p *Parser) span(node ast.Node, start token.Pos) {
+	s, ok := node.(interface{ SetSpan(from, to token.Pos) })
+	// parse functions return typed nil pointers on error
+	if ok && !reflect.ValueOf(s).IsNil() {
+		s.SetSpan(start, p.cur.End)
+	}
 }
 
 func (p *Parser) integerExpr() ast.Expression {
 	expr := &ast.IntegerLiteral{Token: p.cur}
-	v, err := strconv.ParseInt(p.cur.Text, 10, 64)
+	text := p.cur.Text
+	base := 0
+	if len(text) < 2 || !strings.ContainsAny(text[1:2], "xXoObB") {
+		// leading zeros don't make an octal literal, so decimals are
+		// parsed with base 10 which doesn't accept underscores
+		if strings.Contains(text, "__") || strings.HasSuffix(text, "_") {
+			p.errorf("invalid integer literal %s", p.cur.Text)
+			return nil
+		}
+		text = strings.ReplaceAll(text, "_", "")
+		base = 10
+	}
+	v, err := strconv.ParseInt(text, base, 64)
+	if err != nil {
+		if errors.Is(err, strconv.ErrRange) {
+			p.errorf("integer literal %s overflows int64", p.cur.Text)
+		} else {
+			p.errorf("invalid integer literal %s", p.cur.Text)
+		}
+		return nil
+	}
+	expr.Value = v
+	return expr
+}
+
+func (p *Parser) floatExpr() ast.Expression {
+	expr := &ast.FloatLiteral{Token: p.cur}
+	v, err := strconv.ParseFloat(p.cur.Text, 64)
 	if err != nil {
-		p.errorf("invalid integer %s: %v", p.cur, err)
+		if errors.Is(err, strconv.ErrRange) {
+			p.errorf("float literal %s overflows float64", p.cur.Text)
+		} else {
+			p.errorf("invalid float literal %s", p.cur.Text)
+		}
 		return nil
 	}
 	expr.Value = v
@@ -378,6 +931,29 @@ func (p *Parser) stringLit() ast.Expression {
 	}
 }
 
+func (p *Parser) templateLit() ast.Expression {
+	expr := &ast.TemplateLiteral{
+		Token:   p.cur,
+		Strings: []string{p.cur.Text},
+	}
+	for {
+		p.next()
+		expr.Values = append(expr.Values, p.expression(LOWEST))
+		switch {
+		case p.peek.Is(token.TEMPLATE_MIDDLE):
+			p.next()
+			expr.Strings = append(expr.Strings, p.cur.Text)
+		case p.peek.Is(token.TEMPLATE_TAIL):
+			p.next()
+			expr.Strings = append(expr.Strings, p.cur.Text)
+			return expr
+		default:
+			p.errorAt(p.peek, "expected end of template interpolation, got %s instead", p.peek)
+			return nil
+		}
+	}
+}
+
 func (p *Parser) prefixExpr() ast.Expression {
 	expr := &ast.PrefixExpression{
 		Token:    p.cur,
@@ -394,21 +970,75 @@ func (p *Parser) propertyExpr(left ast.Expression) ast.Expression {
 		Value: left,
 	}
 	p.next()
-	expr.Name = &ast.Identifier{Token: p.cur, Value: p.cur.Text}
+	expr.Name = p.ident()
 	return expr
 }
 
+// optionalExpr parses value?.name, value?.[index] and fn?.(args).
+func (p *Parser) optionalExpr(left ast.Expression) ast.Expression {
+	switch p.peek.Type {
+	case token.LPAREN:
+		p.next()
+		expr := p.callExpr(left).(*ast.CallExpression)
+		expr.Optional = true
+		return expr
+	case token.LBRACKET:
+		p.next()
+		switch expr := p.indexExpr(left).(type) {
+		case *ast.IndexExpression:
+			expr.Optional = true
+			return expr
+		case *ast.SliceExpression:
+			expr.Optional = true
+			return expr
+		default:
+			return nil
+		}
+	default:
+		expr := &ast.PropertyExpression{
+			Token:    p.cur,
+			Value:    left,
+			Optional: true,
+		}
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		expr.Name = p.ident()
+		return expr
+	}
+}
+
 func (p *Parser) indexExpr(left ast.Expression) ast.Expression {
-	expr := &ast.IndexExpression{
-		Token: p.cur,
-		Value: left,
+	tok := p.cur
+	var index ast.Expression
+	if !p.peek.Is(token.COLON) {
+		p.next()
+		index = p.expression(LOWEST)
+	}
+	if p.peek.Is(token.COLON) {
+		p.next()
+		expr := &ast.SliceExpression{
+			Token: tok,
+			Value: left,
+			Low:   index,
+		}
+		if !p.peek.Is(token.RBRACKET) {
+			p.next()
+			expr.High = p.expression(LOWEST)
+		}
+		if !p.expectPeek(token.RBRACKET) {
+			return nil
+		}
+		return expr
 	}
-	p.next()
-	expr.Index = p.expression(PREFIX)
 	if !p.expectPeek(token.RBRACKET) {
 		return nil
 	}
-	return expr
+	return &ast.IndexExpression{
+		Token: tok,
+		Value: left,
+		Index: index,
+	}
 }
 
 func (p *Parser) infixExpr(left ast.Expression) ast.Expression {
@@ -418,11 +1048,41 @@ func (p *Parser) infixExpr(left ast.Expression) ast.Expression {
 		Operator: p.cur.Text,
 	}
 	precedence := p.precedence(p.cur)
+	// exponentiation is right associative
+	if p.cur.Is(token.POWER) {
+		precedence--
+	}
 	p.next()
 	expr.Right = p.expression(precedence)
 	return expr
 }
 
+func (p *Parser) conditionalExpr(left ast.Expression) ast.Expression {
+	expr := &ast.ConditionalExpression{
+		Token:     p.cur,
+		Condition: left,
+	}
+	p.next()
+	expr.Consequence = p.expression(LOWEST)
+	if !p.expectPeek(token.COLON) {
+		return nil
+	}
+	p.next()
+	// conditional expressions are right associative
+	expr.Alternative = p.expression(CONDITIONAL - 1)
+	return expr
+}
+
+func (p *Parser) pipelineExpr(left ast.Expression) ast.Expression {
+	expr := &ast.PipelineExpression{
+		Token: p.cur,
+		Left:  left,
+	}
+	p.next()
+	expr.Right = p.expression(PIPELINE)
+	return expr
+}
+
 func (p *Parser) delimitedExpr(term token.TokenType) []ast.Expression {
 	var args []ast.Expression
 
@@ -437,12 +1097,24 @@ func (p *Parser) delimitedExpr(term token.TokenType) []ast.Expression {
 			}
 		}
 		p.next()
+		if p.cur.Is(token.ELLIPSIS) {
+			args = append(args, p.spreadExpr())
+			continue
+		}
 		args = append(args, p.expression(LOWEST))
 	}
 
 	return args
 }
 
+func (p *Parser) spreadExpr() ast.Expression {
+	expr := &ast.SpreadExpression{Token: p.cur}
+	p.next()
+	expr.Value = p.expression(LOWEST)
+	p.span(expr, expr.Token.Pos)
+	return expr
+}
+
 func (p *Parser) arrayExpr() ast.Expression {
 	expr := &ast.ArrayLiteral{Token: p.cur}
 	expr.Elements = p.delimitedExpr(token.RBRACKET)
@@ -459,6 +1131,13 @@ func (p *Parser) callExpr(left ast.Expression) ast.Expression {
 }
 
 func (p *Parser) stmt() ast.Statement {
+	start := p.cur.Pos
+	stmt := p.statement()
+	p.span(stmt, start)
+	return stmt
+}
+
+func (p *Parser) statement() ast.Statement {
 	switch p.cur.Type {
 	case token.FUNCTION:
 		return p.functionStmt()
@@ -471,11 +1150,26 @@ func (p *Parser) stmt() ast.Statement {
 	case token.PACKAGE:
 		return p.packageStmt()
 	case token.WHILE:
-		return p.whileStmt()
+		return p.whileStmt("")
+	case token.FOR:
+		return p.forStmt("")
+	case token.BREAK, token.CONTINUE:
+		return p.branchStmt()
 	case token.DEBUGGER:
 		return p.debuggerStmt()
 	case token.SWITCH:
 		return p.switchStmt()
+	case token.TRY:
+		return p.tryStmt()
+	case token.THROW:
+		return p.throwStmt()
+	case token.DEFER:
+		return p.deferStmt()
+	case token.IDENT:
+		if p.peek.Is(token.COLON) {
+			return p.labeledStmt()
+		}
+		return p.expressionStmt()
 	default:
 		return p.expressionStmt()
 	}
@@ -508,14 +1202,62 @@ func (p *Parser) returnStmt() *ast.ReturnStatement {
 
 func (p *Parser) assignExpr(left ast.Expression) ast.Expression {
 	expr := &ast.AssignmentExpression{
-		Token: p.cur,
-		Left:  left,
+		Token:    p.cur,
+		Operator: p.cur.Text,
+		Left:     left,
+	}
+	if isOptional(left) {
+		p.errorf("cannot assign to optional chain %s", left)
+		return nil
+	}
+	if expr.Operator == "=" {
+		expr.Left = assignPattern(left)
 	}
 	p.next()
 	expr.Value = p.expression(LOWEST)
 	return expr
 }
 
+func isOptional(expr ast.Expression) bool {
+	switch expr := expr.(type) {
+	case *ast.PropertyExpression:
+		return expr.Optional
+	case *ast.IndexExpression:
+		return expr.Optional
+	default:
+		return false
+	}
+}
+
+// assignPattern converts an array or hash literal on the left of an
+// assignment into a pattern. Other targets are checked when the
+// assignment is evaluated.
+func assignPattern(expr ast.Expression) ast.Expression {
+	switch expr := expr.(type) {
+	case *ast.ArrayLiteral:
+		pattern := &ast.ArrayPattern{Span: expr.Span, Token: expr.Token}
+		for i, e := range expr.Elements {
+			if s, ok := e.(*ast.SpreadExpression); ok && i == len(expr.Elements)-1 {
+				pattern.Rest = assignPattern(s.Value)
+				break
+			}
+			pattern.Elements = append(pattern.Elements, assignPattern(e))
+		}
+		return pattern
+	case *ast.HashLiteral:
+		pattern := &ast.HashPattern{Span: expr.Span, Token: expr.Token}
+		for _, pair := range expr.Pairs {
+			pattern.Pairs = append(pattern.Pairs, &ast.HashPair{
+				Key:   pair.Key,
+				Value: assignPattern(pair.Value),
+			})
+		}
+		return pattern
+	default:
+		return expr
+	}
+}
+
 func (p *Parser) importStmt() *ast.ImportStatement {
 	stmt := &ast.ImportStatement{Token: p.cur}
 	if !p.expectPeek(token.STRING) {
@@ -531,29 +1273,30 @@ func (p *Parser) packageStmt() *ast.PackageStatement {
 	if !p.expectPeek(token.IDENT) {
 		return nil
 	}
-	stmt.Name = &ast.Identifier{Token: p.cur, Value: p.cur.Text}
+	stmt.Name = p.ident()
 	p.semicolon()
 	return stmt
 }
 
 func (p *Parser) letStmt() *ast.LetStatement {
 	stmt := &ast.LetStatement{Token: p.cur}
-	if !p.expectPeek(token.IDENT) {
+	if p.peek.Is(token.LBRACKET) || p.peek.Is(token.LBRACE) {
+		p.next()
+		stmt.Pattern = p.pattern()
+		if stmt.Pattern == nil {
+			return nil
+		}
+	} else if !p.expectPeek(token.IDENT) {
 		return nil
+	} else {
+		stmt.Name = p.ident()
 	}
-	stmt.Name = &ast.Identifier{
-		Token: p.cur,
-		Value: p.cur.Text,
-	}
-	if p.peek.Is(token.COLON) {
+	if stmt.Name != nil && p.peek.Is(token.COLON) {
 		p.next()
 		if !p.expectPeek(token.IDENT) {
 			return nil
 		}
-		stmt.Type = &ast.Identifier{
-			Token: p.cur,
-			Value: p.cur.Text,
-		}
+		stmt.Type = p.ident()
 	}
 	if !p.expectPeek(token.ASSIGN) {
 		return nil
@@ -567,10 +1310,17 @@ func (p *Parser) letStmt() *ast.LetStatement {
 func (p *Parser) expression(precedence int) ast.Expression {
 	prefix, ok := p.prefixFns[p.cur.Type]
 	if !ok {
-		p.errorf("no prefix parse function for: %s", p.cur)
+		// the lexer has already reported illegal characters
+		if p.cur.Is(token.ILLEGAL) {
+			p.bad = true
+		} else {
+			p.errorf("no prefix parse function for: %s", p.cur)
+		}
 		return nil
 	}
+	start := p.cur.Pos
 	left := prefix()
+	p.span(left, start)
 
 	for !p.peek.Is(token.SEMICOLON) && precedence < p.precedence(p.peek) {
 		infix, ok := p.infixFns[p.peek.Type]
@@ -579,6 +1329,7 @@ func (p *Parser) expression(precedence int) ast.Expression {
 		}
 		p.next()
 		left = infix(left)
+		p.span(left, start)
 	}
 
 	return left
@@ -589,11 +1340,25 @@ func (p *Parser) expectPeek(t token.TokenType) bool {
 		p.next()
 		return true
 	}
-	p.errorf("expected %s, got %s instead", t, p.peek)
+	p.errorAt(p.peek, "expected %s, got %s instead", t, p.peek)
 	return false
 }
 
 func (p *Parser) errorf(format string, args ...interface{}) {
-	err := fmt.Sprintf(format, args...)
-	p.errors = append(p.errors, fmt.Sprintf("%s: %s", p.cur.Pos, err))
+	p.errorAt(p.cur, format, args...)
+}
+
+// errorAt reports an error at the position of tok.
+func (p *Parser) errorAt(tok token.Token, format string, args ...interface{}) {
+	if len(p.errors) == 0 && tok.Is(token.EOF) {
+		p.eof = true
+	}
+	// errors found before the parser has synced to the next statement,
+	// or at the same token as the last one, are usually caused by it
+	n := len(p.errors)
+	cascade := p.bad || n > 0 && p.errors[n-1].Pos == tok.Pos
+	p.bad = true
+	if !cascade && n < MaxErrors {
+		p.errors.Add(tok.Pos, fmt.Sprintf(format, args...))
+	}
 }
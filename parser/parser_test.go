@@ -1,7 +1,9 @@
 package parser
 
 import (
+	"errors"
 	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
@@ -9,6 +11,7 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"github.com/icholy/monkey/ast"
+	"github.com/icholy/monkey/lexer"
 	"github.com/icholy/monkey/token"
 )
 
@@ -366,6 +369,251 @@ func TestMonkey(t *testing.T) {
 		})
 	})
 
+	t.Run("integer literals", func(t *testing.T) {
+		tests := []struct {
+			input string
+			value int64
+		}{
+			{"0xFF", 255},
+			{"0o755", 493},
+			{"0b1010", 10},
+			{"1_000_000", 1000000},
+			{"0755", 755},
+			{"0_1", 1},
+			{"0_0", 0},
+			{"9223372036854775807", 9223372036854775807},
+		}
+		for _, tt := range tests {
+			t.Run(tt.input, func(t *testing.T) {
+				RequireEqualAST(t, tt.input, &ast.Program{
+					Statements: []ast.Statement{
+						&ast.ExpressionStatement{
+							Token: token.New(token.INT, tt.input),
+							Expression: &ast.IntegerLiteral{
+								Token: token.New(token.INT, tt.input),
+								Value: tt.value,
+							},
+						},
+					},
+				})
+			})
+		}
+	})
+
+	t.Run("invalid integer literals", func(t *testing.T) {
+		_, err := Parse("let x = 9223372036854775808")
+		require.EqualError(t, err, "1:9: integer literal 9223372036854775808 overflows int64")
+		_, err = Parse("1__0")
+		require.EqualError(t, err, "1:1: invalid integer literal 1__0")
+		_, err = Parse("0_")
+		require.EqualError(t, err, "1:1: invalid integer literal 0_")
+		_, err = Parse("0b102")
+		require.EqualError(t, err, "1:1: invalid integer literal 0b102")
+	})
+
+	t.Run("unterminated string", func(t *testing.T) {
+		_, err := Parse(`let x = "foo;`)
+		require.EqualError(t, err, "1:9: unterminated string literal")
+	})
+
+	t.Run("invalid float literals", func(t *testing.T) {
+		_, err := Parse("1e+")
+		require.EqualError(t, err, "1:1: invalid float literal 1e+: exponent has no digits")
+		require.True(t, errors.Is(err, lexer.ErrInvalidFloat))
+		_, err = Parse("let x = 1e")
+		require.EqualError(t, err, "1:9: invalid float literal 1e: exponent has no digits")
+		_, err = Parse("1e400")
+		require.EqualError(t, err, "1:1: float literal 1e400 overflows float64")
+		_, err = Parse("1_.5")
+		require.EqualError(t, err, "1:1: invalid float literal 1_.5")
+	})
+
+	t.Run("illegal character", func(t *testing.T) {
+		_, err := Parse("a @ b")
+		require.EqualError(t, err, "1:3: illegal character '@'")
+		require.True(t, errors.Is(err, lexer.ErrIllegalCharacter))
+	})
+
+	t.Run("multiple errors", func(t *testing.T) {
+		input := `
+			let = 1;
+			let ok = 2;
+			let y = ;
+			let f = fn() {
+				let = 3
+				ok
+			};
+			let z = 1 +;
+		`
+		_, err := Parse(input)
+		var list ErrorList
+		require.True(t, errors.As(err, &list))
+		var msgs []string
+		for _, e := range list {
+			msgs = append(msgs, e.Error())
+		}
+		require.Equal(t, []string{
+			`2:8: expected IDENT, got ASSIGN("=") instead`,
+			`4:12: no prefix parse function for: SEMICOLON(";")`,
+			`6:9: expected IDENT, got ASSIGN("=") instead`,
+			`9:15: no prefix parse function for: SEMICOLON(";")`,
+		}, msgs)
+		require.EqualError(t, err, `2:8: expected IDENT, got ASSIGN("=") instead (and 3 more errors)`)
+	})
+
+	t.Run("multiple errors on one line", func(t *testing.T) {
+		tests := []struct {
+			input    string
+			messages []string
+		}{
+			{"let = 1; let x = ;", []string{
+				`1:5: expected IDENT, got ASSIGN("=") instead`,
+				`1:18: no prefix parse function for: SEMICOLON(";")`,
+			}},
+			{"if (x) { let = {1} } let = 2", []string{
+				`1:14: expected IDENT, got ASSIGN("=") instead`,
+				`1:26: expected IDENT, got ASSIGN("=") instead`,
+			}},
+			{"fn() { 1 + }", []string{
+				`1:12: no prefix parse function for: RBRACE("}")`,
+			}},
+		}
+		for _, tt := range tests {
+			p := New(lexer.New(tt.input))
+			p.ParseProgram()
+			var msgs []string
+			for _, e := range p.Errors() {
+				msgs = append(msgs, e.Error())
+			}
+			require.Equal(t, tt.messages, msgs, tt.input)
+		}
+	})
+
+	t.Run("lexer errors first", func(t *testing.T) {
+		p := New(lexer.New("let = 1;\nlet x = @;"))
+		p.ParseProgram()
+		errs := p.Errors()
+		require.Len(t, errs, 2)
+		require.EqualError(t, errs[0], "2:9: illegal character '@'")
+		require.True(t, errors.Is(errs[0], lexer.ErrIllegalCharacter))
+		require.EqualError(t, errs[1], `1:5: expected IDENT, got ASSIGN("=") instead`)
+	})
+
+	t.Run("max errors", func(t *testing.T) {
+		input := strings.Repeat("let = 1;\n", MaxErrors*2)
+		p := New(lexer.New(input))
+		p.ParseProgram()
+		require.Len(t, p.Errors(), MaxErrors)
+	})
+
+	t.Run("incomplete input", func(t *testing.T) {
+		tests := []struct {
+			input      string
+			incomplete bool
+		}{
+			{"let x = ", true},
+			{"fn(x) {", true},
+			{"if (x", true},
+			{"foo(1,", true},
+			{`"abc`, true},
+			{"/* abc", true},
+			{"let x = 1", false},
+			{"let x = )", false},
+			{"fn(x) { x }", false},
+		}
+		for _, tt := range tests {
+			p := New(lexer.New(tt.input))
+			p.ParseProgram()
+			require.Equal(t, tt.incomplete, p.Incomplete(), tt.input)
+		}
+	})
+
+	t.Run("reader", func(t *testing.T) {
+		program, err := ParseReader(strings.NewReader("let x = 1; x + 2"))
+		require.NoError(t, err)
+		require.Equal(t, "let x = 1;(x + 2)", program.String())
+	})
+
+	t.Run("spans", func(t *testing.T) {
+		program, err := Parse("let x = (1 + y) * 2;\nfunction f(a: int) { a.b[0] }")
+		require.NoError(t, err)
+		var spans []string
+		ast.Inspect(program, func(n ast.Node) bool {
+			if n != nil {
+				spans = append(spans, fmt.Sprintf("%s-%s %T", n.Pos(), n.End(), n))
+			}
+			return true
+		})
+		require.Equal(t, []string{
+			"1:1-2:30 *ast.Program",
+			"1:1-1:21 *ast.LetStatement",
+			"1:5-1:6 *ast.Identifier",
+			"1:9-1:20 *ast.InfixExpression",
+			"1:9-1:16 *ast.InfixExpression",
+			"1:10-1:11 *ast.IntegerLiteral",
+			"1:14-1:15 *ast.Identifier",
+			"1:19-1:20 *ast.IntegerLiteral",
+			"2:1-2:30 *ast.FunctionStatement",
+			"2:10-2:11 *ast.Identifier",
+			"2:12-2:18 *ast.Parameter",
+			"2:12-2:13 *ast.Identifier",
+			"2:15-2:18 *ast.Identifier",
+			"2:20-2:30 *ast.BlockStatement",
+			"2:22-2:28 *ast.ExpressionStatement",
+			"2:22-2:28 *ast.IndexExpression",
+			"2:22-2:25 *ast.PropertyExpression",
+			"2:22-2:23 *ast.Identifier",
+			"2:24-2:25 *ast.Identifier",
+			"2:26-2:27 *ast.IntegerLiteral",
+		}, spans)
+		require.Equal(t, 50, program.End().Byte)
+	})
+
+	t.Run("empty program", func(t *testing.T) {
+		for _, input := range []string{"", "  ", "\n\t\n"} {
+			RequireEqualAST(t, input, &ast.Program{})
+		}
+		program, err := Parse("// nothing\n")
+		require.NoError(t, err)
+		require.Empty(t, program.Statements)
+		require.Len(t, program.Comments, 1)
+		require.Equal(t, "// nothing", program.Comments[0].Text)
+	})
+
+	t.Run("comments", func(t *testing.T) {
+		program, err := Parse("// a\nlet x = 1; /* b */\nx // c\n")
+		require.NoError(t, err)
+		var comments []string
+		for _, c := range program.Comments {
+			comments = append(comments, fmt.Sprintf("%s-%s %s", c.Pos(), c.End(), c.Text))
+		}
+		require.Equal(t, []string{"1:1-1:5 // a", "2:12-2:19 /* b */", "3:3-3:7 // c"}, comments)
+		require.Len(t, program.Statements, 2)
+	})
+
+	t.Run("float literal", func(t *testing.T) {
+		input := "1.5 * 2"
+		RequireEqualAST(t, input, &ast.Program{
+			Statements: []ast.Statement{
+				&ast.ExpressionStatement{
+					Token: token.New(token.FLOAT, "1.5"),
+					Expression: &ast.InfixExpression{
+						Token:    token.New(token.ASTERISK, "*"),
+						Operator: "*",
+						Left: &ast.FloatLiteral{
+							Token: token.New(token.FLOAT, "1.5"),
+							Value: 1.5,
+						},
+						Right: &ast.IntegerLiteral{
+							Token: token.New(token.INT, "2"),
+							Value: 2,
+						},
+					},
+				},
+			},
+		})
+	})
+
 	t.Run("strings", func(t *testing.T) {
 		input := `"hello" + "world"`
 		RequireEqualAST(t, input, &ast.Program{
@@ -389,6 +637,28 @@ func TestMonkey(t *testing.T) {
 		})
 	})
 
+	t.Run("template literal", func(t *testing.T) {
+		input := `"x=${x}!"`
+		RequireEqualAST(t, input, &ast.Program{
+			Statements: []ast.Statement{
+				&ast.ExpressionStatement{
+					Token: token.New(token.TEMPLATE_HEAD, "x="),
+					Expression: &ast.TemplateLiteral{
+						Token: token.New(token.TEMPLATE_HEAD, "x="),
+						Values: []ast.Expression{
+							&ast.Identifier{
+								Token: token.New(token.IDENT, "x"),
+								Value: "x",
+							},
+						},
+						Strings: []string{"x=", "!"},
+					},
+				},
+			},
+		})
+		RequireEqualString(t, `"a${1 + 2}b${c}"`, `"a${(1 + 2)}b${c}"`)
+	})
+
 	t.Run("arrays", func(t *testing.T) {
 		input := `["test", 1, hello]`
 		RequireEqualAST(t, input, &ast.Program{
@@ -439,6 +709,32 @@ func TestMonkey(t *testing.T) {
 		})
 	})
 
+	t.Run("slice", func(t *testing.T) {
+		RequireEqualAST(t, "foo[:n]", &ast.Program{
+			Statements: []ast.Statement{
+				&ast.ExpressionStatement{
+					Token: token.New(token.IDENT, "foo"),
+					Expression: &ast.SliceExpression{
+						Token: token.New(token.LBRACKET, "["),
+						Value: &ast.Identifier{
+							Token: token.New(token.IDENT, "foo"),
+							Value: "foo",
+						},
+						High: &ast.Identifier{
+							Token: token.New(token.IDENT, "n"),
+							Value: "n",
+						},
+					},
+				},
+			},
+		})
+		RequireEqualString(t, "xs[1:3]", "xs[1:3]")
+		RequireEqualString(t, "xs[i + 1:]", "xs[(i + 1):]")
+		RequireEqualString(t, "xs[:]", "xs[:]")
+		RequireEqualString(t, "xs[-1]", "xs[(-1)]")
+		RequireEqualString(t, "xs[i + 1]", "xs[(i + 1)]")
+	})
+
 	t.Run("empty hash", func(t *testing.T) {
 		input := `{}`
 		RequireEqualAST(t, input, &ast.Program{
@@ -618,7 +914,8 @@ func TestMonkey(t *testing.T) {
 				&ast.ExpressionStatement{
 					Token: token.New(token.IDENT, "foo"),
 					Expression: &ast.AssignmentExpression{
-						Token: token.New(token.ASSIGN, "="),
+						Token:    token.New(token.ASSIGN, "="),
+						Operator: "=",
 						Left: &ast.Identifier{
 							Token: token.New(token.IDENT, "foo"),
 							Value: "foo",
@@ -637,7 +934,8 @@ func TestMonkey(t *testing.T) {
 				&ast.ExpressionStatement{
 					Token: token.New(token.IDENT, "foo"),
 					Expression: &ast.AssignmentExpression{
-						Token: token.New(token.ASSIGN, "="),
+						Token:    token.New(token.ASSIGN, "="),
+						Operator: "=",
 						Left: &ast.IndexExpression{
 							Token: token.New(token.LBRACKET, "["),
 							Value: &ast.Identifier{
@@ -663,6 +961,33 @@ func TestMonkey(t *testing.T) {
 		})
 	})
 
+	t.Run("compound assignment", func(t *testing.T) {
+		RequireEqualString(t, "x += 1", "x += 1")
+		RequireEqualString(t, "x %= y * 2", "x %= (y * 2)")
+		RequireEqualString(t, "a[0] -= 1", "a[0] -= 1")
+	})
+
+	t.Run("arithmetic and bitwise operators", func(t *testing.T) {
+		tests := []struct {
+			input, expected string
+		}{
+			{"a % b * c", "((a % b) * c)"},
+			{"a + b % c", "(a + (b % c))"},
+			{"a ** b ** c", "(a ** (b ** c))"},
+			{"a * b ** c", "(a * (b ** c))"},
+			{"-a ** b", "(-(a ** b))"},
+			{"a ** -b", "(a ** (-b))"},
+			{"a | b & c", "(a | (b & c))"},
+			{"a ^ b << 2", "(a ^ (b << 2))"},
+			{"a >> 1 + b", "((a >> 1) + b)"},
+			{"a & b == c", "((a & b) == c)"},
+			{"~a & b", "((~a) & b)"},
+		}
+		for _, tt := range tests {
+			RequireEqualString(t, tt.input, tt.expected)
+		}
+	})
+
 	t.Run("while loop", func(t *testing.T) {
 		RequireEqualAST(t, "while 1 >= x {}", &ast.Program{
 			Statements: []ast.Statement{
@@ -688,6 +1013,224 @@ func TestMonkey(t *testing.T) {
 		})
 	})
 
+	t.Run("for loop", func(t *testing.T) {
+		RequireEqualAST(t, "for x in xs {}", &ast.Program{
+			Statements: []ast.Statement{
+				&ast.ForStatement{
+					Token: token.New(token.FOR, "for"),
+					Value: &ast.Identifier{
+						Token: token.New(token.IDENT, "x"),
+						Value: "x",
+					},
+					Iterable: &ast.Identifier{
+						Token: token.New(token.IDENT, "xs"),
+						Value: "xs",
+					},
+					Body: &ast.BlockStatement{
+						Token: token.New(token.LBRACE, "{"),
+					},
+				},
+			},
+		})
+		RequireEqualString(t, "for k, v in {} { k }", "for k, v in {  } { k; }")
+		RequireEqualString(t, "for i in n + 1 { i; }", "for i in (n + 1) { i; }")
+	})
+
+	t.Run("break and continue", func(t *testing.T) {
+		RequireEqualAST(t, "outer: while x { break outer }", &ast.Program{
+			Statements: []ast.Statement{
+				&ast.LabeledStatement{
+					Token: token.New(token.IDENT, "outer"),
+					Label: &ast.Identifier{
+						Token: token.New(token.IDENT, "outer"),
+						Value: "outer",
+					},
+					Statement: &ast.WhileStatement{
+						Token: token.New(token.WHILE, "while"),
+						Condition: &ast.Identifier{
+							Token: token.New(token.IDENT, "x"),
+							Value: "x",
+						},
+						Body: &ast.BlockStatement{
+							Token: token.New(token.LBRACE, "{"),
+							Statements: []ast.Statement{
+								&ast.BranchStatement{
+									Token: token.New(token.BREAK, "break"),
+									Label: &ast.Identifier{
+										Token: token.New(token.IDENT, "outer"),
+										Value: "outer",
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		})
+		RequireEqualString(t, "for x in xs { continue; }", "for x in xs { continue; }")
+		RequireEqualString(t, "a: for x in xs { while y { continue a } }", "a: for x in xs { while (y) { continue a; }; }")
+		RequireEqualString(t, "while x { break\ny }", "while (x) { break; y; }")
+	})
+
+	t.Run("invalid branches", func(t *testing.T) {
+		tests := []struct {
+			input   string
+			message string
+		}{
+			{"break", "1:1: break outside loop"},
+			{"if x { continue }", "1:8: continue outside loop"},
+			{"while x { fn() { break } }", "1:18: break outside loop"},
+			{"a: while x {}; while y { break a }", "1:32: undefined loop label: a"},
+			{"a: let x = 1", `1:4: expected loop after label a, got LET("let") instead`},
+		}
+		for _, tt := range tests {
+			_, err := Parse(tt.input)
+			require.EqualError(t, err, tt.message)
+		}
+	})
+
+	t.Run("destructuring", func(t *testing.T) {
+		RequireEqualAST(t, "let [a, ...b] = c", &ast.Program{
+			Statements: []ast.Statement{
+				&ast.LetStatement{
+					Token: token.New(token.LET, "let"),
+					Pattern: &ast.ArrayPattern{
+						Token: token.New(token.LBRACKET, "["),
+						Elements: []ast.Expression{
+							&ast.Identifier{
+								Token: token.New(token.IDENT, "a"),
+								Value: "a",
+							},
+						},
+						Rest: &ast.Identifier{
+							Token: token.New(token.IDENT, "b"),
+							Value: "b",
+						},
+					},
+					Value: &ast.Identifier{
+						Token: token.New(token.IDENT, "c"),
+						Value: "c",
+					},
+				},
+			},
+		})
+		RequireEqualAST(t, "let {a} = b", &ast.Program{
+			Statements: []ast.Statement{
+				&ast.LetStatement{
+					Token: token.New(token.LET, "let"),
+					Pattern: &ast.HashPattern{
+						Token: token.New(token.LBRACE, "{"),
+						Pairs: []*ast.HashPair{
+							{
+								Key: &ast.Identifier{
+									Token: token.New(token.IDENT, "a"),
+									Value: "a",
+								},
+								Value: &ast.Identifier{
+									Token: token.New(token.IDENT, "a"),
+									Value: "a",
+								},
+							},
+						},
+					},
+					Value: &ast.Identifier{
+						Token: token.New(token.IDENT, "b"),
+						Value: "b",
+					},
+				},
+			},
+		})
+		RequireEqualString(t, `let {name, "age": [a, b,], x: {y}} = p`, `let { name: name, "age": [a, b], x: { y: y } } = p;`)
+		RequireEqualString(t, "fn([x, y], {z}, w: int) { x }", "fn([x, y], { z: z }, w) { x; }")
+		RequireEqualString(t, "[a, b] = [b, a]", "[a, b] = [b, a]")
+		RequireEqualString(t, `{"k": x.y} = h`, `{ "k": x.y } = h`)
+	})
+
+	t.Run("default and rest parameters", func(t *testing.T) {
+		RequireEqualAST(t, "fn(a = 1, ...b) {}", &ast.Program{
+			Statements: []ast.Statement{
+				&ast.ExpressionStatement{
+					Token: token.New(token.FN, "fn"),
+					Expression: &ast.FunctionLiteral{
+						Token: token.New(token.FN, "fn"),
+						Parameters: []*ast.Parameter{
+							{
+								Token: token.New(token.IDENT, "a"),
+								Name: &ast.Identifier{
+									Token: token.New(token.IDENT, "a"),
+									Value: "a",
+								},
+								Default: &ast.IntegerLiteral{
+									Token: token.New(token.INT, "1"),
+									Value: 1,
+								},
+							},
+							{
+								Token: token.New(token.ELLIPSIS, "..."),
+								Name: &ast.Identifier{
+									Token: token.New(token.IDENT, "b"),
+									Value: "b",
+								},
+								Rest: true,
+							},
+						},
+						Body: &ast.BlockStatement{
+							Token: token.New(token.LBRACE, "{"),
+						},
+					},
+				},
+			},
+		})
+		RequireEqualString(t, "function f(a: int = 1, [b] = c, ...d) { d }", "function f(a, [b], d) d; ")
+	})
+
+	t.Run("spread", func(t *testing.T) {
+		RequireEqualAST(t, "f(...xs)", &ast.Program{
+			Statements: []ast.Statement{
+				&ast.ExpressionStatement{
+					Token: token.New(token.IDENT, "f"),
+					Expression: &ast.CallExpression{
+						Token: token.New(token.LPAREN, "("),
+						Function: &ast.Identifier{
+							Token: token.New(token.IDENT, "f"),
+							Value: "f",
+						},
+						Arguments: []ast.Expression{
+							&ast.SpreadExpression{
+								Token: token.New(token.ELLIPSIS, "..."),
+								Value: &ast.Identifier{
+									Token: token.New(token.IDENT, "xs"),
+									Value: "xs",
+								},
+							},
+						},
+					},
+				},
+			},
+		})
+		RequireEqualString(t, "[...a, 1, ...b + c]", "[...a, 1, ...(b + c)]")
+		RequireEqualString(t, "[a, ...b] = c", "[a, ...b] = c")
+	})
+
+	t.Run("invalid destructuring", func(t *testing.T) {
+		tests := []struct {
+			input   string
+			message string
+		}{
+			{"let [1] = x", `1:6: expected pattern, got INT("1") instead`},
+			{"let [...a, b] = x", `1:10: expected RBRACKET, got COMMA(",") instead`},
+			{"let {1: a} = x", `1:6: expected hash pattern key, got INT("1") instead`},
+			{"let [a]: int = x", `1:8: expected ASSIGN, got COLON(":") instead`},
+			{"fn(...a, b) {}", "1:8: rest parameter must be last"},
+			{"fn(...a = 1) {}", `1:9: expected RPAREN, got ASSIGN("=") instead`},
+			{"...a", `1:1: no prefix parse function for: ELLIPSIS("...")`},
+		}
+		for _, tt := range tests {
+			_, err := Parse(tt.input)
+			require.EqualError(t, err, tt.message)
+		}
+	})
+
 	t.Run("property access", func(t *testing.T) {
 		RequireEqualAST(t, "foo.bar", &ast.Program{
 			Statements: []ast.Statement{
@@ -709,6 +1252,225 @@ func TestMonkey(t *testing.T) {
 		})
 	})
 
+	t.Run("optional chaining", func(t *testing.T) {
+		RequireEqualAST(t, "a?.b", &ast.Program{
+			Statements: []ast.Statement{
+				&ast.ExpressionStatement{
+					Token: token.New(token.IDENT, "a"),
+					Expression: &ast.PropertyExpression{
+						Token: token.New(token.QUESTION_DOT, "?."),
+						Value: &ast.Identifier{
+							Token: token.New(token.IDENT, "a"),
+							Value: "a",
+						},
+						Name: &ast.Identifier{
+							Token: token.New(token.IDENT, "b"),
+							Value: "b",
+						},
+						Optional: true,
+					},
+				},
+			},
+		})
+		RequireEqualString(t, "a?.b?.c", "a?.b?.c")
+		RequireEqualString(t, "a?.[k]?.(1, 2)", "a?.[k]?.(1, 2)")
+		RequireEqualString(t, "a?.[1:]", "a?.[1:]")
+		RequireEqualString(t, "a ?? b ?? c", "((a ?? b) ?? c)")
+		RequireEqualString(t, "a || b ?? c == d", "((a || b) ?? (c == d))")
+		_, err := Parse("a?.b = 1")
+		require.EqualError(t, err, "1:6: cannot assign to optional chain a?.b")
+		_, err = Parse("a?.1")
+		require.EqualError(t, err, `1:4: expected IDENT, got INT("1") instead`)
+	})
+
+	t.Run("conditional", func(t *testing.T) {
+		RequireEqualAST(t, "a ? 1 : 2", &ast.Program{
+			Statements: []ast.Statement{
+				&ast.ExpressionStatement{
+					Token: token.New(token.IDENT, "a"),
+					Expression: &ast.ConditionalExpression{
+						Token: token.New(token.QUESTION, "?"),
+						Condition: &ast.Identifier{
+							Token: token.New(token.IDENT, "a"),
+							Value: "a",
+						},
+						Consequence: &ast.IntegerLiteral{
+							Token: token.New(token.INT, "1"),
+							Value: 1,
+						},
+						Alternative: &ast.IntegerLiteral{
+							Token: token.New(token.INT, "2"),
+							Value: 2,
+						},
+					},
+				},
+			},
+		})
+		RequireEqualString(t, "a ? b : c ? d : e", "(a ? b : (c ? d : e))")
+		RequireEqualString(t, "a ? b ? c : d : e", "(a ? (b ? c : d) : e)")
+		RequireEqualString(t, "a == b ? c + 1 : d ?? e", "((a == b) ? (c + 1) : (d ?? e))")
+		RequireEqualString(t, "x = a ? b : c", "x = (a ? b : c)")
+		_, err := Parse("a ? b")
+		require.EqualError(t, err, `1:6: expected COLON, got EOF("") instead`)
+	})
+
+	t.Run("pipeline", func(t *testing.T) {
+		RequireEqualAST(t, "x |> f(1)", &ast.Program{
+			Statements: []ast.Statement{
+				&ast.ExpressionStatement{
+					Token: token.New(token.IDENT, "x"),
+					Expression: &ast.PipelineExpression{
+						Token: token.New(token.PIPELINE, "|>"),
+						Left: &ast.Identifier{
+							Token: token.New(token.IDENT, "x"),
+							Value: "x",
+						},
+						Right: &ast.CallExpression{
+							Token: token.New(token.LPAREN, "("),
+							Function: &ast.Identifier{
+								Token: token.New(token.IDENT, "f"),
+								Value: "f",
+							},
+							Arguments: []ast.Expression{
+								&ast.IntegerLiteral{
+									Token: token.New(token.INT, "1"),
+									Value: 1,
+								},
+							},
+						},
+					},
+				},
+			},
+		})
+		RequireEqualString(t, "x |> f |> g(1)", "((x |> f) |> g(1))")
+		RequireEqualString(t, "a + b |> f", "((a + b) |> f)")
+		RequireEqualString(t, "a ? b : c |> f", "((a ? b : c) |> f)")
+	})
+
+	t.Run("try", func(t *testing.T) {
+		RequireEqualAST(t, "try { x } catch (e) { throw e }", &ast.Program{
+			Statements: []ast.Statement{
+				&ast.TryStatement{
+					Token: token.New(token.TRY, "try"),
+					Block: &ast.BlockStatement{
+						Token: token.New(token.LBRACE, "{"),
+						Statements: []ast.Statement{
+							&ast.ExpressionStatement{
+								Token: token.New(token.IDENT, "x"),
+								Expression: &ast.Identifier{
+									Token: token.New(token.IDENT, "x"),
+									Value: "x",
+								},
+							},
+						},
+					},
+					Param: &ast.Identifier{
+						Token: token.New(token.IDENT, "e"),
+						Value: "e",
+					},
+					Catch: &ast.BlockStatement{
+						Token: token.New(token.LBRACE, "{"),
+						Statements: []ast.Statement{
+							&ast.ThrowStatement{
+								Token: token.New(token.THROW, "throw"),
+								Value: &ast.Identifier{
+									Token: token.New(token.IDENT, "e"),
+									Value: "e",
+								},
+							},
+						},
+					},
+				},
+			},
+		})
+		RequireEqualString(t, "try { a } finally { b }", "try { a; } finally { b; }")
+		RequireEqualString(t, "try { a } catch { b } finally { c }", "try { a; } catch { b; } finally { c; }")
+		RequireEqualString(t, "throw a + 1", "throw (a + 1)")
+		tests := []struct {
+			input   string
+			message string
+		}{
+			{"try { a }", `1:10: expected CATCH or FINALLY, got EOF("") instead`},
+			{"try { a } catch (1) {}", `1:18: expected IDENT, got INT("1") instead`},
+			{"try a", `1:5: expected LBRACE, got IDENT("a") instead`},
+		}
+		for _, tt := range tests {
+			_, err := Parse(tt.input)
+			require.EqualError(t, err, tt.message)
+		}
+	})
+
+	t.Run("defer", func(t *testing.T) {
+		RequireEqualString(t, "fn() { defer f(a, b) }", "fn() { defer f(a, b); }")
+		RequireEqualString(t, "x => { defer x?.() }", "fn(x) { defer x?.(); }")
+		tests := []struct {
+			input   string
+			message string
+		}{
+			{"defer f()", "1:1: defer outside function"},
+			{"fn() { defer f }", "1:8: expression in defer must be function call"},
+			{"fn() { defer }", "1:14: no prefix parse function for: RBRACE(\"}\")"},
+		}
+		for _, tt := range tests {
+			_, err := Parse(tt.input)
+			require.EqualError(t, err, tt.message)
+		}
+	})
+
+	t.Run("arrow function", func(t *testing.T) {
+		RequireEqualAST(t, "x => x", &ast.Program{
+			Statements: []ast.Statement{
+				&ast.ExpressionStatement{
+					Token: token.New(token.IDENT, "x"),
+					Expression: &ast.FunctionLiteral{
+						Token: token.New(token.ARROW, "=>"),
+						Parameters: []*ast.Parameter{
+							{
+								Token: token.New(token.IDENT, "x"),
+								Name: &ast.Identifier{
+									Token: token.New(token.IDENT, "x"),
+									Value: "x",
+								},
+							},
+						},
+						Body: &ast.BlockStatement{
+							Token: token.New(token.ARROW, "=>"),
+							Statements: []ast.Statement{
+								&ast.ReturnStatement{
+									Token: token.New(token.ARROW, "=>"),
+									ReturnValue: &ast.Identifier{
+										Token: token.New(token.IDENT, "x"),
+										Value: "x",
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		})
+		RequireEqualString(t, "(a, b) => a + b", "fn(a, b) { return (a + b); }")
+		RequireEqualString(t, "() => { 1 }", "fn() { 1; }")
+		RequireEqualString(t, "([a], {b: c}, d = 1, ...e) => a", "fn([a], { b: c }, d, e) { return a; }")
+		RequireEqualString(t, "x => y => x", "fn(x) { return fn(y) { return x; }; }")
+		RequireEqualString(t, "f(x => x, 1)", "f(fn(x) { return x; }, 1)")
+		RequireEqualString(t, "(a)", "a")
+		tests := []struct {
+			input   string
+			message string
+		}{
+			{"()", `1:3: expected ARROW, got EOF("") instead`},
+			{"(a, b)", `1:7: expected ARROW, got EOF("") instead`},
+			{"(...a)", `1:7: expected ARROW, got EOF("") instead`},
+			{"(a + 1) => a", "1:9: invalid arrow function parameter (a + 1)"},
+			{"(...a, b) => a", "1:2: rest parameter must be last"},
+		}
+		for _, tt := range tests {
+			_, err := Parse(tt.input)
+			require.EqualError(t, err, tt.message)
+		}
+	})
+
 	t.Run("package", func(t *testing.T) {
 		RequireEqualAST(t, "package foo", &ast.Program{
 			Statements: []ast.Statement{
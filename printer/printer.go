@@ -0,0 +1,818 @@
+package printer
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/icholy/monkey/ast"
+	"github.com/icholy/monkey/parser"
+	"github.com/icholy/monkey/token"
+)
+
+// Indent is written once for each level of nesting.
+const Indent = "  "
+
+var precedences = map[string]int{
+	"|>": parser.PIPELINE,
+	"??": parser.COALESCE,
+	"||": parser.ANDOR,
+	"&&": parser.ANDOR,
+	"==": parser.EQUALS,
+	"!=": parser.EQUALS,
+	"in": parser.EQUALS,
+	"<":  parser.LESSGREATER,
+	"<=": parser.LESSGREATER,
+	">":  parser.LESSGREATER,
+	">=": parser.LESSGREATER,
+	"+":  parser.SUM,
+	"-":  parser.SUM,
+	"|":  parser.SUM,
+	"^":  parser.SUM,
+	"*":  parser.PRODUCT,
+	"/":  parser.PRODUCT,
+	"%":  parser.PRODUCT,
+	"&":  parser.PRODUCT,
+	"<<": parser.PRODUCT,
+	">>": parser.PRODUCT,
+	"**": parser.POWER,
+}
+
+// Source parses src and returns it in canonical form. A leading
+// shebang line is kept.
+func Source(src []byte) ([]byte, error) {
+	program, err := parser.Parse(string(src))
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if bytes.HasPrefix(src, []byte("#!")) {
+		line, _, _ := bytes.Cut(src, []byte("\n"))
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	if err := Fprint(&buf, program); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Fprint writes the canonical source of node to w. The comments of a
+// program are kept.
+func Fprint(w io.Writer, node ast.Node) error {
+	var p printer
+	if program, ok := node.(*ast.Program); ok {
+		p.comments = program.Comments
+	}
+	p.node(node)
+	p.flush(token.Pos{Byte: math.MaxInt})
+	if len(p.out) > 0 {
+		p.out = append(p.out, '\n')
+	}
+	_, err := w.Write(p.out)
+	return err
+}
+
+type printer struct {
+	out    []byte
+	indent int
+	// bol is set at the beginning of a line before it's been indented
+	bol bool
+	// source line of the last thing printed
+	line     int
+	comments []*ast.Comment
+}
+
+func (p *printer) write(s string) {
+	if p.bol && s != "" {
+		p.out = append(p.out, strings.Repeat(Indent, p.indent)...)
+		p.bol = false
+	}
+	p.out = append(p.out, s...)
+}
+
+func (p *printer) printf(format string, args ...interface{}) {
+	p.write(fmt.Sprintf(format, args...))
+}
+
+func (p *printer) newline() {
+	if len(p.out) > 0 {
+		p.out = append(p.out, '\n')
+		p.bol = true
+	}
+}
+
+// linebreak starts a new line for something at the source line. A
+// single blank line is kept if there was one in the source.
+func (p *printer) linebreak(line int) {
+	p.newline()
+	if p.line > 0 && line > p.line+1 {
+		p.newline()
+	}
+}
+
+// flush prints the comments which come before pos on their own lines.
+func (p *printer) flush(pos token.Pos) {
+	for len(p.comments) > 0 && p.comments[0].Pos().Byte < pos.Byte {
+		c := p.comments[0]
+		p.comments = p.comments[1:]
+		p.linebreak(c.Pos().Line)
+		p.write(c.Text)
+		p.line = c.End().Line
+	}
+}
+
+// trailing prints the comments which start on the last printed line.
+func (p *printer) trailing() {
+	for len(p.comments) > 0 && p.comments[0].Pos().Line == p.line {
+		c := p.comments[0]
+		p.comments = p.comments[1:]
+		p.write(" " + c.Text)
+		p.line = c.End().Line
+	}
+}
+
+// opened is called after printing something which ends on the source
+// line and opens a new level of nesting. Blank lines aren't kept at the
+// start of the nested lines.
+func (p *printer) opened(line int) {
+	p.line = line
+	p.trailing()
+	p.line = 0
+}
+
+// commented reports whether there are comments before pos.
+func (p *printer) commented(pos token.Pos) bool {
+	return len(p.comments) > 0 && p.comments[0].Pos().Byte < pos.Byte
+}
+
+func (p *printer) node(node ast.Node) {
+	switch node := node.(type) {
+	case *ast.Program:
+		p.stmts(node.Statements, false)
+	case ast.Statement:
+		p.stmt(node)
+	case ast.Expression:
+		p.expr(node)
+	default:
+		p.write(node.String())
+	}
+}
+
+// stmts prints one statement per line. Statements which end in an
+// expression get a semicolon if the next statement would continue it.
+// If more is set, something other than a closing brace follows.
+func (p *printer) stmts(list []ast.Statement, more bool) {
+	// where a semicolon goes if the next statement needs one
+	semi := -1
+	// a bare return takes whatever follows as its value
+	bare := false
+	for _, s := range list {
+		p.flush(s.Pos())
+		p.linebreak(s.Pos().Line)
+		start := len(p.out)
+		p.stmt(s)
+		if semi >= 0 && (bare || continues(bytes.TrimLeft(p.out[start:], " "))) {
+			p.insert(semi, ';')
+		}
+		semi = -1
+		if open(s) {
+			semi = len(p.out)
+		}
+		r, ok := s.(*ast.ReturnStatement)
+		bare = ok && r.ReturnValue == nil
+		p.line = s.End().Line
+		p.trailing()
+	}
+	if bare && more {
+		p.insert(semi, ';')
+	}
+}
+
+func (p *printer) insert(i int, b byte) {
+	p.out = append(p.out[:i], append([]byte{b}, p.out[i:]...)...)
+}
+
+// open reports whether the statement ends in an expression.
+func open(s ast.Statement) bool {
+	switch s.(type) {
+	case *ast.ExpressionStatement, *ast.LetStatement, *ast.ReturnStatement, *ast.ThrowStatement,
+		*ast.DeferStatement:
+		return true
+	default:
+		return false
+	}
+}
+
+// simple reports whether the statement can be printed in a one line
+// block.
+func simple(s ast.Statement) bool {
+	_, ok := s.(*ast.BranchStatement)
+	return ok || open(s)
+}
+
+// continues reports whether the source could be parsed as a
+// continuation of a preceding expression.
+func continues(src []byte) bool {
+	return len(src) > 0 && strings.IndexByte("([-", src[0]) >= 0
+}
+
+func (p *printer) stmt(s ast.Statement) {
+	switch s := s.(type) {
+	case *ast.PackageStatement:
+		p.printf("package %s", s.Name.Value)
+	case *ast.ImportStatement:
+		p.write("import " + quote(s.Value))
+	case *ast.LetStatement:
+		p.write("let ")
+		if s.Pattern != nil {
+			p.pattern(s.Pattern, true)
+		} else {
+			p.write(s.Name.Value)
+		}
+		if s.Type != nil {
+			p.write(": " + s.Type.Value)
+		}
+		p.write(" = ")
+		p.expr(s.Value)
+	case *ast.ReturnStatement:
+		p.write("return")
+		if s.ReturnValue != nil {
+			p.write(" ")
+			p.expr(s.ReturnValue)
+		}
+	case *ast.ExpressionStatement:
+		p.expr(s.Expression)
+	case *ast.FunctionStatement:
+		p.write("function " + s.Name.Value)
+		p.signature(s.Parameters, s.ReturnType)
+		p.write(" ")
+		p.block(s.Body)
+	case *ast.ThrowStatement:
+		p.write("throw ")
+		p.expr(s.Value)
+	case *ast.DeferStatement:
+		p.write("defer ")
+		p.expr(s.Call)
+	case *ast.TryStatement:
+		p.write("try ")
+		p.block(s.Block)
+		if s.Catch != nil {
+			p.write(" catch ")
+			if s.Param != nil {
+				p.write("(" + s.Param.Value + ") ")
+			}
+			p.block(s.Catch)
+		}
+		if s.Finally != nil {
+			p.write(" finally ")
+			p.block(s.Finally)
+		}
+	case *ast.WhileStatement:
+		p.write("while ")
+		p.expr(s.Condition)
+		p.write(" ")
+		p.block(s.Body)
+	case *ast.ForStatement:
+		p.write("for ")
+		if s.Key != nil {
+			p.write(s.Key.Value + ", ")
+		}
+		p.write(s.Value.Value + " in ")
+		p.expr(s.Iterable)
+		p.write(" ")
+		p.block(s.Body)
+	case *ast.LabeledStatement:
+		p.write(s.Label.Value + ": ")
+		p.stmt(s.Statement)
+	case *ast.BranchStatement:
+		p.write(s.Token.Text)
+		if s.Label != nil {
+			p.write(" " + s.Label.Value)
+		}
+	case *ast.SwitchStatement:
+		p.switchStmt(s)
+	case *ast.BlockStatement:
+		p.block(s)
+	case *ast.DebuggerStatement:
+		p.write("debugger")
+	default:
+		p.write(s.String())
+	}
+}
+
+func (p *printer) switchStmt(s *ast.SwitchStatement) {
+	p.write("switch ")
+	p.expr(s.Value)
+	p.write(" {")
+	p.opened(s.Value.End().Line)
+	for i, c := range s.Cases {
+		p.flush(c.Pos())
+		p.linebreak(c.Pos().Line)
+		p.write("case ")
+		p.expr(c.Value)
+		p.write(":")
+		p.opened(c.Value.End().Line)
+		p.indent++
+		p.stmts(c.Statements, i < len(s.Cases)-1 || len(s.Default) > 0)
+		p.indent--
+	}
+	if len(s.Default) > 0 {
+		p.newline()
+		p.write("default:")
+		p.line = 0
+		p.indent++
+		p.stmts(s.Default, false)
+		p.indent--
+	}
+	p.flush(s.End())
+	p.newline()
+	p.write("}")
+	p.line = s.End().Line
+}
+
+// block prints a block on a single line if it was written on one and
+// only contains a simple statement.
+func (p *printer) block(b *ast.BlockStatement) {
+	if p.commented(b.End()) {
+		p.blockLines(b)
+		return
+	}
+	switch len(b.Statements) {
+	case 0:
+		p.write("{}")
+		return
+	case 1:
+		if b.Pos().Line == b.End().Line && simple(b.Statements[0]) {
+			p.write("{ ")
+			p.stmt(b.Statements[0])
+			p.write(" }")
+			return
+		}
+	}
+	p.blockLines(b)
+}
+
+func (p *printer) blockLines(b *ast.BlockStatement) {
+	p.write("{")
+	p.opened(b.Pos().Line)
+	p.indent++
+	p.stmts(b.Statements, false)
+	p.flush(b.End())
+	p.indent--
+	p.newline()
+	p.write("}")
+	p.line = b.End().Line
+}
+
+func (p *printer) signature(params []*ast.Parameter, ret *ast.Identifier) {
+	p.write("(")
+	for i, param := range params {
+		if i > 0 {
+			p.write(", ")
+		}
+		if param.Rest {
+			p.write("...")
+		}
+		if param.Pattern != nil {
+			p.pattern(param.Pattern, true)
+		} else {
+			p.write(param.Name.Value)
+		}
+		if param.Type != nil {
+			p.write(": " + param.Type.Value)
+		}
+		if param.Default != nil {
+			p.write(" = ")
+			p.expr(param.Default)
+		}
+	}
+	p.write(")")
+	if ret != nil {
+		p.write(": " + ret.Value)
+	}
+}
+
+// arrow prints an arrow function. Expression bodies are parsed into an
+// implicit return with the => token.
+func (p *printer) arrow(e *ast.FunctionLiteral) {
+	if ps := e.Parameters; len(ps) == 1 && ps[0].Name != nil && ps[0].Type == nil && ps[0].Default == nil && !ps[0].Rest {
+		p.write(ps[0].Name.Value)
+	} else {
+		p.signature(e.Parameters, nil)
+	}
+	p.write(" => ")
+	if len(e.Body.Statements) == 1 {
+		if ret, ok := e.Body.Statements[0].(*ast.ReturnStatement); ok && ret.Token.Is(token.ARROW) {
+			// a body starting with { would be parsed as a block
+			if _, ok := leftmost(ret.ReturnValue).(*ast.HashLiteral); ok {
+				p.write("(")
+				p.expr(ret.ReturnValue)
+				p.write(")")
+			} else {
+				p.expr(ret.ReturnValue)
+			}
+			return
+		}
+	}
+	p.block(e.Body)
+}
+
+// leftmost returns the sub-expression printed first in e.
+func leftmost(e ast.Expression) ast.Expression {
+	for {
+		switch x := e.(type) {
+		case *ast.InfixExpression:
+			e = x.Left
+		case *ast.AssignmentExpression:
+			e = x.Left
+		case *ast.ConditionalExpression:
+			e = x.Condition
+		case *ast.PipelineExpression:
+			e = x.Left
+		case *ast.CallExpression:
+			e = x.Function
+		case *ast.IndexExpression:
+			e = x.Value
+		case *ast.SliceExpression:
+			e = x.Value
+		case *ast.PropertyExpression:
+			e = x.Value
+		default:
+			return e
+		}
+	}
+}
+
+// precedence returns how tightly the printed expression binds.
+func precedence(e ast.Expression) int {
+	switch e := e.(type) {
+	case *ast.AssignmentExpression:
+		return 0
+	case *ast.FunctionLiteral:
+		if e.Token.Is(token.ARROW) {
+			return 0
+		}
+		return parser.ASSIGN + 1
+	case *ast.PipelineExpression:
+		return parser.PIPELINE
+	case *ast.ConditionalExpression:
+		return parser.CONDITIONAL
+	case *ast.InfixExpression:
+		return precedences[e.Operator]
+	case *ast.PrefixExpression:
+		return parser.PREFIX
+	case *ast.CallExpression:
+		return parser.CALL
+	case *ast.IndexExpression, *ast.SliceExpression, *ast.PropertyExpression:
+		return parser.INDEX
+	default:
+		return parser.ASSIGN + 1
+	}
+}
+
+func (p *printer) expr(e ast.Expression) {
+	p.operand(e, 0)
+}
+
+// operand prints e, adding parentheses if it binds less tightly than prec.
+func (p *printer) operand(e ast.Expression, prec int) {
+	if precedence(e) < prec {
+		p.write("(")
+		defer p.write(")")
+	}
+	switch e := e.(type) {
+	case *ast.Identifier:
+		p.write(e.Value)
+	case *ast.IntegerLiteral:
+		p.literal(e.Token, e)
+	case *ast.FloatLiteral:
+		p.literal(e.Token, e)
+	case *ast.BooleanExpression, *ast.NullExpression:
+		p.write(e.String())
+	case *ast.StringLiteral:
+		p.write(quote(e.Value))
+	case *ast.TemplateLiteral:
+		p.write(`"`)
+		for i, s := range e.Strings {
+			p.write(escape(s))
+			if i < len(e.Values) {
+				p.write("${")
+				p.expr(e.Values[i])
+				p.write("}")
+			}
+		}
+		p.write(`"`)
+	case *ast.PrefixExpression:
+		p.write(e.Operator)
+		p.operand(e.Right, parser.PREFIX)
+	case *ast.SpreadExpression:
+		p.write("...")
+		p.expr(e.Value)
+	case *ast.ConditionalExpression:
+		p.operand(e.Condition, parser.CONDITIONAL+1)
+		p.write(" ? ")
+		p.expr(e.Consequence)
+		p.write(" : ")
+		p.operand(e.Alternative, parser.CONDITIONAL)
+	case *ast.PipelineExpression:
+		p.operand(e.Left, parser.PIPELINE)
+		p.write(" |> ")
+		p.operand(e.Right, parser.PIPELINE+1)
+	case *ast.InfixExpression:
+		prec := precedences[e.Operator]
+		if e.Operator == "**" {
+			// right associative
+			p.operand(e.Left, prec+1)
+			p.write(" ** ")
+			p.operand(e.Right, parser.PREFIX)
+			break
+		}
+		p.operand(e.Left, prec)
+		p.write(" " + e.Operator + " ")
+		p.operand(e.Right, prec+1)
+	case *ast.AssignmentExpression:
+		p.operand(e.Left, parser.CALL)
+		p.write(" " + e.Operator + " ")
+		p.expr(e.Value)
+	case *ast.CallExpression:
+		p.operand(e.Function, parser.CALL)
+		if e.Optional {
+			p.write("?.")
+		}
+		p.write("(")
+		p.elements(e, exprElements(p, e.Arguments), ")", false)
+	case *ast.IndexExpression:
+		p.operand(e.Value, parser.CALL)
+		if e.Optional {
+			p.write("?.")
+		}
+		p.write("[")
+		p.expr(e.Index)
+		p.write("]")
+	case *ast.SliceExpression:
+		p.operand(e.Value, parser.CALL)
+		if e.Optional {
+			p.write("?.")
+		}
+		p.write("[")
+		if e.Low != nil {
+			p.expr(e.Low)
+		}
+		p.write(":")
+		if e.High != nil {
+			p.expr(e.High)
+		}
+		p.write("]")
+	case *ast.PropertyExpression:
+		p.operand(e.Value, parser.CALL)
+		if e.Optional {
+			p.write("?")
+		}
+		p.write("." + e.Name.Value)
+	case *ast.IfExpression:
+		p.write("if ")
+		p.expr(e.Condition)
+		p.write(" ")
+		p.block(e.Concequence)
+		if e.Alternative != nil {
+			p.write(" else ")
+			p.block(e.Alternative)
+		}
+	case *ast.FunctionLiteral:
+		if e.Token.Is(token.ARROW) {
+			p.arrow(e)
+			break
+		}
+		p.write("fn")
+		p.signature(e.Parameters, e.ReturnType)
+		p.write(" ")
+		p.block(e.Body)
+	case *ast.ArrayLiteral:
+		p.arrayLit(e)
+	case *ast.HashLiteral:
+		p.hashLit(e)
+	case *ast.ArrayPattern, *ast.HashPattern:
+		// patterns on the left of an assignment are parsed as literals
+		p.pattern(e, false)
+	default:
+		p.write(e.String())
+	}
+}
+
+// pattern prints a destructuring pattern on a single line. When
+// shorthand is set, {name: name} is printed as {name}.
+func (p *printer) pattern(e ast.Expression, shorthand bool) {
+	switch e := e.(type) {
+	case *ast.ArrayPattern:
+		p.write("[")
+		for i, elem := range e.Elements {
+			if i > 0 {
+				p.write(", ")
+			}
+			p.pattern(elem, shorthand)
+		}
+		if e.Rest != nil {
+			if len(e.Elements) > 0 {
+				p.write(", ")
+			}
+			p.write("...")
+			p.pattern(e.Rest, shorthand)
+		}
+		p.write("]")
+	case *ast.HashPattern:
+		p.write("{")
+		for i, pair := range e.Pairs {
+			if i > 0 {
+				p.write(", ")
+			}
+			key, ok := pair.Key.(*ast.Identifier)
+			if value, isIdent := pair.Value.(*ast.Identifier); shorthand && ok && isIdent && key.Value == value.Value {
+				p.write(key.Value)
+				continue
+			}
+			if ok {
+				p.write(key.Value)
+			} else {
+				p.expr(pair.Key)
+			}
+			p.write(": ")
+			p.pattern(pair.Value, shorthand)
+		}
+		p.write("}")
+	default:
+		p.expr(e)
+	}
+}
+
+// element is an item in a bracketed list.
+type element struct {
+	start, end token.Pos
+	print      func()
+}
+
+func exprElements(p *printer, list []ast.Expression) []element {
+	var elems []element
+	for _, e := range list {
+		e := e
+		elems = append(elems, element{e.Pos(), e.End(), func() { p.expr(e) }})
+	}
+	return elems
+}
+
+// elements prints a comma separated list which runs until the end of
+// node. Line breaks between the elements are kept from the source. If
+// trail is set, a comma follows the last element when the closing
+// bracket is on its own line.
+func (p *printer) elements(node ast.Node, elems []element, close string, trail bool) {
+	p.line = node.Pos().Line
+	// only indent if there are elements on their own lines
+	indent := 0
+	for _, e := range elems {
+		if e.start.Line > node.Pos().Line {
+			indent = 1
+		}
+	}
+	p.indent += indent
+	for i, e := range elems {
+		p.flush(e.start)
+		if e.start.Line > p.line {
+			p.linebreak(e.start.Line)
+		} else if i > 0 {
+			p.write(" ")
+		}
+		e.print()
+		p.line = e.end.Line
+		last := i == len(elems)-1
+		if !last || trail && node.End().Line > p.line {
+			p.write(",")
+		}
+		if last || elems[i+1].start.Line > p.line {
+			p.trailing()
+		}
+	}
+	p.flush(node.End())
+	p.indent -= indent
+	if node.End().Line > p.line {
+		p.newline()
+	}
+	p.write(close)
+	p.line = node.End().Line
+}
+
+// literal prints a number the way it was written.
+func (p *printer) literal(tok token.Token, e ast.Expression) {
+	if tok.Text != "" {
+		p.write(tok.Text)
+	} else {
+		p.write(e.String())
+	}
+}
+
+func (p *printer) arrayLit(a *ast.ArrayLiteral) {
+	p.write("[")
+	p.elements(a, exprElements(p, a.Elements), "]", false)
+}
+
+func (p *printer) hashLit(h *ast.HashLiteral) {
+	width := alignment(h)
+	var elems []element
+	for i, pair := range h.Pairs {
+		pair, width := pair, width[i]
+		elems = append(elems, element{pair.Key.Pos(), pair.Value.End(), func() {
+			key := sprint(pair.Key)
+			p.write(key + ": ")
+			if pad := width - utf8.RuneCountInString(key); pad > 0 {
+				p.write(strings.Repeat(" ", pad))
+			}
+			p.expr(pair.Value)
+		}})
+	}
+	p.write("{")
+	p.elements(h, elems, "}", true)
+}
+
+// alignment returns the width to pad each key of a hash literal to so
+// that the values line up. Runs of pairs which are on lines of their
+// own are aligned. A blank line ends a run.
+func alignment(h *ast.HashLiteral) []int {
+	width := make([]int, len(h.Pairs))
+	line := h.Pos().Line
+	var run []int
+	align := func() {
+		max := 0
+		for _, i := range run {
+			if n := utf8.RuneCountInString(sprint(h.Pairs[i].Key)); n > max {
+				max = n
+			}
+		}
+		for _, i := range run {
+			width[i] = max
+		}
+		run = nil
+	}
+	for i, pair := range h.Pairs {
+		start, end := pair.Key.Pos().Line, pair.Value.End().Line
+		next := h.End().Line
+		if i < len(h.Pairs)-1 {
+			next = h.Pairs[i+1].Key.Pos().Line
+		}
+		if start <= line || start != end || end >= next || start > line+1 {
+			align()
+		}
+		if start > line && start == end && end < next {
+			run = append(run, i)
+		}
+		line = end
+	}
+	align()
+	return width
+}
+
+// sprint returns the source of an expression.
+func sprint(e ast.Expression) string {
+	var p printer
+	p.expr(e)
+	return string(p.out)
+}
+
+func quote(s string) string {
+	return `"` + escape(s) + `"`
+}
+
+// escape returns s as the contents of a string literal.
+func escape(s string) string {
+	var b strings.Builder
+	for i, r := range s {
+		switch r {
+		case '"', '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '$':
+			if strings.HasPrefix(s[i+1:], "{") {
+				b.WriteByte('\\')
+			}
+			b.WriteByte('$')
+		default:
+			switch {
+			case unicode.IsPrint(r):
+				b.WriteRune(r)
+			case r < 0x80:
+				fmt.Fprintf(&b, `\x%02x`, r)
+			default:
+				fmt.Fprintf(&b, `\u{%x}`, r)
+			}
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,184 @@
+package printer
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/icholy/monkey/parser"
+)
+
+func TestSource(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		output string
+	}{
+		{
+			name:   "statements",
+			input:  "let x=1;return x;debugger;package foo;import \"a.monkey\"",
+			output: "let x = 1\nreturn x\ndebugger\npackage foo\nimport \"a.monkey\"\n",
+		},
+		{
+			name:   "precedence",
+			input:  "(1 + 2) * 3 - (4 - 5) - -6 ** 2; (2 ** 3) ** 2 + 2 ** 3 ** 2; a[(1 + 2)] + a[-1] + (-a)[0] + !(x == y)",
+			output: "(1 + 2) * 3 - (4 - 5) - -6 ** 2;\n(2 ** 3) ** 2 + 2 ** 3 ** 2\na[1 + 2] + a[-1] + (-a)[0] + !(x == y)\n",
+		},
+		{
+			name:   "assignment",
+			input:  "x.y[0] += 1; (a = 1) + 2",
+			output: "x.y[0] += 1;\n(a = 1) + 2\n",
+		},
+		{
+			name:   "semicolons",
+			input:  "x; (y)(z); let a = 1; [1]; b; -1; c; 2",
+			output: "x\ny(z)\nlet a = 1;\n[1]\nb;\n-1\nc\n2\n",
+		},
+		{
+			name:   "bare return",
+			input:  "fn() { return; x }; switch x { case 1: return; default: 2 }",
+			output: "fn() {\n  return;\n  x\n}\nswitch x {\ncase 1:\n  return;\ndefault:\n  2\n}\n",
+		},
+		{
+			name:   "functions",
+			input:  "function f(a: int, b): int { return a } let g = fn(x) { x * 2 }; fn() {}",
+			output: "function f(a: int, b): int { return a }\nlet g = fn(x) { x * 2 }\nfn() {}\n",
+		},
+		{
+			name:   "blocks",
+			input:  "if (x) { 1 } else { 2 }; while x { x -= 1; y } if x {\n1\n}; for k,v in h { k } for x in [1] {}",
+			output: "if x { 1 } else { 2 }\nwhile x {\n  x -= 1\n  y\n}\nif x {\n  1\n}\nfor k, v in h { k }\nfor x in [1] {}\n",
+		},
+		{
+			name:   "branches",
+			input:  "outer:for x in xs { while y { if x { continue outer }; break } }",
+			output: "outer: for x in xs {\n  while y {\n    if x { continue outer }\n    break\n  }\n}\n",
+		},
+		{
+			name:   "destructuring",
+			input:  "let [a,[b],...c]=x\nlet {name: name, \"age\": age, k: {v}} = h;\n[a, b] = [b, a]\n{k: k} = h\nfn([x], {y}) {}",
+			output: "let [a, [b], ...c] = x\nlet {name, \"age\": age, k: {v}} = h;\n[a, b] = [b, a]\n{k: k} = h\nfn([x], {y}) {}\n",
+		},
+		{
+			name:   "spread",
+			input:  "let f = fn(a,b=1+2,...c) {}\nf(...[1,...xs])",
+			output: "let f = fn(a, b = 1 + 2, ...c) {}\nf(...[1, ...xs])\n",
+		},
+		{
+			name:   "slices",
+			input:  "xs[1:i+1]; xs[:-1]; s[2 :]; xs[ : ]; (a+b)[0]",
+			output: "xs[1:i + 1]\nxs[:-1]\ns[2:]\nxs[:];\n(a + b)[0]\n",
+		},
+		{
+			name:   "null operators",
+			input:  "a?.b?.[c]?.(d) ?? (e ?? f); (a ?? b) || c",
+			output: "a?.b?.[c]?.(d) ?? (e ?? f);\n(a ?? b) || c\n",
+		},
+		{
+			name:   "conditional and pipeline",
+			input:  "a?b:c?d:e; (a?b:c)?d:e; x|>f(1)|>(g|>h); (a ?? b) ? c : d",
+			output: "a ? b : c ? d : e;\n(a ? b : c) ? d : e\nx |> f(1) |> (g |> h)\na ?? b ? c : d\n",
+		},
+		{
+			name:   "try",
+			input:  "try{a()}catch(e){throw e}finally{b()}\ntry { a() } catch { }",
+			output: "try { a() } catch (e) { throw e } finally { b() }\ntry { a() } catch {}\n",
+		},
+		{
+			name:   "defer",
+			input:  "fn(){defer f(1);[g]}",
+			output: "fn() {\n  defer f(1);\n  [g]\n}\n",
+		},
+		{
+			name:   "arrow functions",
+			input:  "let f = (x)=>x*2; g = (a, b=1, ...c) => { a }; h(x => y => x); k = () => ({}); (x => x)(1)",
+			output: "let f = x => x * 2\ng = (a, b = 1, ...c) => { a }\nh(x => y => x)\nk = () => ({});\n(x => x)(1)\n",
+		},
+		{
+			name:   "strings",
+			input:  "\"a\\\"b\\\\c\\n\\t\\x01é\"; `${raw}`; \"t ${x + \"${y}\"} \\${z} $\"",
+			output: "\"a\\\"b\\\\c\\n\\t\\x01é\"\n\"\\${raw}\"\n\"t ${x + \"${y}\"} \\${z} $\"\n",
+		},
+		{
+			name:   "literals",
+			input:  "[1, 2.50, 0x1F]; {}; {\"a\": true, 1: null}",
+			output: "[1, 2.50, 0x1F]\n{}\n{\"a\": true, 1: null}\n",
+		},
+		{
+			name:   "multiline literals",
+			input:  "let h = {\n\"a\": 1,\n\"bcd\": 2,\n\n\"e\": [1, 2,\n3] }\nf(1,\n2)",
+			output: "let h = {\n  \"a\":   1,\n  \"bcd\": 2,\n\n  \"e\": [1, 2,\n    3]}\nf(1,\n  2)\n",
+		},
+		{
+			name:   "switch",
+			input:  "switch x {\ncase 1: a; b\ncase 2:\n\nc\ndefault: d }",
+			output: "switch x {\ncase 1:\n  a\n  b\ncase 2:\n  c\ndefault:\n  d\n}\n",
+		},
+		{
+			name:   "comments",
+			input:  "// head\n\n\nlet x = 1; // x\n/* before */\nfunction f() {\n\n  // inside\n  return x\n  // end\n}\n// tail",
+			output: "// head\n\nlet x = 1 // x\n/* before */\nfunction f() {\n  // inside\n  return x\n  // end\n}\n// tail\n",
+		},
+		{
+			name:   "comments in literals",
+			input:  "let a = [\n  1, // one\n  2\n]\nlet h = {\n  // first\n  \"a\": 1, // a\n}",
+			output: "let a = [\n  1, // one\n  2\n]\nlet h = {\n  // first\n  \"a\": 1, // a\n}\n",
+		},
+		{
+			name:   "shebang",
+			input:  "#!/usr/bin/env monkey\nx",
+			output: "#!/usr/bin/env monkey\nx\n",
+		},
+		{
+			name:   "empty",
+			input:  "\n\n",
+			output: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output, err := Source([]byte(tt.input))
+			require.NoError(t, err)
+			require.Equal(t, tt.output, string(output))
+			again, err := Source(output)
+			require.NoError(t, err)
+			require.Equal(t, string(output), string(again), "not idempotent")
+			RequireSameProgram(t, tt.input, string(output))
+		})
+	}
+}
+
+func TestSourceError(t *testing.T) {
+	_, err := Source([]byte("let = 1"))
+	require.Error(t, err)
+}
+
+func TestSourceStd(t *testing.T) {
+	src, err := os.ReadFile("../std.monkey")
+	require.NoError(t, err)
+	output, err := Source(src)
+	require.NoError(t, err)
+	again, err := Source(output)
+	require.NoError(t, err)
+	require.Equal(t, string(output), string(again), "not idempotent")
+	RequireSameProgram(t, string(src), string(output))
+}
+
+func TestFprint(t *testing.T) {
+	program, err := parser.Parse("switch x { case 1: fn(a: int): int { a } }")
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	require.NoError(t, Fprint(&buf, program.Statements[0]))
+	require.Equal(t, "switch x {\ncase 1:\n  fn(a: int): int { a }\n}\n", buf.String())
+}
+
+func RequireSameProgram(t *testing.T, input, output string) {
+	t.Helper()
+	expected, err := parser.Parse(input)
+	require.NoError(t, err)
+	actual, err := parser.Parse(output)
+	require.NoError(t, err)
+	require.Equal(t, expected.String(), actual.String())
+}
@@ -7,8 +7,10 @@ import (
 type TokenType string
 
 type Pos struct {
-	Line   int
-	Offset int
+	Line   int `json:"line"`
+	Offset int `json:"offset"`
+	// Byte is the 0-based byte offset from the start of the input
+	Byte int `json:"byte"`
 }
 
 func (p Pos) String() string {
@@ -17,6 +19,8 @@ func (p Pos) String() string {
 
 type Token struct {
 	Pos
+	// End is the position immediately after the token
+	End  Pos
 	Type TokenType
 	Text string
 }
@@ -40,16 +44,20 @@ func (t Token) String() string {
 const (
 	ILLEGAL = "ILLEGAL"
 	EOF     = "EOF"
+	COMMENT = "COMMENT"
 
 	// Identifiers + literals
 	IDENT    = "IDENT"
 	INT      = "INT"
+	FLOAT    = "FLOAT"
 	ASSIGN   = "ASSIGN"
 	PLUS     = "PLUS"
 	MINUS    = "MINUS"
 	BANG     = "BANG"
 	ASTERISK = "ASTERISK"
 	SLASH    = "SLASH"
+	PERCENT  = "PERCENT"
+	POWER    = "POWER"
 	GT       = "GT"
 	LT       = "LT"
 	EQ       = "EQ"
@@ -59,11 +67,34 @@ const (
 	DOT      = "DOT"
 	OR       = "OR"
 	AND      = "AND"
+	QUESTION = "QUESTION"
+	PIPELINE = "PIPELINE"
+	ARROW    = "ARROW"
+
+	// Null handling
+	QUESTION_DOT = "QUESTION_DOT"
+	NULLISH      = "NULLISH"
+
+	// Bitwise operators
+	AMPERSAND = "AMPERSAND"
+	PIPE      = "PIPE"
+	CARET     = "CARET"
+	TILDE     = "TILDE"
+	SHL       = "SHL"
+	SHR       = "SHR"
+
+	// Compound assignment
+	PLUS_ASSIGN     = "PLUS_ASSIGN"
+	MINUS_ASSIGN    = "MINUS_ASSIGN"
+	ASTERISK_ASSIGN = "ASTERISK_ASSIGN"
+	SLASH_ASSIGN    = "SLASH_ASSIGN"
+	PERCENT_ASSIGN  = "PERCENT_ASSIGN"
 
 	// Delimiters
 	COMMA     = "COMMA"
 	SEMICOLON = "SEMICOLON"
 	COLON     = "COLON"
+	ELLIPSIS  = "ELLIPSIS"
 
 	LPAREN   = "LPAREN"
 	RPAREN   = "RPAREN"
@@ -73,6 +104,11 @@ const (
 	RBRACKET = "RBRACKET"
 	STRING   = "STRING"
 
+	// Template literal parts: "head${x}middle${y}tail"
+	TEMPLATE_HEAD   = "TEMPLATE_HEAD"
+	TEMPLATE_MIDDLE = "TEMPLATE_MIDDLE"
+	TEMPLATE_TAIL   = "TEMPLATE_TAIL"
+
 	// Keywords
 	FN       = "FN"
 	FUNCTION = "FUNCTION"
@@ -84,6 +120,9 @@ const (
 	RETURN   = "RETURN"
 	IMPORT   = "IMPORT"
 	WHILE    = "WHILE"
+	FOR      = "FOR"
+	BREAK    = "BREAK"
+	CONTINUE = "CONTINUE"
 	PACKAGE  = "PACKAGE"
 	DEBUGGER = "DEBUGGER"
 	NULL     = "NULL"
@@ -91,6 +130,11 @@ const (
 	SWITCH   = "SWITCH"
 	CASE     = "CASE"
 	DEFAULT  = "DEFAULT"
+	TRY      = "TRY"
+	CATCH    = "CATCH"
+	FINALLY  = "FINALLY"
+	THROW    = "THROW"
+	DEFER    = "DEFER"
 )
 
 var keywords = map[string]TokenType{
@@ -104,6 +148,9 @@ var keywords = map[string]TokenType{
 	"function": FUNCTION,
 	"import":   IMPORT,
 	"while":    WHILE,
+	"for":      FOR,
+	"break":    BREAK,
+	"continue": CONTINUE,
 	"package":  PACKAGE,
 	"debugger": DEBUGGER,
 	"null":     NULL,
@@ -111,6 +158,11 @@ var keywords = map[string]TokenType{
 	"switch":   SWITCH,
 	"case":     CASE,
 	"default":  DEFAULT,
+	"try":      TRY,
+	"catch":    CATCH,
+	"finally":  FINALLY,
+	"throw":    THROW,
+	"defer":    DEFER,
 }
 
 func LookupIdent(ident string) TokenType {
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/icholy/monkey/lexer"
+	"github.com/icholy/monkey/token"
+)
+
+type jsonToken struct {
+	Type  token.TokenType `json:"type"`
+	Text  string          `json:"text"`
+	Start token.Pos       `json:"start"`
+	End   token.Pos       `json:"end"`
+}
+
+// tokens prints the token stream of a file, including comments.
+func tokens(args []string) error {
+	fs := flag.NewFlagSet("tokens", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print the tokens as a JSON array")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("usage: monkey tokens [-json] file")
+	}
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	l := lexer.NewReader(f)
+	l.KeepComments(true)
+	var toks []jsonToken
+	for {
+		tok := l.NextToken()
+		if *asJSON {
+			toks = append(toks, jsonToken{
+				Type:  tok.Type,
+				Text:  tok.Text,
+				Start: tok.Pos,
+				End:   tok.End,
+			})
+		} else {
+			fmt.Printf("%s-%s\t%s\t%q\n", tok.Pos, tok.End, tok.Type, tok.Text)
+		}
+		if tok.Is(token.EOF) {
+			break
+		}
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(toks); err != nil {
+			return err
+		}
+	}
+	if errs := l.Errors(); len(errs) != 0 {
+		var msgs []string
+		for _, err := range errs {
+			msgs = append(msgs, err.Error())
+		}
+		return errors.New(strings.Join(msgs, "\n"))
+	}
+	return nil
+}
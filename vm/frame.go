@@ -1,6 +1,7 @@
 package vm
 
 import (
+	"github.com/icholy/monkey/ast"
 	"github.com/icholy/monkey/code"
 	"github.com/icholy/monkey/object"
 )
@@ -10,6 +11,24 @@ type Frame struct {
 	cl           *object.Closure
 	ip           int
 	bp           int
+	// argc is the number of arguments passed for the parameters
+	// before the rest parameter
+	argc int
+	// defers holds the calls to make when the function returns
+	defers []deferred
+	// exiting is set once the function has returned or failed with
+	// result or err, and is making its deferred calls
+	exiting bool
+	result  object.Object
+	err     error
+	// deferred is set on the frames of deferred calls
+	deferred bool
+}
+
+type deferred struct {
+	fn   object.Object
+	args []object.Object
+	node ast.Node
 }
 
 func NewFrame(cl *object.Closure, bp int) *Frame {
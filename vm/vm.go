@@ -1,8 +1,12 @@
 package vm
 
 import (
+	"errors"
 	"fmt"
+	"math"
+	"sort"
 
+	"github.com/icholy/monkey/ast"
 	"github.com/icholy/monkey/code"
 	"github.com/icholy/monkey/compiler"
 	"github.com/icholy/monkey/object"
@@ -18,6 +22,9 @@ var (
 	True  = object.New(true)
 	False = object.New(false)
 	Null  = object.New(nil)
+
+	errDivisionByZero = errors.New("division by zero")
+	errNegativeShift  = errors.New("negative shift count")
 )
 
 type VM struct {
@@ -30,11 +37,19 @@ type VM struct {
 
 	frames   []*Frame
 	frameIdx int
+
+	// cells holds the captured variables which are still on the
+	// stack, ordered by slot
+	cells []*cell
 }
 
 func New(bytecode *compiler.Bytecode) *VM {
 
-	fn := &object.CompiledFunction{Instructions: bytecode.Instructions}
+	fn := &object.CompiledFunction{
+		Instructions: bytecode.Instructions,
+		SourceMap:    bytecode.SourceMap,
+		Handlers:     bytecode.Handlers,
+	}
 	closure := &object.Closure{Fn: fn}
 
 	frames := make([]*Frame, MaxFrames)
@@ -81,7 +96,173 @@ func (vm *VM) LastPopped() object.Object {
 	return vm.stack[vm.sp]
 }
 
+type Error struct {
+	Err  error
+	Node ast.Node
+}
+
+func (e Error) Error() string {
+	return fmt.Sprintf("%s-%s: %s", e.Node.Pos(), e.Node.End(), e.Err)
+}
+
+// Run executes the bytecode. Errors are reported at the source of the
+// instruction which caused them when it's known.
 func (vm *VM) Run() error {
+	for {
+		err := vm.run()
+		if err == nil {
+			return nil
+		}
+		if _, ok := err.(*Error); !ok {
+			frame := vm.frame()
+			if node := frame.cl.Fn.SourceMap.Lookup(frame.ip); node != nil {
+				err = &Error{
+					Node: node,
+					Err:  err,
+				}
+			}
+		}
+		if err = vm.catch(err); err != nil {
+			return err
+		}
+	}
+}
+
+// catch unwinds the stack to the innermost handler for err and jumps to
+// it. Functions without one make their deferred calls on the way out. It
+// returns the error if there was no handler. Try statements start with
+// nothing on the stack above the locals, so that's where it's reset to.
+func (vm *VM) catch(err error) error {
+	for {
+		frame := vm.frame()
+		// a function which is exiting only has deferred calls left
+		if h, ok := frame.cl.Fn.Handlers.Lookup(frame.ip); ok && !frame.exiting {
+			vm.sp = frame.bp + frame.cl.Fn.NumLocals
+			frame.JumpTo(h.Target)
+			if h.Finally {
+				return vm.push(&pending{err: err})
+			}
+			return vm.push(thrown(err))
+		}
+		if vm.frameIdx == 0 {
+			return err
+		}
+		frame.exiting, frame.err = true, err
+		if err = vm.exit(); err == nil {
+			return nil
+		}
+	}
+}
+
+// exit makes the deferred calls of the current function, which has
+// returned or failed, last to first and then leaves it. Deferred closures
+// run in their own frames and exit is called again when they return. An
+// error from a deferred call replaces the function's result.
+func (vm *VM) exit() error {
+	frame := vm.frame()
+	for n := len(frame.defers); n > 0; n = len(frame.defers) {
+		d := frame.defers[n-1]
+		frame.defers = frame.defers[:n-1]
+		vm.sp = frame.bp + frame.cl.Fn.NumLocals
+		if err := vm.push(d.fn); err != nil {
+			return err
+		}
+		for _, arg := range d.args {
+			if err := vm.push(arg); err != nil {
+				return err
+			}
+		}
+		if err := vm.call(len(d.args)); err != nil {
+			frame.err = err
+			if d.node != nil {
+				frame.err = &Error{Node: d.node, Err: err}
+			}
+			continue
+		}
+		if f := vm.frame(); f != frame {
+			f.deferred = true
+			return nil
+		}
+	}
+	vm.popFrame()
+	vm.closeCells(frame.bp)
+	if frame.err != nil {
+		return frame.err
+	}
+	vm.sp = frame.bp - 1
+	if frame.deferred {
+		return vm.exit()
+	}
+	return vm.push(frame.result)
+}
+
+// thrown returns the value a catch block receives for err. Errors which
+// weren't thrown are converted to error objects.
+func thrown(err error) object.Object {
+	if e, ok := err.(*Error); ok {
+		err = e.Err
+	}
+	if e, ok := err.(*object.Exception); ok {
+		return e.Value
+	}
+	return &object.Error{Message: err.Error()}
+}
+
+// pending holds an error while a finally block runs, OpThrow raises it
+// again unchanged.
+type pending struct {
+	err error
+}
+
+func (p *pending) KeyValue() object.KeyValue { return p }
+func (p *pending) Inspect(depth int) string  { return p.err.Error() }
+func (p *pending) Type() object.ObjectType   { return "PENDING" }
+
+// cell holds a variable captured by closures. It refers to the
+// variable's stack slot until the function which declared it exits, and
+// then holds the value itself.
+type cell struct {
+	slot  int
+	ref   *object.Object
+	value object.Object
+}
+
+func (c *cell) get() object.Object  { return *c.ref }
+func (c *cell) set(v object.Object) { *c.ref = v }
+
+func (c *cell) KeyValue() object.KeyValue { return c }
+func (c *cell) Inspect(depth int) string  { return c.get().Inspect(depth) }
+func (c *cell) Type() object.ObjectType   { return "CELL" }
+
+// capture returns the cell for the stack slot, so that closures which
+// capture the same variable share it.
+func (vm *VM) capture(slot int) *cell {
+	i := sort.Search(len(vm.cells), func(i int) bool {
+		return vm.cells[i].slot >= slot
+	})
+	if i < len(vm.cells) && vm.cells[i].slot == slot {
+		return vm.cells[i]
+	}
+	c := &cell{slot: slot, ref: &vm.stack[slot]}
+	vm.cells = append(vm.cells, nil)
+	copy(vm.cells[i+1:], vm.cells[i:])
+	vm.cells[i] = c
+	return c
+}
+
+// closeCells moves the captured variables at or above slot off the
+// stack.
+func (vm *VM) closeCells(slot int) {
+	n := len(vm.cells)
+	for ; n > 0 && vm.cells[n-1].slot >= slot; n-- {
+		c := vm.cells[n-1]
+		c.value = *c.ref
+		c.ref = &c.value
+	}
+	vm.cells = vm.cells[:n]
+}
+
+func (vm *VM) run() error {
 
 	frame := vm.frame()
 
@@ -95,7 +276,8 @@ func (vm *VM) Run() error {
 			if err := vm.push(vm.constants[index]); err != nil {
 				return err
 			}
-		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv:
+		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv, code.OpMod, code.OpPow,
+			code.OpBitAnd, code.OpBitOr, code.OpBitXor, code.OpShl, code.OpShr:
 			right := vm.pop()
 			left := vm.pop()
 			if err := vm.binaryOp(op, left, right); err != nil {
@@ -131,12 +313,14 @@ func (vm *VM) Run() error {
 			}
 		case code.OpHash:
 			n := frame.ReadUint16()
-			h := object.NewHash()
+			pairs := make([]*object.HashPair, n)
 			for i := 0; i < n; i++ {
 				value := vm.pop()
 				key := vm.pop()
-				h.Set(key, value)
+				pairs[n-1-i] = &object.HashPair{Key: key, Value: value}
 			}
+			h := object.NewHash()
+			h.SetPairs(pairs...)
 			if err := vm.push(h); err != nil {
 				return err
 			}
@@ -144,8 +328,64 @@ func (vm *VM) Run() error {
 			if err := vm.indexOp(); err != nil {
 				return err
 			}
+		case code.OpProperty:
+			optional := frame.ReadUint8()
+			if err := vm.propertyOp(optional == 1); err != nil {
+				return err
+			}
+		case code.OpSlice:
+			high := vm.pop()
+			low := vm.pop()
+			slice, err := object.Slice(vm.pop(), low, high)
+			if err != nil {
+				return err
+			}
+			if err := vm.push(slice); err != nil {
+				return err
+			}
+		case code.OpSetIndex:
+			if err := vm.setIndexOp(); err != nil {
+				return err
+			}
+		case code.OpIter:
+			it, err := object.NewIterator(vm.pop())
+			if err != nil {
+				return err
+			}
+			if err := vm.push(it); err != nil {
+				return err
+			}
+		case code.OpIterNext:
+			n := frame.ReadUint8()
+			if err := vm.iterNextOp(n); err != nil {
+				return err
+			}
+		case code.OpUnpackArray:
+			n := frame.ReadUint16()
+			rest := frame.ReadUint8()
+			if err := vm.unpackArrayOp(n, rest == 1); err != nil {
+				return err
+			}
+		case code.OpUnpackHash:
+			n := frame.ReadUint16()
+			if err := vm.unpackHashOp(n); err != nil {
+				return err
+			}
+		case code.OpStr:
+			v := vm.pop()
+			if err := vm.push(&object.String{Value: object.ToString(v)}); err != nil {
+				return err
+			}
 		case code.OpPop:
 			vm.pop()
+		case code.OpDup:
+			n := frame.ReadUint8()
+			base := vm.sp - n
+			for i := 0; i < n; i++ {
+				if err := vm.push(vm.stack[base+i]); err != nil {
+					return err
+				}
+			}
 		case code.OpMinus:
 			if err := vm.minusOp(); err != nil {
 				return err
@@ -154,6 +394,10 @@ func (vm *VM) Run() error {
 			if err := vm.bangOp(); err != nil {
 				return err
 			}
+		case code.OpBitNot:
+			if err := vm.bitNotOp(); err != nil {
+				return err
+			}
 		case code.OpJump:
 			pos := frame.ReadUint16()
 			frame.JumpTo(pos)
@@ -163,6 +407,18 @@ func (vm *VM) Run() error {
 			if !isTruthy(condition) {
 				frame.JumpTo(pos)
 			}
+		case code.OpJumpNull:
+			pos := frame.ReadUint16()
+			if _, ok := vm.peek().(*object.Null); ok {
+				frame.JumpTo(pos)
+			}
+		case code.OpJumpNotNull:
+			pos := frame.ReadUint16()
+			if _, ok := vm.peek().(*object.Null); ok {
+				vm.pop()
+			} else {
+				frame.JumpTo(pos)
+			}
 		case code.OpSetGlobal:
 			index := frame.ReadUint16()
 			vm.globals[index] = vm.pop()
@@ -185,32 +441,52 @@ func (vm *VM) Run() error {
 				return err
 			}
 		case code.OpGetFree:
+			index := frame.ReadUint8()
+			if err := vm.push(frame.cl.Free[index].(*cell).get()); err != nil {
+				return err
+			}
+		case code.OpSetFree:
+			index := frame.ReadUint8()
+			frame.cl.Free[index].(*cell).set(vm.pop())
+		case code.OpCaptureLocal:
+			index := frame.ReadUint8()
+			if err := vm.push(vm.capture(frame.bp + index)); err != nil {
+				return err
+			}
+		case code.OpCaptureFree:
 			index := frame.ReadUint8()
 			if err := vm.push(frame.cl.Free[index]); err != nil {
 				return err
 			}
 		case code.OpCall:
 			nArgs := frame.ReadUint8() // num args
-			callee := vm.stack[vm.sp-1-nArgs]
-
-			switch callee := callee.(type) {
-			case *object.Builtin:
-				args := vm.stack[vm.sp-nArgs : vm.sp]
-				ret, err := callee.Fn(args...)
-				vm.sp = vm.sp - nArgs - 1
-				if err != nil {
+			if err := vm.call(nArgs); err != nil {
+				return err
+			}
+			frame = vm.frame()
+		case code.OpCallSpread:
+			args, ok := vm.pop().(*object.Array)
+			if !ok {
+				return fmt.Errorf("not an array")
+			}
+			for _, arg := range args.Elements {
+				if err := vm.push(arg); err != nil {
 					return err
 				}
-				vm.push(ret)
-			case *object.Closure:
-				if nArgs != callee.Fn.NumParameters {
-					return fmt.Errorf("wrong number of arguments: want %d, got %d", callee.Fn.NumParameters, nArgs)
-				}
-				frame = NewFrame(callee, vm.sp-nArgs)
-				vm.pushFrame(frame)
-				vm.sp = frame.bp + callee.Fn.NumLocals
-			default:
-				return fmt.Errorf("calling non-function")
+			}
+			if err := vm.call(len(args.Elements)); err != nil {
+				return err
+			}
+			frame = vm.frame()
+		case code.OpMissingArg:
+			index := frame.ReadUint8()
+			if err := vm.push(boolObject(index >= frame.argc)); err != nil {
+				return err
+			}
+		case code.OpConcat:
+			n := frame.ReadUint16()
+			if err := vm.concatOp(n); err != nil {
+				return err
 			}
 		case code.OpClosure:
 			index := frame.ReadUint16()
@@ -220,7 +496,7 @@ func (vm *VM) Run() error {
 				return fmt.Errorf("closure: not a function")
 			}
 
-			// push the free variables onto the stack
+			// collect the captured variables from the stack
 			free := make([]object.Object, nFree)
 			for i := 0; i < nFree; i++ {
 				free[i] = vm.stack[vm.sp-nFree+i]
@@ -230,13 +506,28 @@ func (vm *VM) Run() error {
 			if err := vm.push(&object.Closure{Fn: fn, Free: free}); err != nil {
 				return err
 			}
+		case code.OpThrow:
+			if p, ok := vm.peek().(*pending); ok {
+				return p.err
+			}
+			return &object.Exception{Value: vm.pop()}
+		case code.OpDefer:
+			args, ok := vm.pop().(*object.Array)
+			if !ok {
+				return fmt.Errorf("not an array")
+			}
+			frame.defers = append(frame.defers, deferred{
+				fn:   vm.pop(),
+				args: args.Elements,
+				node: frame.cl.Fn.SourceMap.Lookup(frame.ip),
+			})
 		case code.OpReturn:
-			retVal := vm.pop()
-			vm.sp = vm.popFrame().bp - 1
-			frame = vm.frame()
-			if err := vm.push(retVal); err != nil {
+			frame.exiting = true
+			frame.result = vm.pop()
+			if err := vm.exit(); err != nil {
 				return err
 			}
+			frame = vm.frame()
 		default:
 			return fmt.Errorf("unexpected opcode: %d", op)
 		}
@@ -284,24 +575,251 @@ func (vm *VM) indexOp() error {
 			return err
 		}
 		return vm.push(el)
+	case *object.String:
+		i, ok := index.(*object.Integer)
+		if !ok {
+			return fmt.Errorf("cannot index into string with: %s", index.Type())
+		}
+		el, err := value.At(int(i.Value))
+		if err != nil {
+			return err
+		}
+		return vm.push(el)
 	case *object.Hash:
 		el, ok := value.Get(index)
 		if !ok {
 			return vm.push(Null)
 		}
 		return vm.push(el)
+	case *object.Error:
+		el, ok := value.Get(index)
+		if !ok {
+			return vm.push(Null)
+		}
+		return vm.push(el)
 	default:
 		return fmt.Errorf("cannot index into: %s", value.Type())
 	}
 }
 
+// propertyOp pushes the property of a hash or error. A missing property
+// is an error unless the lookup is optional.
+func (vm *VM) propertyOp(optional bool) error {
+	name := vm.pop().(*object.String)
+	var (
+		val object.Object
+		ok  bool
+	)
+	switch value := vm.pop().(type) {
+	case *object.Hash:
+		val, ok = value.Get(name)
+	case *object.Error:
+		val, ok = value.Get(name)
+	default:
+		return fmt.Errorf("cannot access '%s' of %s", name.Value, value.Type())
+	}
+	switch {
+	case ok:
+		return vm.push(val)
+	case optional:
+		return vm.push(Null)
+	default:
+		return fmt.Errorf("property not found: %s", name.Value)
+	}
+}
+
+// iterNextOp pushes the next n loop variables and true, or false when
+// the iterator is done.
+func (vm *VM) iterNextOp(n int) error {
+	it, ok := vm.pop().(*object.Iterator)
+	if !ok {
+		return fmt.Errorf("not an iterator")
+	}
+	key, value, ok := it.Next()
+	if !ok {
+		return vm.push(False)
+	}
+	if n == 2 {
+		if err := vm.push(key); err != nil {
+			return err
+		}
+		if err := vm.push(value); err != nil {
+			return err
+		}
+	} else {
+		if err := vm.push(it.Single(key, value)); err != nil {
+			return err
+		}
+	}
+	return vm.push(True)
+}
+
+// call calls the function below the n arguments on top of the stack.
+// Missing arguments are set to null, and the remaining arguments are
+// collected into an array for the rest parameter.
+func (vm *VM) call(n int) error {
+	switch callee := vm.stack[vm.sp-1-n].(type) {
+	case *object.Builtin:
+		args := vm.stack[vm.sp-n : vm.sp]
+		ret, err := callee.Fn(args...)
+		vm.sp = vm.sp - n - 1
+		if err != nil {
+			return err
+		}
+		return vm.push(ret)
+	case *object.Closure:
+		fn := callee.Fn
+		fixed := fn.NumParameters
+		if fn.Rest {
+			fixed--
+		}
+		required := fixed - fn.NumDefaults
+		switch {
+		case fn.NumDefaults == 0 && !fn.Rest && n != fixed:
+			return fmt.Errorf("wrong number of arguments: want %d, got %d", fixed, n)
+		case n < required:
+			return fmt.Errorf("wrong number of arguments: want at least %d, got %d", required, n)
+		case !fn.Rest && n > fixed:
+			return fmt.Errorf("wrong number of arguments: want at most %d, got %d", fixed, n)
+		}
+		argc := n
+		var rest *object.Array
+		if fn.Rest {
+			rest = &object.Array{}
+			if n > fixed {
+				rest.Elements = append(rest.Elements, vm.stack[vm.sp-(n-fixed):vm.sp]...)
+				vm.sp -= n - fixed
+				argc = fixed
+			}
+		}
+		for i := argc; i < fixed; i++ {
+			if err := vm.push(Null); err != nil {
+				return err
+			}
+		}
+		if rest != nil {
+			if err := vm.push(rest); err != nil {
+				return err
+			}
+		}
+		frame := NewFrame(callee, vm.sp-fn.NumParameters)
+		frame.argc = argc
+		vm.pushFrame(frame)
+		vm.sp = frame.bp + fn.NumLocals
+		return nil
+	default:
+		return fmt.Errorf("calling non-function")
+	}
+}
+
+// concatOp replaces the n arrays on top of the stack with their
+// concatenation.
+func (vm *VM) concatOp(n int) error {
+	var elements []object.Object
+	for _, v := range vm.stack[vm.sp-n : vm.sp] {
+		arr, ok := v.(*object.Array)
+		if !ok {
+			return fmt.Errorf("cannot spread %s", v.Type())
+		}
+		elements = append(elements, arr.Elements...)
+	}
+	vm.sp -= n
+	return vm.push(&object.Array{Elements: elements})
+}
+
+// unpackArrayOp replaces an array with its first n elements, the first
+// one on top, and an array of the remaining elements below them when
+// rest is set. Missing elements are null.
+func (vm *VM) unpackArrayOp(n int, rest bool) error {
+	val := vm.pop()
+	arr, ok := val.(*object.Array)
+	if !ok {
+		return fmt.Errorf("cannot destructure %s as array", val.Type())
+	}
+	if rest {
+		r := &object.Array{}
+		if n < len(arr.Elements) {
+			r.Elements = append(r.Elements, arr.Elements[n:]...)
+		}
+		if err := vm.push(r); err != nil {
+			return err
+		}
+	}
+	for i := n - 1; i >= 0; i-- {
+		var elem object.Object = Null
+		if arr.InRange(i) {
+			elem = arr.Elements[i]
+		}
+		if err := vm.push(elem); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unpackHashOp replaces a hash and the n keys above it with the values
+// of those keys, the first one on top. Missing values are null.
+func (vm *VM) unpackHashOp(n int) error {
+	keys := make([]object.Object, n)
+	for i := n - 1; i >= 0; i-- {
+		keys[i] = vm.pop()
+	}
+	val := vm.pop()
+	hash, ok := val.(*object.Hash)
+	if !ok {
+		return fmt.Errorf("cannot destructure %s as hash", val.Type())
+	}
+	for i := n - 1; i >= 0; i-- {
+		v, ok := hash.Get(keys[i])
+		if !ok {
+			v = Null
+		}
+		if err := vm.push(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (vm *VM) setIndexOp() error {
+	value := vm.pop()
+	index := vm.pop()
+	dest := vm.pop()
+
+	switch dest := dest.(type) {
+	case *object.Array:
+		i, ok := index.(*object.Integer)
+		if !ok {
+			return fmt.Errorf("index must be an integer %s", index.Type())
+		}
+		return dest.SetAt(int(i.Value), value)
+	case *object.Hash:
+		dest.Set(index, value)
+		return nil
+	default:
+		return fmt.Errorf("cannot index into %s", dest.Type())
+	}
+}
+
 func (vm *VM) minusOp() error {
+	right := vm.pop()
+	switch value := right.(type) {
+	case *object.Integer:
+		return vm.push(&object.Integer{Value: -value.Value})
+	case *object.Float:
+		return vm.push(&object.Float{Value: -value.Value})
+	default:
+		return fmt.Errorf("cannot use minus on type: %s", right.Type())
+	}
+}
+
+func (vm *VM) bitNotOp() error {
 	right := vm.pop()
 	value, ok := right.(*object.Integer)
 	if !ok {
-		return fmt.Errorf("cannot use minus on type: %s", right.Type())
+		return fmt.Errorf("cannot use bitwise not on type: %s", right.Type())
 	}
-	return vm.push(&object.Integer{Value: -value.Value})
+	return vm.push(&object.Integer{Value: ^value.Value})
 }
 
 func (vm *VM) bangOp() error {
@@ -318,9 +836,12 @@ func (vm *VM) bangOp() error {
 }
 
 func (vm *VM) compareOp(op code.Opcode, left, right object.Object) error {
-	if left.Type() == object.INTEGER && left.Type() == object.INTEGER {
+	if left.Type() == object.INTEGER && right.Type() == object.INTEGER {
 		return vm.compareIntegerOp(op, left.(*object.Integer), right.(*object.Integer))
 	}
+	if isNumeric(left) && isNumeric(right) {
+		return vm.compareFloatOp(op, toFloat(left), toFloat(right))
+	}
 	switch op {
 	case code.OpEqual:
 		return vm.push(boolObject(left == right))
@@ -344,10 +865,26 @@ func (vm *VM) compareIntegerOp(op code.Opcode, left, right *object.Integer) erro
 	}
 }
 
+func (vm *VM) compareFloatOp(op code.Opcode, left, right float64) error {
+	switch op {
+	case code.OpEqual:
+		return vm.push(boolObject(left == right))
+	case code.OpNotEqual:
+		return vm.push(boolObject(left != right))
+	case code.OpGreaterThan:
+		return vm.push(boolObject(left > right))
+	default:
+		return fmt.Errorf("unknown operator: %d", op)
+	}
+}
+
 func (vm *VM) binaryOp(op code.Opcode, left, right object.Object) error {
 	if left.Type() == object.INTEGER && right.Type() == object.INTEGER {
 		return vm.binaryIntegerOp(op, left.(*object.Integer), right.(*object.Integer))
 	}
+	if isNumeric(left) && isNumeric(right) {
+		return vm.binaryFloatOp(op, toFloat(left), toFloat(right))
+	}
 	if left.Type() == object.STRING && right.Type() == object.STRING {
 		return vm.binaryStringOp(op, left.(*object.String), right.(*object.String))
 	}
@@ -375,13 +912,96 @@ func (vm *VM) binaryIntegerOp(op code.Opcode, left, right *object.Integer) error
 	case code.OpMul:
 		result = left.Value * right.Value
 	case code.OpDiv:
+		if right.Value == 0 {
+			return errDivisionByZero
+		}
 		result = left.Value / right.Value
+	case code.OpMod:
+		if right.Value == 0 {
+			return errDivisionByZero
+		}
+		result = left.Value % right.Value
+	case code.OpPow:
+		if right.Value < 0 {
+			return vm.push(&object.Float{Value: math.Pow(float64(left.Value), float64(right.Value))})
+		}
+		result = intPow(left.Value, right.Value)
+	case code.OpBitAnd:
+		result = left.Value & right.Value
+	case code.OpBitOr:
+		result = left.Value | right.Value
+	case code.OpBitXor:
+		result = left.Value ^ right.Value
+	case code.OpShl:
+		if right.Value < 0 {
+			return errNegativeShift
+		}
+		result = left.Value << uint64(right.Value)
+	case code.OpShr:
+		if right.Value < 0 {
+			return errNegativeShift
+		}
+		result = left.Value >> uint64(right.Value)
 	default:
 		return fmt.Errorf("unknown integer operator: %d", op)
 	}
 	return vm.push(&object.Integer{Value: result})
 }
 
+func (vm *VM) binaryFloatOp(op code.Opcode, left, right float64) error {
+	var result float64
+	switch op {
+	case code.OpAdd:
+		result = left + right
+	case code.OpSub:
+		result = left - right
+	case code.OpMul:
+		result = left * right
+	case code.OpDiv:
+		if right == 0 {
+			return errDivisionByZero
+		}
+		result = left / right
+	case code.OpMod:
+		if right == 0 {
+			return errDivisionByZero
+		}
+		result = math.Mod(left, right)
+	case code.OpPow:
+		result = math.Pow(left, right)
+	default:
+		return fmt.Errorf("unknown float operator: %d", op)
+	}
+	return vm.push(&object.Float{Value: result})
+}
+
+func intPow(x, n int64) int64 {
+	result := int64(1)
+	for n > 0 {
+		if n&1 == 1 {
+			result *= x
+		}
+		x *= x
+		n >>= 1
+	}
+	return result
+}
+
+func isNumeric(v object.Object) bool {
+	return v.Type() == object.INTEGER || v.Type() == object.FLOAT
+}
+
+func toFloat(v object.Object) float64 {
+	switch v := v.(type) {
+	case *object.Integer:
+		return float64(v.Value)
+	case *object.Float:
+		return v.Value
+	default:
+		return 0
+	}
+}
+
 func (vm *VM) peek() object.Object {
 	if vm.sp == 0 {
 		return nil
@@ -25,6 +25,13 @@ func TestRun(t *testing.T) {
 		{"1 / 1", object.New(1)},
 		{"1 + 4 * 2", object.New(9)},
 		{"10 + 10 / 5", object.New(12)},
+		{"1.5", object.New(1.5)},
+		{"1.5 + 1", object.New(2.5)},
+		{"1 / 2.0", object.New(0.5)},
+		{"-0.5 * 4", object.New(-2.0)},
+		{"2.5 > 2", object.New(true)},
+		{"2 < 2.5", object.New(true)},
+		{"1 == 1.0", object.New(true)},
 		{"true", object.New(true)},
 		{"false", object.New(false)},
 		{"1 > 2", object.New(false)},
@@ -55,7 +62,35 @@ func TestRun(t *testing.T) {
 		{`[1 + 2, "test", true == false]`, object.New([]interface{}{3, "test", false})},
 		{"{1: 1, 2: 2, 3:3 }", object.New(map[interface{}]interface{}{1: 1, 2: 2, 3: 3})},
 		{"[1, 2, 3][1]", object.New(2)},
+		{"[1, 2, 3][-1]", object.New(3)},
+		{`let x = {"a": {"b": 1}}; [x?.a?.b, x?.a?.c?.d, x.a.b]`, object.New([]interface{}{1, nil, 1})},
+		{`let x = null; [x?.a, x?.[1 / 0], x?.(1 / 0), x?.[1:]]`, object.New([]interface{}{nil, nil, nil, nil})},
+		{`len?.("abc")`, object.New(3)},
+		{`let x = {}; x?.foo`, Null},
+		{`let a = null; a?.b.c`, Null},
+		{`let a = null; a?.b()`, Null},
+		{`let a = null; a?.b[0].c(1 / 0)[1:]`, Null},
+		{`let a = {"b": {"c": 1}}; a?.b.c`, object.New(1)},
+		{`let a = {"b": fn(x) { x }}; a?.b(null?.c.d)`, Null},
+		{`let f = fn(a) { defer a?.b(); 1 }; f(null)`, object.New(1)},
+		{"[null ?? 1, false ?? 1, 2 ?? 1 / 0]", object.New([]interface{}{1, false, 2})},
+		{`let h = {"a": 1}; h.a += 1; h.a`, object.New(2)},
+		{"[true ? 1 : 2, null ? 1 / 0 : 2, false ? 1 : 0 ? 3 : 4]", object.New([]interface{}{1, 2, 3})},
+		{`"abc" |> len`, object.New(3)},
+		{"let sub = fn(a, b) { a - b }; 10 |> sub(3) |> sub(2)", object.New(5)},
+		{"let f = fn(a, ...r) { r }; 1 |> f(...[2, 3])", object.New([]interface{}{2, 3})},
+		{"let x = [1, 2]; x[-2] = 5; x", object.New([]interface{}{5, 2})},
+		{"[1, 2, 3, 4][1:3]", object.New([]interface{}{2, 3})},
+		{"[1, 2, 3][:-1]", object.New([]interface{}{1, 2})},
+		{`"héllo"[1:]`, object.New("éllo")},
+		{"let x = [1, 2]; let y = x[:]; y[0] = 5; x", object.New([]interface{}{1, 2})},
 		{"fn() { 15 }()", object.New(15)},
+		{"let double = x => x * 2; double(4)", object.New(8)},
+		{"((a, b) => { let c = a + b; c * 2 })(1, 2)", object.New(6)},
+		{"let add = x => y => x + y; add(1)(2)", object.New(3)},
+		{"((a, b = 2, ...c) => a + b + len(c))(1)", object.New(3)},
+		{"(([a, b]) => a + b)([3, 4])", object.New(7)},
+		{"(() => 5)()", object.New(5)},
 		{"let one = fn() { 1 }; one() + one()", object.New(2)},
 		{"let x = fn() { return 1; return 2; }; x()", object.New(1)},
 		{"let x = fn() { return }; x()", object.New(nil)},
@@ -72,9 +107,99 @@ func TestRun(t *testing.T) {
 		{"append([], 1)", object.New([]interface{}{1})},
 		{"len([]); 1", object.New(1)},
 		{`len("hello world")`, object.New(11)},
+		{`len("日本語")`, object.New(3)},
+		{`let x = 1; let y = 2; "x=${x}, y=${y + 1}"`, object.New("x=1, y=3")},
+		{`"${"a"}${true}${null}"`, object.New("atruenull")},
+		{`"héllo"[1]`, object.New("é")},
+		{`"héllo"[4]`, object.New("o")},
+		{`"héllo"[-5]`, object.New("h")},
+		{`"abc"[2]`, object.New("c")},
+		{`let π = 3.14; π`, object.New(3.14)},
 		{"last([1, 2, 3])", object.New(3)},
 		{"let x = len([1, 2, 3]); let y = len([1, 2, 3]); y + x", object.New(6)},
 		{"let make = fn(a) { fn() {a} }; make(1)()", object.New(1)},
+		{"fn() { let x = 1; let g = fn() { x }; x = 2; g() }()", object.New(2)},
+		{"let counter = fn() { let n = 0; fn() { n += 1; n } }; let c = counter(); c(); c()", object.New(2)},
+		{"let f = fn() { let n = 0; [fn() { n += 1 }, fn() { n }] }; let p = f(); p[0](); p[0](); p[1]()", object.New(2)},
+		{"fn() { let n = 0; let inc = fn() { fn() { n += 1 } }; inc()(); n }()", object.New(1)},
+		{"7 % 3", object.New(1)},
+		{"-7 % 3", object.New(-1)},
+		{"7.5 % 2", object.New(1.5)},
+		{"2 ** 10", object.New(1024)},
+		{"2 ** 3 ** 2", object.New(512)},
+		{"2 ** -1", object.New(0.5)},
+		{"1.5 ** 2", object.New(2.25)},
+		{"{1.0: 2}[1]", object.New(2)},
+		{"{1: 2}[1.0]", object.New(2)},
+		{"{1.5: 2}[1.5]", object.New(2)},
+		{"len({1: 1, 1.0: 2})", object.New(1)},
+		{"6 & 3 | 8 ^ 1", object.New(11)},
+		{"1 << 4 >> 2", object.New(4)},
+		{"~5", object.New(-6)},
+		{"let x = 1; x = 2; x", object.New(2)},
+		{"let x = 5; x += 2; x *= 3; x -= 1; x /= 4; x %= 3; x", object.New(2)},
+		{"let x = 1; x = 2", object.New(nil)},
+		{"let f = fn() { let x = 1; x += 1; x }; f()", object.New(2)},
+		{"let a = [1, 2]; a[1] = 5; a", object.New([]interface{}{1, 5})},
+		{"let a = [1, 2]; a[0] += 10; a[0]", object.New(11)},
+		{"let n = 0; let f = fn() { n += 1; 0 }; let a = [1, 2]; a[f()] += 10; [a, n]", object.New([]interface{}{[]interface{}{11, 2}, 1})},
+		{`let log = []; let h = {"x": 1}; let f = fn() { append(log, "h"); h }; let g = fn() { append(log, "v"); 1 }; f().x += g(); f()["x"] = g(); [h.x, log]`, object.New([]interface{}{1, []interface{}{"h", "v", "h", "v"}})},
+		{`let h = {"x": 1}; h.x += 1; h.y = 3; h["x"] + h["y"]`, object.New(5)},
+		{"let s = 0; for x in [1, 2, 3] { s += x }; s", object.New(6)},
+		{"let s = 0; for i, x in [5, 6] { s += i * x }; s", object.New(6)},
+		{`let s = 0; for k, v in {"a": 1, "b": 2} { s += v }; s`, object.New(3)},
+		{`let s = ""; for k in {"a": 1} { s += k }; s`, object.New("a")},
+		{`let s = ""; for k in {"e": 1, "b": 2, "d": 3, "a": 4, "c": 5} { s += k }; s`, object.New("ebdac")},
+		{`let h = {"b": 1, "a": 2}; h["c"] = 3; h["b"] = 4; let s = ""; for k, v in h { s += k + str(v) }; s`, object.New("b4a2c3")},
+		{`let s = ""; for ch in "héllo" { s = ch + s }; s`, object.New("olléh")},
+		{"let s = 0; for i in 4 { for j in i { s += 1 } }; s", object.New(6)},
+		{"let fs = []; for x in [1, 2] { fs = append(fs, fn() { x }) }; fs[0]() + fs[1]()", object.New(4)},
+		{"fn() { let fs = []; for i, x in [1, 2] { fs = append(fs, fn() { i + x }) }; fs[0]() + fs[1]() }()", object.New(6)},
+		{"let f = fn(a) { let s = 0; for x in a { s += x }; s }; f([1, 2]) + f([3])", object.New(6)},
+		{"let f = fn() { for x in [1, 2] { return x } }; f()", object.New(1)},
+		{"let i = 0; while i < 5 { i += 1 }; i", object.New(5)},
+		{"let i = 0; while true { i += 1; if i == 3 { break } }; i", object.New(3)},
+		{"let s = 0; for x in 5 { if x % 2 == 0 { continue } s += x }; s", object.New(4)},
+		{"let s = 0; outer: for i in 3 { for j in 3 { if j > i { continue outer } if i == 2 { break outer } s += 1 } }; s", object.New(3)},
+		{"let f = fn() { let i = 0; a: while true { while true { i += 1; if i > 2 { break a } } }; i }; f()", object.New(3)},
+		{"let [a, [b, c], ...rest] = [1, [2, 3], 4, 5]; [a, b, c, rest]", object.New([]interface{}{1, 2, 3, []interface{}{4, 5}})},
+		{"let [a, b, ...rest] = [1]; [a, b, len(rest)]", object.New([]interface{}{1, nil, 0})},
+		{`let {name, "age": age, inner: {x}, missing} = {"name": "bob", "age": 3, "inner": {"x": 4}}; [name, age, x, missing]`, object.New([]interface{}{"bob", 3, 4, nil})},
+		{"let f = fn() { let [a, b] = [1, 2]; a + b }; f()", object.New(3)},
+		{`let f = fn(a, [b, c], {d}) { a + b + c + d }; f(1, [2, 3], {"d": 4})`, object.New(10)},
+		{"let a = 1; let b = 2; [a, b] = [b, a]; [a, b]", object.New([]interface{}{2, 1})},
+		{`let h = {}; let a = [0]; {"x": h.x, "y": a[0]} = {"x": 1, "y": 2}; [h["x"], a[0]]`, object.New([]interface{}{1, 2})},
+		{"let f = fn(a, b = 10) { a + b }; [f(1), f(1, 2)]", object.New([]interface{}{11, 3})},
+		{"let f = fn(x, y = x * 2) { y }; f(3)", object.New(6)},
+		{"let f = fn(a, ...rest) { [a, len(rest)] }; [f(1), f(1, 2, 3)]", object.New([]interface{}{[]interface{}{1, 0}, []interface{}{1, 2}})},
+		{"let f = fn(a = 1, ...rest) { [a, rest] }; f(5, 6)", object.New([]interface{}{5, []interface{}{6}})},
+		{"let f = fn([a, b] = [1, 2]) { a + b }; f()", object.New(3)},
+		{"let xs = [2, 3]; [1, ...xs, 4, ...[5]]", object.New([]interface{}{1, 2, 3, 4, 5})},
+		{"let f = fn(a, b, c) { a * 100 + b * 10 + c }; let xs = [2, 3]; f(1, ...xs)", object.New(123)},
+		{`len(...["abc"])`, object.New(3)},
+		{"let h = 0; let t = 0; [h, ...t] = [1, 2, 3]; t", object.New([]interface{}{2, 3})},
+		{"let x = 0; try { x = 1 / 0 } catch (e) { x = [type(e), e.message] }; x", object.New([]interface{}{"ERROR", "division by zero"})},
+		{"let x = 0; try { throw 42 } catch (e) { x = e }; x", object.New(42)},
+		{"let x = 0; try { [][1] } catch { x = 1 }; x", object.New(1)},
+		{`let m = ""; try { first([]) } catch (e) { m = e["message"] }; m`, object.New("first: cannot get first element of empty array")},
+		{`let f = fn() { throw "x" }; let g = fn() { f() + 1 }; let r = 0; try { g() } catch (e) { r = e }; r`, object.New("x")},
+		{"let f = fn() { let a = 1; try { a = 1 / 0 } catch (e) { a = 2 }; a * 10 }; f() + f()", object.New(40)},
+		{"let log = []; try { append(log, 1) } finally { append(log, 2) }; log", object.New([]interface{}{1, 2})},
+		{`let log = []; let f = fn() { try { return 1 } finally { append(log, "f") } }; [f(), log]`, object.New([]interface{}{1, []interface{}{"f"}})},
+		{"let f = fn() { try { return 1 } finally { return 2 } }; f()", object.New(2)},
+		{"let n = 0; while true { try { break } finally { n += 1 } }; n", object.New(1)},
+		{"let n = 0; for x in [1, 2, 3] { try { if x == 2 { continue } } finally { n += x } }; n", object.New(6)},
+		{`let log = []; try { try { throw 1 } catch (e) { throw e + 1 } finally { append(log, "f") } } catch (e) { append(log, e) }; log`, object.New([]interface{}{"f", 2})},
+		{`let log = []; try { try { 1 / 0 } finally { append(log, "f") } } catch (e) { append(log, e.message) }; log`, object.New([]interface{}{"f", "division by zero"})},
+		{"let f = fn() { try { 1 } catch (e) { 2 } }; f()", Null},
+		{"let log = []; let f = fn() { defer append(log, 1); defer append(log, 2); append(log, 0) }; f(); log", object.New([]interface{}{0, 2, 1})},
+		{"let log = []; let f = fn(x) { defer append(log, x); x = 2; return x }; [f(1), log]", object.New([]interface{}{2, []interface{}{1}})},
+		{"let log = []; let f = fn() { defer append(log, 1); throw 2 }; try { f() } catch (e) { append(log, e) }; log", object.New([]interface{}{1, 2})},
+		{"let log = []; let f = fn() { for x in [1, 2] { defer append(log, x) }; defer fn() { append(log, 0) }() }; f(); log", object.New([]interface{}{0, 2, 1})},
+		{"let f = fn() { defer fn() { throw 2 }(); 1 }; let r = 0; try { f() } catch (e) { r = e }; r", object.New(2)},
+		{"let log = []; let g = fn(x) { defer append(log, x); x }; let f = fn() { defer g(1); defer g(2); g(3) }; [f(), log]", object.New([]interface{}{3, []interface{}{3, 2, 1}})},
+		{"let log = []; let f = fn() { defer fn() { append(log, 1) }(); defer fn() { throw 2 }(); defer fn() { append(log, 3) }() }; try { f() } catch (e) { append(log, e) }; log", object.New([]interface{}{3, 1, 2})},
+		{"let f = fn(g) { defer g?.(); 1 }; f(null)", object.New(1)},
 	}
 	for _, tt := range tests {
 		t.Run(tt.input, func(t *testing.T) {
@@ -88,3 +213,46 @@ func TestRun(t *testing.T) {
 		})
 	}
 }
+
+func TestRunError(t *testing.T) {
+	tests := []struct {
+		input   string
+		message string
+	}{
+		{"1 / 0", "1:1-1:6: division by zero"},
+		{`let x = {}; x.foo`, "1:13-1:18: property not found: foo"},
+		{`let x = {}; x.foo += 1`, "1:13-1:23: property not found: foo"},
+		{`[1].a`, "1:1-1:6: cannot access 'a' of ARRAY"},
+		{`let a = {}; a?.b.c`, "1:13-1:19: cannot access 'c' of NULL"},
+		{"1 % 0", "1:1-1:6: division by zero"},
+		{"1.5 / 0", "1:1-1:8: division by zero"},
+		{"1 << -1", "1:1-1:8: negative shift count"},
+		{"~1.5", "1:1-1:5: cannot use bitwise not on type: FLOAT"},
+		{"let a = [1]; a[3] = 1", "1:14-1:22: 3 not in range"},
+		{`"héllo"[-6]`, "1:1-1:12: -6 out of range"},
+		{"let f = fn(x) {\n  x / 0\n};\nf(1)", "2:3-2:8: division by zero"},
+		{"fn(x) { x }()", "1:1-1:14: wrong number of arguments: want 1, got 0"},
+		{"for x in true {}", "1:1-1:17: cannot iterate over BOOLEAN"},
+		{"let [a] = 1", "1:1-1:12: cannot destructure INTEGER as array"},
+		{"let {a} = [1]", "1:1-1:14: cannot destructure ARRAY as hash"},
+		{"fn(a, b = 1) { a }()", "1:1-1:21: wrong number of arguments: want at least 1, got 0"},
+		{"fn(a, b = 1) { a }(1, 2, 3)", "1:1-1:28: wrong number of arguments: want at most 2, got 3"},
+		{"[...1]", "1:1-1:7: cannot spread INTEGER"},
+		{"[1, 2][1:0]", "1:1-1:12: invalid slice indices: 1 > 0"},
+		{`throw "boom"`, `1:1-1:13: uncaught exception: "boom"`},
+		{"try { 1 / 0 } finally { 1 }", "1:7-1:12: division by zero"},
+		{"try { throw 1 } catch (e) { e / 0 }", "1:29-1:34: division by zero"},
+		{"try { 1 / 0 } catch (e) { throw e }", "1:27-1:34: division by zero"},
+		{"let f = fn() { defer len(1, 2); 1 }; f()", "1:16-1:32: len: wrong number of arguments"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			program, err := parser.Parse(tt.input)
+			assert.NilError(t, err)
+			bytecode, err := compiler.Compile(program)
+			assert.NilError(t, err)
+			vm := New(bytecode)
+			assert.Error(t, vm.Run(), tt.message)
+		})
+	}
+}